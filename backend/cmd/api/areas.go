@@ -0,0 +1,325 @@
+package main
+
+import (
+	"errors"
+	"inzynierka/internal/data"
+	"inzynierka/internal/data/validator"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// reads area and tag filter from query string, eg. ?area=<uuid>&tag=lights,ground-floor
+func (app *App) readGroupFilter(qs url.Values, v *validator.Validator) data.GroupFilter {
+	var filter data.GroupFilter
+
+	if areaStr := app.readString(qs, "area", ""); areaStr != "" {
+		areaId, err := uuid.Parse(areaStr)
+		if err != nil {
+			v.AddError("area", "must be a valid uuid")
+		} else {
+			filter.Area = &areaId
+		}
+	}
+
+	filter.Tags = app.readCSV(qs, "tag", nil)
+
+	return filter
+}
+
+// checks area sensor, rule or sequence is assigned to exists
+func (app *App) validateAreaReference(v *validator.Validator, areaId *uuid.UUID) error {
+	if areaId == nil {
+		return nil
+	}
+
+	_, err := app.models.Areas.Get(*areaId)
+	if err != nil {
+		if errors.Is(err, data.ErrRecordNotFound) {
+			v.AddError("area_id", "must reference existing area")
+			return nil
+		}
+		return err
+	}
+
+	return nil
+}
+
+// area id in update requests, nil uuid removes the area
+func areaUpdate(areaId uuid.UUID) *uuid.UUID {
+	if areaId == uuid.Nil {
+		return nil
+	}
+	return &areaId
+}
+
+// validates area against its parent and sub-areas, writes error response and returns false if the area is invalid
+func (app *App) validateArea(w http.ResponseWriter, r *http.Request, area *data.Area) bool {
+	areas, err := app.models.Areas.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	var parent *data.Area
+	children := []*data.Area{}
+
+	for _, other := range areas {
+		if area.ParentID != nil && other.ID == *area.ParentID {
+			parent = other
+		}
+		if area.ID != uuid.Nil && other.ParentID != nil && *other.ParentID == area.ID {
+			children = append(children, other)
+		}
+	}
+
+	v := validator.New()
+
+	if data.ValidateArea(v, area, parent, children); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	return true
+}
+
+func (app *App) createAreaHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name     string        `json:"name"`
+		Kind     data.AreaKind `json:"kind"`
+		ParentID *uuid.UUID    `json:"parent_id"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	area := &data.Area{
+		Name:     input.Name,
+		Kind:     input.Kind,
+		ParentID: input.ParentID,
+	}
+
+	if !app.validateArea(w, r, area) {
+		return
+	}
+
+	err = app.models.Areas.Insert(area)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"data": area}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *App) listAreasHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	filters := app.readFilters(r.URL.Query(), 0, v, "name", "id", "kind", "created_at")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	areas, metadata, err := app.models.Areas.GetAllAreas(filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": areas, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// reads area from id url param, writes error response and returns nil if it fails
+func (app *App) readAreaParam(w http.ResponseWriter, r *http.Request) *data.Area {
+	areaIdStr := chi.URLParam(r, "id")
+	areaId, err := uuid.Parse(areaIdStr)
+
+	if err != nil {
+		app.writeJSON(w, http.StatusBadRequest, envelope{"error": "not a valid uuid"}, nil)
+		return nil
+	}
+
+	area, err := app.models.Areas.Get(areaId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	return area
+}
+
+func (app *App) getAreaHandler(w http.ResponseWriter, r *http.Request) {
+	area := app.readAreaParam(w, r)
+	if area == nil {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"area": area}, versionHeaders(area.Version))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *App) updateAreaHandler(w http.ResponseWriter, r *http.Request) {
+	area := app.readAreaParam(w, r)
+	if area == nil {
+		return
+	}
+
+	var input struct {
+		Name *string        `json:"name"`
+		Kind *data.AreaKind `json:"kind"`
+		// nil uuid moves the area to the top level
+		ParentID *uuid.UUID `json:"parent_id"`
+		Version  *int       `json:"version"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if !app.versionMatches(w, r, area.Version, input.Version) {
+		return
+	}
+
+	if input.Name != nil {
+		area.Name = *input.Name
+	}
+	if input.Kind != nil {
+		area.Kind = *input.Kind
+	}
+	if input.ParentID != nil {
+		area.ParentID = areaUpdate(*input.ParentID)
+	}
+
+	if !app.validateArea(w, r, area) {
+		return
+	}
+
+	err = app.models.Areas.Update(area)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": area}, versionHeaders(area.Version))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *App) deleteAreaHandler(w http.ResponseWriter, r *http.Request) {
+	areaIdStr := chi.URLParam(r, "id")
+	areaId, err := uuid.Parse(areaIdStr)
+
+	if err != nil {
+		app.writeJSON(w, http.StatusBadRequest, envelope{"error": "not a valid uuid"}, nil)
+		return
+	}
+
+	err = app.models.Areas.Delete(areaId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrAreaNotEmpty):
+			v := validator.New()
+			v.AddError("id", "must not have sub-areas")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "area successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// writes the same value to every switch in the area (and its sub-areas) having all of the tags,
+// eg. turning off every binary switch in the living room
+func (app *App) setGroupValueHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Area  *uuid.UUID         `json:"area"`
+		Tags  []string           `json:"tags"`
+		Type  data.SensorType    `json:"type"`
+		Value data.SequenceValue `json:"value"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	filter := data.GroupFilter{Area: input.Area, Tags: input.Tags}
+
+	v := validator.New()
+	v.Check(!filter.IsEmpty(), "area", "must be provided when tags are missing")
+	v.Check(input.Type == "" || input.Type.IsWritable(), "type", "must be one of 'binary_switch', 'decimal_switch' or 'enum_switch'")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	sensors, err := app.models.Sensors.GetByGroup(filter)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	group := &data.Scene{Values: []data.SceneValue{}}
+
+	for _, sensor := range sensors {
+		if !sensor.Type.IsWritable() || (input.Type != "" && sensor.Type != input.Type) {
+			continue
+		}
+
+		data.ValidateSensorValue(v, "value", sensor, input.Value)
+		group.Values = append(group.Values, data.SceneValue{Sensor: sensor.ID, Value: input.Value})
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	_, failed := app.applyScene(group, 0, 0)
+
+	errs := make(map[uuid.UUID]string, len(failed))
+	for id, err := range failed {
+		errs[id] = err.Error()
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"applied": len(group.Values) - len(failed), "failed": errs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"inzynierka/internal/data"
+	"inzynierka/internal/data/validator"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const defaultAdoptedRefreshRate = 5
+
+func (app *App) runDiscovery() {
+	cfg := app.config.discovery
+
+	if cfg.announcePort != 0 {
+		go func() {
+			err := app.discovery.ListenAnnouncements(context.Background(), cfg.announcePort)
+			if err != nil {
+				app.logger.Error("discovery announcements", "port", cfg.announcePort, "error", err)
+			}
+		}()
+	}
+
+	if cfg.subnet == "" && !cfg.mdns {
+		return
+	}
+
+	for {
+		app.discoverDevices()
+		time.Sleep(cfg.interval)
+	}
+}
+
+// forgets devices which stopped answering and looks for new ones
+func (app *App) discoverDevices() {
+	ctx := context.Background()
+
+	if forgotten := app.discovery.Refresh(ctx); forgotten > 0 {
+		app.logger.Info("discovered devices gone", "count", forgotten)
+	}
+
+	if app.config.discovery.mdns {
+		if err := app.discovery.QueryMDNS(ctx); err != nil {
+			app.logger.Error("discovery mdns", "error", err)
+		}
+	}
+
+	if app.config.discovery.subnet != "" {
+		found, err := app.discovery.Scan(ctx)
+		if err != nil && !errors.Is(err, data.ErrScanInProgress) {
+			app.logger.Error("discovery scan", "subnet", app.config.discovery.subnet, "error", err)
+			return
+		}
+		app.logger.Debug("discovery scan finished", "subnet", app.config.discovery.subnet, "found", found)
+	}
+}
+
+// notifies everyone about devices which are not sensors yet
+func (app *App) notifyDeviceFound(device data.DiscoveredDevice) {
+	registered, err := app.models.Sensors.GetByURIs(data.TransportHTTP, []string{device.URI})
+	if err != nil {
+		app.logger.Error("notifyDeviceFound query", "uri", device.URI, "error", err)
+		return
+	}
+
+	if len(registered) > 0 {
+		return
+	}
+
+	name := device.URI
+	if device.Name != "" {
+		name = fmt.Sprintf("%s (%s)", device.Name, device.URI)
+	}
+
+	_ = app.sendNotificationToAll("New device found", fmt.Sprintf("%s %s can be added as a sensor", device.Type, name), data.NotificationLevelInfo)
+}
+
+// found devices which are not registered as sensors
+func (app *App) unregisteredDevices() ([]data.DiscoveredDevice, error) {
+	devices := app.discovery.Devices()
+
+	uris := make([]string, len(devices))
+	for i, device := range devices {
+		uris[i] = device.URI
+	}
+
+	registered, err := app.models.Sensors.GetByURIs(data.TransportHTTP, uris)
+	if err != nil {
+		return nil, err
+	}
+
+	taken := make(map[string]bool, len(registered))
+	for _, sensor := range registered {
+		taken[sensor.URI] = true
+	}
+
+	unregistered := []data.DiscoveredDevice{}
+	for _, device := range devices {
+		if !taken[device.URI] {
+			unregistered = append(unregistered, device)
+		}
+	}
+
+	return unregistered, nil
+}
+
+func (app *App) listDiscoveredDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	devices, err := app.unregisteredDevices()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": devices, "scanning": app.discovery.Scanning()}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// starts discovery round in background, found devices are listed by listDiscoveredDevicesHandler
+func (app *App) scanDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	if app.config.discovery.subnet == "" && !app.config.discovery.mdns {
+		app.errorResponse(w, r, http.StatusConflict, "device discovery is not configured")
+		return
+	}
+
+	if app.discovery.Scanning() {
+		app.errorResponse(w, r, http.StatusConflict, "scan already in progress")
+		return
+	}
+
+	go app.discoverDevices()
+
+	err := app.writeJSON(w, http.StatusAccepted, envelope{"message": "scan started"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// creates http sensor of the type reported by discovered device
+func (app *App) adoptDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		URI         string     `json:"uri"`
+		Name        string     `json:"name"`
+		Hidden      bool       `json:"hidden"`
+		RefreshRate int        `json:"refresh_rate"`
+		Active      bool       `json:"active"`
+		Unit        string     `json:"unit"`
+		Options     []string   `json:"options"`
+		Icon        string     `json:"icon"`
+		AreaID      *uuid.UUID `json:"area_id"`
+		Tags        []string   `json:"tags"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	device, ok := app.discovery.Device(input.URI)
+	if !ok {
+		v := validator.New()
+		v.AddError("uri", "must be a discovered device")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	sensor := &data.Sensor{
+		Name:        input.Name,
+		URI:         device.URI,
+		Type:        device.Type,
+		Hidden:      input.Hidden,
+		RefreshRate: input.RefreshRate,
+		Active:      input.Active,
+		Transport:   data.TransportHTTP,
+		Unit:        input.Unit,
+		Options:     input.Options,
+		Icon:        input.Icon,
+		AreaID:      input.AreaID,
+		Tags:        input.Tags,
+	}
+
+	if sensor.Name == "" {
+		sensor.Name = device.Name
+	}
+	if sensor.Name == "" {
+		sensor.Name = device.URI
+	}
+	if sensor.RefreshRate == 0 && !sensor.Active {
+		sensor.RefreshRate = defaultAdoptedRefreshRate
+	}
+
+	app.createSensor(w, r, sensor)
+}
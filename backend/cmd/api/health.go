@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"inzynierka/internal/data"
+	"sync"
+	"time"
+)
+
+func (app *App) runHealthChecks() {
+	for {
+		time.Sleep(app.config.health.interval)
+		app.checkSensorHealth()
+	}
+}
+
+// polled sensors are checked by their listeners, active ones are asked for their status
+// or, when their transport can not do that, checked for values they should have pushed
+func (app *App) checkSensorHealth() {
+	sensors, err := app.models.Sensors.GetAll()
+	if err != nil {
+		app.logger.Error("checkSensorHealth query", "error", err)
+		return
+	}
+
+	now := time.Now()
+	var wg sync.WaitGroup
+
+	for _, sensor := range sensors {
+		if !sensor.Active {
+			continue
+		}
+
+		transport, err := app.transports.For(sensor)
+		if err != nil {
+			continue
+		}
+
+		checker, ok := transport.(data.StatusChecker)
+		if !ok {
+			app.health.CheckSilence(sensor.ID, now)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.checkSensorStatus(sensor, checker)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (app *App) checkSensorStatus(sensor *data.Sensor, checker data.StatusChecker) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	if err := checker.Status(ctx, sensor); err != nil {
+		app.health.Failure(sensor.ID, err)
+		return
+	}
+
+	app.health.Success(sensor.ID, time.Since(start))
+}
+
+// publishes the change to websocket clients and notifies everyone when sensor goes offline or comes back
+func (app *App) sensorHealthChanged(change data.HealthChange) {
+	app.healthBroker.Publish(change)
+
+	// sensor heard from for the first time is not worth a notification
+	if change.Previous == data.HealthUnknown && change.Health.Status == data.HealthOnline {
+		return
+	}
+
+	sensor, err := app.models.Sensors.Get(change.SensorID)
+	if err != nil {
+		app.logger.Error("sensorHealthChanged query", "sensor", change.SensorID, "error", err)
+		return
+	}
+
+	switch change.Health.Status {
+	case data.HealthOffline:
+		app.logger.Warn("sensor offline", "sensor", sensor.ID, "error", change.Health.LastError)
+		_ = app.sendNotificationToAll("Sensor offline", fmt.Sprintf("%s is not responding: %s", sensor.Name, change.Health.LastError), data.NotificationLevelWarning)
+	case data.HealthOnline:
+		app.logger.Info("sensor back online", "sensor", sensor.ID)
+		_ = app.sendNotificationToAll("Sensor back online", fmt.Sprintf("%s is responding again", sensor.Name), data.NotificationLevelSuccess)
+	}
+}
@@ -4,7 +4,8 @@ import "net/http"
 
 func (app *App) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
 	data := envelope{
-		"status": "available",
+		"status":             "available",
+		"measurement_writer": app.measurementWriter.Stats(),
 	}
 
 	err := app.writeJSON(w, http.StatusOK, data, nil)
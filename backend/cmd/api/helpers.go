@@ -1,7 +1,7 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +10,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -19,6 +20,30 @@ import (
 
 type envelope map[string]any
 
+// nullable is optional field of update requests which can also be reset with null,
+// Set tells it apart from the field not being provided at all
+type nullable[T any] struct {
+	Set   bool
+	Value *T
+}
+
+func (n *nullable[T]) UnmarshalJSON(data []byte) error {
+	n.Set = true
+
+	if string(data) == "null" {
+		n.Value = nil
+		return nil
+	}
+
+	var value T
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	n.Value = &value
+	return nil
+}
+
 func (app *App) writeJSON(w http.ResponseWriter, status int, data envelope, headers http.Header) error {
 	js, err := json.Marshal(data)
 	if err != nil {
@@ -125,14 +150,206 @@ func (app *App) readInt(qs url.Values, key string, defaulValue int, v *validator
 	return i
 }
 
+func (app *App) readTime(qs url.Values, key string, defaultValue time.Time, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be valid RFC3339 timestamp")
+		return defaultValue
+	}
+
+	return t
+}
+
+func (app *App) readDuration(qs url.Values, key string, defaultValue time.Duration, v *validator.Validator) time.Duration {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		v.AddError(key, "must be valid duration (eg. 5m, 1h)")
+		return defaultValue
+	}
+
+	return d
+}
+
+// ETag of versioned records is their version, updates can be made conditional on it with If-Match header
+func versionETag(version int) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+// headers of responses with versioned record
+func versionHeaders(version int) http.Header {
+	headers := make(http.Header)
+	headers.Set("ETag", versionETag(version))
+	return headers
+}
+
+// reads version from If-Match header (either "3" or W/"3"), nil when it is missing or matches any version
+func (app *App) readIfMatch(r *http.Request) (*int, error) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+
+	if ifMatch == "" || ifMatch == "*" {
+		return nil, nil
+	}
+
+	tag := strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`)
+
+	version, err := strconv.Atoi(tag)
+	if err != nil {
+		return nil, fmt.Errorf("If-Match header must be ETag of the record, got %s", ifMatch)
+	}
+
+	return &version, nil
+}
+
+// checks version client expects the record to have (from If-Match header, or version field of the body)
+// against its current version. Writes error response and returns false if they differ, requests without
+// expected version are only protected against changes made while they are processed
+func (app *App) versionMatches(w http.ResponseWriter, r *http.Request, current int, bodyVersion *int) bool {
+	expected, err := app.readIfMatch(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return false
+	}
+
+	if expected == nil {
+		expected = bodyVersion
+	}
+
+	if expected != nil && *expected != current {
+		app.editConflictResponse(w, r)
+		return false
+	}
+
+	return true
+}
+
+// reads optional boolean, nil when it is missing
+func (app *App) readBool(qs url.Values, key string, v *validator.Validator) *bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return nil
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean")
+		return nil
+	}
+
+	return &b
+}
+
+// reads page, page_size, sort and search of list handlers, sorting by the first of sortable columns by default.
+// Every column can also be sorted in descending order by prefixing it with -
+func (app *App) readFilters(qs url.Values, defaultPageSize int, v *validator.Validator, sortable ...string) data.Filters {
+	filters := data.Filters{
+		Page:     app.readInt(qs, "page", 1, v),
+		PageSize: app.readInt(qs, "page_size", defaultPageSize, v),
+		Sort:     app.readString(qs, "sort", sortable[0]),
+		Search:   app.readString(qs, "search", ""),
+	}
+
+	for _, column := range sortable {
+		filters.SortSafelist = append(filters.SortSafelist, strings.TrimPrefix(column, "-"), "-"+strings.TrimPrefix(column, "-"))
+	}
+
+	data.ValidateFilters(v, filters)
+
+	return filters
+}
+
 // function creates a new listener, adds it to app module and depending on sensor active flag starts it or just starts broker
 func (app *App) setupSensorListener(sensor *data.Sensor) {
-	listener := app.createAndAddSensorListener(sensor)
-	if sensor.Active {
-		go listener.Broker.Start()
-	} else {
-		go listener.Start()
+	transport, err := app.transports.For(sensor)
+	if err != nil {
+		app.logger.Warn("sensor will not receive values", "sensor", sensor.ID, "error", err)
+	}
+
+	listener := app.createAndAddSensorListener(sensor, transport)
+	if err = listener.Start(); err != nil && transport != nil {
+		app.logger.Error("starting sensor listener", "sensor", sensor.ID, "error", err)
+	}
+
+	app.restartVirtualDependents(sensor.ID)
+}
+
+// virtual sensors stay subscribed to the listener they started with,
+// so they are restarted whenever listener of their source is replaced or removed
+func (app *App) restartVirtualDependents(sensorId uuid.UUID) {
+	dependents := []*data.Sensor{}
+
+	for _, listener := range app.listeners.All() {
+		if slices.Contains(data.VirtualSources(listener.GetSensor()), sensorId) {
+			dependents = append(dependents, listener.GetSensor())
+		}
+	}
+
+	for _, dependent := range dependents {
+		app.stopAndDeleteSensorListener(dependent.ID)
+		app.setupSensorListener(dependent)
+	}
+}
+
+// checks sources of virtual sensor exist and do not depend on the sensor, directly or through other virtual sensors
+func (app *App) validateVirtualSources(v *validator.Validator, sensor *data.Sensor) error {
+	visited := map[uuid.UUID]struct{}{}
+	pending := data.VirtualSources(sensor)
+
+	for len(pending) > 0 {
+		id := pending[0]
+		pending = pending[1:]
+
+		if _, ok := visited[id]; ok {
+			continue
+		}
+		visited[id] = struct{}{}
+
+		if id == sensor.ID {
+			v.AddError("uri", "must not depend on the sensor itself through other virtual sensors")
+			return nil
+		}
+
+		source, err := app.models.Sensors.Get(id)
+		if err != nil {
+			if errors.Is(err, data.ErrRecordNotFound) {
+				v.AddError("uri", fmt.Sprintf("must reference existing sensors, %s does not exist", id))
+				return nil
+			}
+			return err
+		}
+
+		// expressions are computed over numbers
+		if source.Type.ValueKind().IsText() {
+			v.AddError("uri", fmt.Sprintf("must reference numeric sensors, %s is an enum or text sensor", id))
+			return nil
+		}
+
+		pending = append(pending, data.VirtualSources(source)...)
 	}
+
+	return nil
+}
+
+// passes value pushed by the sensor to server endpoint (instead of being polled) to its listener
+func (app *App) deliverSensorValue(transport data.SensorTransport, id uuid.UUID, value data.SensorValue, at time.Time) error {
+	pushTransport, ok := app.transports[transport].(data.PushTransport)
+	if !ok {
+		return fmt.Errorf("%w: %s", data.ErrTransportUnavailable, transport)
+	}
+
+	return pushTransport.Deliver(id, value, at)
 }
 
 func (app *App) sendNotificationToAll(title, description string, level data.NotificationLevel) error {
@@ -162,30 +379,65 @@ func (app *App) sendNotificationToAll(title, description string, level data.Noti
 }
 
 // creates and adds a sensor listener to map in app module and returns pointer to it
-func (app *App) createAndAddSensorListener(sensor *data.Sensor) (listener *data.Listener[float64]) {
-	onNewValue := func(value float64) {
-		measuserment := data.SensorMeasurement{
-			SensorID:      sensor.ID,
-			MeasuredAt:    time.Now(),
-			MeasuredValue: value,
+func (app *App) createAndAddSensorListener(sensor *data.Sensor, transport data.Transport) (listener *data.Listener[data.SensorValue]) {
+	detector := app.newAnomalyDetector(sensor)
+
+	onNewValue := func(value, raw data.SensorValue, at time.Time) error {
+		// detection is never enabled for text sensors
+		if detector != nil {
+			for _, anomaly := range detector.Check(value.Number, at) {
+				go app.reportAnomaly(sensor, anomaly)
+			}
 		}
 
-		if err := app.models.SensorMeasurements.Insert(&measuserment); err != nil {
-			app.logger.Error("Writing measurement to DB", "error", err)
-		}
+		return app.measurementWriter.Write(data.NewSensorMeasurement(sensor, value, raw, at))
 	}
 
-	l := data.NewListener[float64](sensor, onNewValue)
-	app.listeners[sensor.ID] = l
+	l := data.NewListener[data.SensorValue](sensor, transport, app.health, onNewValue)
+
+	// listener of the same sensor set up concurrently (e.g. by ingest and sensor update) would keep running unreachable
+	if replaced, ok := app.listeners.Set(sensor.ID, l); ok && replaced != l {
+		replaced.Stop()
+	}
 	return l
 }
 
-func (app *App) stopAndDeleteSensorListener(sensorId uuid.UUID) {
-	if l, ok := app.listeners[sensorId]; ok {
-		l.GetStopCh() <- struct{}{}
+// returns detector of sensor anomalies seeded with its recent measurements, nil if detection is disabled
+func (app *App) newAnomalyDetector(sensor *data.Sensor) *data.AnomalyDetector {
+	if !sensor.Anomaly.Enabled() {
+		return nil
+	}
+
+	detector := data.NewAnomalyDetector(*sensor.Anomaly)
+
+	measurements, err := app.models.SensorMeasurements.GetLastNMeasurements(sensor.ID, sensor.Anomaly.WindowSize())
+	if err != nil {
+		// detection still works, z-score checks just start once the window fills up
+		app.logger.Warn("seeding anomaly detector", "sensor", sensor.ID, "error", err)
+	} else {
+		detector.Seed(measurements)
 	}
 
-	delete(app.listeners, sensorId)
+	return detector
+}
+
+func (app *App) reportAnomaly(sensor *data.Sensor, anomaly data.Anomaly) {
+	app.logger.Warn("sensor anomaly", "sensor", sensor.ID, "kind", anomaly.Kind, "details", anomaly.Details)
+	app.metrics.sensorAnomalies.WithLabelValues(sensor.ID.String(), string(anomaly.Kind)).Inc()
+
+	titles := map[data.AnomalyKind]string{
+		data.AnomalyZScore:     "Unusual sensor value",
+		data.AnomalyStuck:      "Sensor value stuck",
+		data.AnomalyOutOfRange: "Sensor value out of range",
+	}
+
+	_ = app.sendNotificationToAll(titles[anomaly.Kind], fmt.Sprintf("%s: %s", sensor.Name, anomaly.Details), data.NotificationLevelWarning)
+}
+
+func (app *App) stopAndDeleteSensorListener(sensorId uuid.UUID) {
+	if l, ok := app.listeners.Remove(sensorId); ok {
+		l.Stop()
+	}
 }
 
 func (app *App) startRule(rule *data.Rule) {
@@ -202,18 +454,24 @@ func (app *App) stopRule(ruleId uuid.UUID) {
 	delete(app.rules.stopChannels, ruleId)
 }
 
-func (app *App) sendValue(url string, body *bytes.Buffer) error {
-	req, err := http.NewRequest(http.MethodPut, url, body)
+// writes value to the sensor over its transport
+func (app *App) writeSensorValue(sensor *data.Sensor, value data.SequenceValue) error {
+	// range and options could have changed since sequence or scene writing the value was validated
+	sensorValue, err := sensor.Coerce(value.SensorValue())
 	if err != nil {
-		app.logger.Error("handleRuleRequests request creation", "error", err.Error())
 		return err
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	_, err = app.client.Do(req)
+	if !sensorValue.IsText() {
+		if err := sensor.CheckValue(sensorValue.Number); err != nil {
+			return err
+		}
+	}
+
+	transport, err := app.transports.For(sensor)
 	if err != nil {
-		app.logger.Error("handleRuleRequests request", "error", err.Error())
+		return err
 	}
 
-	return nil
+	return transport.Write(context.Background(), sensor, sensorValue)
 }
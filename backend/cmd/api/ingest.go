@@ -0,0 +1,199 @@
+package main
+
+import (
+	"compress/gzip"
+	"errors"
+	"inzynierka/internal/data"
+	"inzynierka/internal/data/validator"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// limit of a single line protocol batch, also applied after decompression
+const maxIngestBytes = 16 << 20
+
+// accepts InfluxDB line protocol batches (compatible with InfluxDB 1.x /write endpoint, eg. Telegraf influxdb output).
+// Every numeric field is mapped onto line protocol sensor with matching series key
+func (app *App) writeLineProtocolHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	precision, ok := data.LineProtocolPrecisions[app.readString(r.URL.Query(), "precision", "ns")]
+	v.Check(ok, "precision", "must be one of 'ns', 'us', 'ms', 's', 'm' or 'h'")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	var body io.ReadCloser = http.MaxBytesReader(w, r.Body, maxIngestBytes)
+
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		defer gz.Close()
+
+		body = http.MaxBytesReader(w, gz, maxIngestBytes)
+	}
+
+	reader := data.NewLineProtocolReader(body, precision, time.Now())
+	points := []*data.LineProtocolPoint{}
+
+	for {
+		point, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			switch {
+			case errors.As(err, &maxBytesError):
+				app.errorResponse(w, r, http.StatusRequestEntityTooLarge, "body must not be larger than 16MB")
+			default:
+				app.badRequestResponse(w, r, err)
+			}
+			return
+		}
+
+		points = append(points, point)
+	}
+
+	sensors, err := app.lineProtocolSensors(points)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	dropped := 0
+
+	for _, point := range points {
+		sensor, ok := sensors[point.SeriesKey()]
+		if !ok {
+			dropped++
+			continue
+		}
+
+		err = app.deliverSensorValue(data.TransportLineProtocol, sensor.ID, point.SensorValue(), point.Time)
+		if errors.Is(err, data.ErrInvalidSensorValue) {
+			app.logger.Warn("line protocol value rejected", "sensor", sensor.ID, "error", err)
+			dropped++
+			continue
+		}
+		if err != nil {
+			app.logger.Error("Queueing measurement", "sensor", sensor.ID, "error", err)
+			// client should retry later, when the queue has drained
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	if dropped > 0 {
+		app.logger.Warn("line protocol points of unknown series or with invalid values dropped", "dropped", dropped)
+	}
+
+	app.logger.Debug("line protocol write", "points", len(points), "skipped strings", reader.Skipped)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// maps series keys of points onto their sensors, unknown series get new hidden sensors
+// if auto creation is enabled, otherwise they are left out
+func (app *App) lineProtocolSensors(points []*data.LineProtocolPoint) (map[string]*data.Sensor, error) {
+	series := make(map[string]*data.LineProtocolPoint)
+	for _, point := range points {
+		if _, ok := series[point.SeriesKey()]; !ok {
+			series[point.SeriesKey()] = point
+		}
+	}
+
+	sensors := make(map[string]*data.Sensor, len(series))
+	if len(series) == 0 {
+		return sensors, nil
+	}
+
+	uris := make([]string, 0, len(series))
+	for key := range series {
+		uris = append(uris, key)
+	}
+
+	existing, err := app.models.Sensors.GetByURIs(data.TransportLineProtocol, uris)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sensor := range existing {
+		sensors[sensor.URI] = sensor
+	}
+
+	if !app.config.ingest.autoCreate {
+		return sensors, nil
+	}
+
+	for key, point := range series {
+		if _, ok := sensors[key]; ok {
+			continue
+		}
+
+		sensor, err := app.createLineProtocolSensor(key, point.IsBool)
+		if err != nil {
+			return nil, err
+		}
+		if sensor != nil {
+			sensors[key] = sensor
+		}
+	}
+
+	return sensors, nil
+}
+
+func (app *App) createLineProtocolSensor(key string, isBool bool) (*data.Sensor, error) {
+	name := key
+	if len(name) > 255 {
+		name = strings.ToValidUTF8(name[:255], "")
+	}
+
+	sensor := &data.Sensor{
+		Name:      name,
+		URI:       key,
+		Type:      data.DecimalSensor,
+		Hidden:    true,
+		Active:    true,
+		Transport: data.TransportLineProtocol,
+	}
+
+	if isBool {
+		sensor.Type = data.BinarySensor
+	}
+
+	v := validator.New()
+	if data.ValidateSensor(v, sensor); !v.Valid() {
+		app.logger.Warn("line protocol series can not be used as sensor", "series", key, "errors", v.Errors)
+		return nil, nil
+	}
+
+	err := app.models.Sensors.Insert(sensor)
+	if err != nil {
+		if !errors.Is(err, data.ErrDuplicateUri) {
+			return nil, err
+		}
+
+		// created by concurrent write in the meantime
+		existing, err := app.models.Sensors.GetByURIs(data.TransportLineProtocol, []string{key})
+		if err != nil || len(existing) == 0 {
+			return nil, err
+		}
+
+		return existing[0], nil
+	}
+
+	app.logger.Info("created line protocol sensor", "id", sensor.ID, "series", key)
+	// concurrent writes creating other series register their listeners at the same time, app.listeners is safe for that
+	app.setupSensorListener(sensor)
+
+	return sensor, nil
+}
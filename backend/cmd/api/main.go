@@ -11,6 +11,7 @@ import (
 
 	"github.com/charmbracelet/log"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Config struct {
@@ -25,6 +26,40 @@ type Config struct {
 	cors struct {
 		trustedOrigins []string
 	}
+	metrics struct {
+		token string
+	}
+	ingest struct {
+		token      string
+		autoCreate bool
+	}
+	mqtt struct {
+		broker   string
+		clientID string
+		username string
+		password string
+		qos      int
+	}
+	measurements struct {
+		rollupInterval  time.Duration
+		minuteRetention time.Duration
+		writer          data.MeasurementWriterConfig
+	}
+	discovery struct {
+		subnet       string
+		ports        []int
+		interval     time.Duration
+		announcePort int
+		mdns         bool
+	}
+	schedules struct {
+		timezone string
+	}
+	health struct {
+		interval         time.Duration
+		failureThreshold int
+		silenceTimeout   time.Duration
+	}
 }
 
 type Settings struct {
@@ -34,16 +69,30 @@ type Settings struct {
 type App struct {
 	config     Config
 	logger     *log.Logger
+	db         *pgxpool.Pool
 	models     data.Models
-	listeners  data.SensorListeners
+	listeners  *data.SensorListeners
 	initBuffer data.SensorInitBuffer
 	rules      struct {
 		channel      chan data.ValidRuleAction
 		stopChannels map[uuid.UUID]chan struct{}
 	}
+	schedules struct {
+		reloadCh chan struct{}
+	}
 	notificationBroker *broker.Broker[data.UserNotification]
-	client             *http.Client
-	settings           Settings
+	measurementWriter  *data.MeasurementBatchWriter
+	// nil when no broker is configured
+	mqtt     *data.MQTTClient
+	metrics  *appMetrics
+	client   *http.Client
+	settings Settings
+	// keyed by data.SensorTransport, mqtt is only present when broker is configured
+	transports data.Transports
+	discovery  *data.Discovery
+	health     *data.HealthTracker
+	// status changes of sensor health, sent to websocket clients
+	healthBroker *broker.Broker[data.HealthChange]
 }
 
 func main() {
@@ -53,6 +102,34 @@ func main() {
 	flag.StringVar(&cfg.db.dsn, "db-dsn", os.Getenv("DATABASE_URL"), "Database DSN")
 	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
 	flag.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time")
+	flag.DurationVar(&cfg.measurements.rollupInterval, "measurements-rollup-interval", 15*time.Minute, "How often old measurements are rolled up into aggregates")
+	flag.DurationVar(&cfg.measurements.minuteRetention, "measurements-minute-retention", 90*24*time.Hour, "How long 1 minute aggregates are kept before rolling them up into 1 hour ones")
+	flag.IntVar(&cfg.measurements.writer.QueueSize, "measurements-queue-size", 10000, "Measurements waiting to be written to the database")
+	flag.IntVar(&cfg.measurements.writer.BatchSize, "measurements-batch-size", 500, "Measurements written to the database at once")
+	flag.DurationVar(&cfg.measurements.writer.FlushInterval, "measurements-flush-interval", time.Second, "Maximum time measurements wait for their batch to fill up")
+	flag.DurationVar(&cfg.measurements.writer.EnqueueTimeout, "measurements-enqueue-timeout", 100*time.Millisecond, "How long new measurement waits for space in full queue before it is dropped")
+	flag.StringVar(&cfg.metrics.token, "metrics-token", os.Getenv("METRICS_TOKEN"), "Bearer token required by /metrics endpoint (signed in user required if empty)")
+	flag.StringVar(&cfg.ingest.token, "ingest-token", os.Getenv("INGEST_TOKEN"), "Token accepted by line protocol write endpoint (only admins can write if empty)")
+	flag.BoolVar(&cfg.ingest.autoCreate, "ingest-auto-create", true, "Create hidden sensors for unknown line protocol series")
+	flag.StringVar(&cfg.mqtt.broker, "mqtt-broker", os.Getenv("MQTT_BROKER"), "MQTT broker address, eg. tcp://localhost:1883 (mqtt sensors are disabled if empty)")
+	flag.StringVar(&cfg.mqtt.clientID, "mqtt-client-id", "household", "MQTT client id")
+	flag.StringVar(&cfg.mqtt.username, "mqtt-username", os.Getenv("MQTT_USERNAME"), "MQTT username")
+	flag.StringVar(&cfg.mqtt.password, "mqtt-password", os.Getenv("MQTT_PASSWORD"), "MQTT password")
+	flag.IntVar(&cfg.mqtt.qos, "mqtt-qos", 1, "MQTT quality of service of subscriptions and commands (0, 1 or 2)")
+	flag.StringVar(&cfg.discovery.subnet, "discovery-subnet", os.Getenv("DISCOVERY_SUBNET"), "Subnet scanned for devices, eg. 192.168.1.0/24 (no scanning if empty)")
+	flag.DurationVar(&cfg.discovery.interval, "discovery-interval", 10*time.Minute, "Time between device discovery rounds")
+	flag.IntVar(&cfg.discovery.announcePort, "discovery-announce-port", 0, "UDP port devices broadcast announcements to (not listening if 0)")
+	flag.BoolVar(&cfg.discovery.mdns, "discovery-mdns", false, "Browse mDNS for devices advertising "+data.MDNSService)
+	cfg.discovery.ports = []int{80}
+	flag.Func("discovery-ports", "Ports probed on every host of discovery subnet, eg. 80,9000-9010 (default 80)", func(val string) error {
+		ports, err := data.ParsePorts(val)
+		cfg.discovery.ports = ports
+		return err
+	})
+	flag.StringVar(&cfg.schedules.timezone, "schedule-timezone", os.Getenv("TZ"), "IANA time zone of schedules created without one, eg. Europe/Warsaw (UTC if empty)")
+	flag.DurationVar(&cfg.health.interval, "health-interval", 30*time.Second, "How often active sensors are checked")
+	flag.IntVar(&cfg.health.failureThreshold, "health-failure-threshold", 3, "Failed checks in a row after which sensor is offline")
+	flag.DurationVar(&cfg.health.silenceTimeout, "health-silence-timeout", 10*time.Minute, "How long sensor which can not be asked for its status may not push values before its check fails")
 	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated) (eg. http://localhost:5173)", func(val string) error {
 		cfg.cors.trustedOrigins = strings.Fields(val)
 		return nil
@@ -79,14 +156,27 @@ func main() {
 	httpClient := &http.Client{}
 	httpClient.Timeout = 5 * time.Second
 
+	models := data.NewModels(db)
+
+	listeners := data.NewSensorListeners()
+
 	app := App{
 		logger:             logger,
 		config:             cfg,
-		models:             data.NewModels(db),
-		listeners:          make(data.SensorListeners),
+		db:                 db,
+		models:             models,
+		listeners:          listeners,
 		initBuffer:         make(data.SensorInitBuffer),
 		client:             httpClient,
 		notificationBroker: broker.NewBroker[data.UserNotification](),
+		measurementWriter:  data.NewMeasurementBatchWriter(models.SensorMeasurements.InsertBatch, cfg.measurements.writer),
+		metrics:            newAppMetrics(),
+		healthBroker:       broker.NewBroker[data.HealthChange](),
+		transports: data.Transports{
+			data.TransportHTTP:         data.NewHTTPTransport(httpClient),
+			data.TransportLineProtocol: data.NewLineProtocolTransport(),
+			data.TransportVirtual:      data.NewVirtualTransport(listeners),
+		},
 		rules: struct {
 			channel      chan data.ValidRuleAction
 			stopChannels map[uuid.UUID]chan struct{}
@@ -94,8 +184,51 @@ func main() {
 			channel:      make(chan data.ValidRuleAction, 1),
 			stopChannels: make(map[uuid.UUID]chan struct{}),
 		},
+		schedules: struct {
+			reloadCh chan struct{}
+		}{
+			reloadCh: make(chan struct{}, 1),
+		},
+	}
+
+	if cfg.mqtt.broker != "" {
+		if cfg.mqtt.qos < 0 || cfg.mqtt.qos > 2 {
+			logger.Error("mqtt qos must be 0, 1 or 2")
+			os.Exit(1)
+		}
+
+		app.mqtt = data.NewMQTTClient(data.MQTTConfig{
+			Broker:   cfg.mqtt.broker,
+			ClientID: cfg.mqtt.clientID,
+			Username: cfg.mqtt.username,
+			Password: cfg.mqtt.password,
+			QoS:      byte(cfg.mqtt.qos),
+		})
+		app.transports[data.TransportMQTT] = data.NewMQTTTransport(app.mqtt)
 	}
 
+	if _, err := time.LoadLocation(cfg.schedules.timezone); err != nil {
+		logger.Error("invalid schedule time zone", "error", err)
+		os.Exit(1)
+	}
+
+	if cfg.discovery.subnet != "" {
+		if _, err := data.SubnetHosts(cfg.discovery.subnet); err != nil {
+			logger.Error("invalid discovery subnet", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	app.discovery = data.NewDiscovery(data.DiscoveryConfig{
+		Subnet: cfg.discovery.subnet,
+		Ports:  cfg.discovery.ports,
+	}, httpClient, app.notifyDeviceFound)
+
+	app.health = data.NewHealthTracker(data.HealthConfig{
+		FailureThreshold: cfg.health.failureThreshold,
+		SilenceTimeout:   cfg.health.silenceTimeout,
+	}, app.sensorHealthChanged)
+
 	err = app.parseSettings()
 	if err != nil {
 		logger.Error(err.Error())
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"inzynierka/internal/data"
+	"inzynierka/internal/data/validator"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	// upper bound of buckets returned by a single request
+	maxMeasurementBuckets = 10000
+	// bucket size is chosen so that default requests return at most that many buckets
+	defaultMeasurementBuckets = 500
+	// exported rows are flushed to the client every that many rows
+	exportFlushRows = 1000
+	// upper bound of time spent on single export, it overrides write timeout of the server
+	exportTimeout = 10 * time.Minute
+	// upper bound of time spent on single import, it overrides read and write timeouts of the server
+	importTimeout  = 10 * time.Minute
+	maxImportBytes = 256 << 20
+)
+
+// bucket sizes tried when bucket is not given, smallest fitting one is used
+var measurementBucketSizes = []time.Duration{
+	time.Minute,
+	5 * time.Minute,
+	15 * time.Minute,
+	time.Hour,
+	6 * time.Hour,
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+}
+
+func defaultBucketSize(from, to time.Time) time.Duration {
+	for _, size := range measurementBucketSizes {
+		if to.Sub(from)/size <= defaultMeasurementBuckets {
+			return size
+		}
+	}
+
+	return measurementBucketSizes[len(measurementBucketSizes)-1]
+}
+
+func (app *App) sensorMeasurementsHandler(w http.ResponseWriter, r *http.Request) {
+	sensorIdStr := chi.URLParam(r, "id")
+	sensorId, err := uuid.Parse(sensorIdStr)
+
+	if err != nil {
+		app.writeJSON(w, http.StatusBadRequest, envelope{"error": "not a valid uuid"}, nil)
+		return
+	}
+
+	sensor, err := app.models.Sensors.Get(sensorId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	qs := r.URL.Query()
+	v := validator.New()
+
+	unit := app.readString(qs, "unit", sensor.Unit)
+	conversion, err := data.NewUnitConversion(sensor.Unit, unit)
+	v.Check(err == nil, "unit", "must be known unit of the same quantity as the sensor unit")
+
+	to := app.readTime(qs, "to", time.Now(), v)
+	from := app.readTime(qs, "from", to.Add(-24*time.Hour), v)
+	v.Check(from.Before(to), "from", "must be before to")
+
+	bucket := app.readDuration(qs, "bucket", defaultBucketSize(from, to), v)
+	v.Check(bucket >= time.Second, "bucket", "must be at least 1s")
+
+	if bucket >= time.Second {
+		v.Check(to.Sub(from)/bucket <= maxMeasurementBuckets, "bucket", "must not result in more than 10000 buckets")
+	}
+
+	aggs := make([]data.MeasurementAggregation, 0)
+	for _, s := range app.readCSV(qs, "agg", []string{string(data.AggregationAvg)}) {
+		agg := data.MeasurementAggregation(s)
+		v.Check(validator.PermittedValue(agg, data.MeasurementAggregations...), "agg", "must be one of avg, min, max, sum, count, last")
+		aggs = append(aggs, agg)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	buckets, err := app.models.SensorMeasurements.GetBuckets(sensorId, from, to, bucket)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	res := make([]map[string]any, 0, len(buckets))
+	for _, b := range buckets {
+		b.Convert(conversion)
+
+		point := map[string]any{"time": b.Time}
+		for _, agg := range aggs {
+			point[string(agg)] = b.Value(agg)
+		}
+		res = append(res, point)
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": res, "from": from, "to": to, "bucket": bucket.String(), "unit": unit}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// streams measurements of selected sensors as csv or ndjson, rolled up history is exported as aggregate averages
+func (app *App) exportMeasurementsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	sensorIds := make([]uuid.UUID, 0)
+	for _, s := range app.readCSV(qs, "sensors", nil) {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			v.AddError("sensors", "must be comma separated list of uuids")
+			break
+		}
+		sensorIds = append(sensorIds, id)
+	}
+
+	to := app.readTime(qs, "to", time.Now(), v)
+	from := app.readTime(qs, "from", time.Unix(0, 0), v)
+	v.Check(from.Before(to), "from", "must be before to")
+
+	format := data.MeasurementFormat(app.readString(qs, "format", string(data.MeasurementCSV)))
+	v.Check(validator.PermittedValue(format, data.MeasurementFormats...), "format", "must be either 'csv' or 'ndjson'")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// large exports take longer than write timeout of the server
+	err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportTimeout))
+	if err != nil {
+		app.logger.Warn("exportMeasurementsHandler write deadline", "error", err)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), exportTimeout)
+	defer cancel()
+
+	contentType := "text/csv"
+	if format == data.MeasurementNDJSON {
+		contentType = "application/x-ndjson"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"measurements.%s\"", format))
+	w.WriteHeader(http.StatusOK)
+
+	writer := data.NewMeasurementWriter(format, w)
+	flusher, _ := w.(http.Flusher)
+	rows := 0
+
+	err = app.models.SensorMeasurements.Export(ctx, sensorIds, from, to, func(measurement *data.SensorMeasurement) error {
+		if err := writer.Write(measurement); err != nil {
+			return err
+		}
+
+		rows++
+		if rows%exportFlushRows == 0 {
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+
+		return nil
+	})
+
+	// headers are already sent, the only thing left is cutting the response short
+	if err != nil {
+		app.logger.Error("exportMeasurementsHandler", "rows", rows, "error", err)
+		return
+	}
+
+	if err = writer.Flush(); err != nil {
+		app.logger.Error("exportMeasurementsHandler flush", "error", err)
+	}
+}
+
+// bulk loads measurements from csv or ndjson request body
+func (app *App) importMeasurementsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	defaultFormat := data.MeasurementCSV
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-ndjson") {
+		defaultFormat = data.MeasurementNDJSON
+	}
+
+	format := data.MeasurementFormat(app.readString(qs, "format", string(defaultFormat)))
+	v.Check(validator.PermittedValue(format, data.MeasurementFormats...), "format", "must be either 'csv' or 'ndjson'")
+
+	policy := data.ImportConflictPolicy(app.readString(qs, "on_conflict", string(data.ImportSkip)))
+	v.Check(validator.PermittedValue(policy, data.ImportConflictPolicies...), "on_conflict", "must be either 'skip', 'overwrite' or 'fail'")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// reading large body and loading it take longer than read and write timeouts of the server
+	rc := http.NewResponseController(w)
+	deadline := time.Now().Add(importTimeout)
+	if err := rc.SetReadDeadline(deadline); err != nil {
+		app.logger.Warn("importMeasurementsHandler read deadline", "error", err)
+	}
+	if err := rc.SetWriteDeadline(deadline); err != nil {
+		app.logger.Warn("importMeasurementsHandler write deadline", "error", err)
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBytes)
+
+	ctx, cancel := context.WithTimeout(r.Context(), importTimeout)
+	defer cancel()
+
+	result, err := app.models.SensorMeasurements.Import(ctx, data.NewMeasurementReader(format, r.Body), policy)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrInvalidMeasurementRow):
+			app.badRequestResponse(w, r, err)
+		case errors.Is(err, data.ErrDuplicateMeasurement):
+			app.errorResponse(w, r, http.StatusConflict, "some of the measurements already exist")
+		case errors.Is(err, data.ErrUnknownSensor):
+			v.AddError("sensor_id", "must reference existing sensor")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": result}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"crypto/subtle"
+	"inzynierka/internal/data"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "household"
+
+type appMetrics struct {
+	registry *prometheus.Registry
+
+	ruleFirings          *prometheus.CounterVec
+	sequenceRuns         *prometheus.CounterVec
+	sensorAnomalies      *prometheus.CounterVec
+	websocketConnections prometheus.Gauge
+	httpDuration         *prometheus.HistogramVec
+}
+
+func newAppMetrics() *appMetrics {
+	m := &appMetrics{
+		registry: prometheus.NewRegistry(),
+		ruleFirings: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rule_firings_total",
+			Help:      "Number of times rule has been fulfilled, by rule and target type.",
+		}, []string{"rule", "target_type"}),
+		sequenceRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "sequence_runs_total",
+			Help:      "Number of finished sequence runs, by sequence and status.",
+		}, []string{"sequence", "status"}),
+		sensorAnomalies: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "sensor_anomalies_total",
+			Help:      "Number of reported sensor anomalies, by sensor and kind.",
+		}, []string{"sensor", "kind"}),
+		websocketConnections: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "websocket_connections",
+			Help:      "Number of open WebSocket connections.",
+		}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Duration of HTTP requests, by method, chi route pattern and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+	}
+
+	m.registry.MustRegister(
+		m.ruleFirings,
+		m.sequenceRuns,
+		m.sensorAnomalies,
+		m.websocketConnections,
+		m.httpDuration,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+var (
+	sensorValueDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "sensor", "value"),
+		"Latest value of the sensor.",
+		[]string{"sensor", "name", "type"}, nil,
+	)
+	listenerPollsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "listener", "polls_total"),
+		"Number of sensor polls done by the listener since it was started, by result.",
+		[]string{"sensor", "name", "result"}, nil,
+	)
+	listenerRestartsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "listener", "restarts_total"),
+		"Number of times polling of the sensor was restarted after it panicked.",
+		[]string{"sensor", "name"}, nil,
+	)
+	sensorUpDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "sensor", "up"),
+		"Whether the sensor is online (1), offline (0) or has not been heard from yet (-1).",
+		[]string{"sensor", "name"}, nil,
+	)
+	sensorSubscribersDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "sensor", "subscribers"),
+		"Number of subscribers of the sensor broker.",
+		[]string{"sensor", "name"}, nil,
+	)
+	notificationSubscribersDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "notification", "subscribers"),
+		"Number of subscribers of the notification broker.",
+		nil, nil,
+	)
+	measurementQueueDepthDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "measurement_writer", "queue_depth"),
+		"Number of measurements waiting to be written.",
+		nil, nil,
+	)
+	measurementsWrittenDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "measurement_writer", "measurements_total"),
+		"Number of measurements handled by the writer, by result.",
+		[]string{"result"}, nil,
+	)
+)
+
+// collects metrics read from the app state on every scrape
+type appCollector struct {
+	app *App
+}
+
+func (c appCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sensorValueDesc
+	ch <- listenerPollsDesc
+	ch <- listenerRestartsDesc
+	ch <- sensorUpDesc
+	ch <- sensorSubscribersDesc
+	ch <- notificationSubscribersDesc
+	ch <- measurementQueueDepthDesc
+	ch <- measurementsWrittenDesc
+}
+
+func (c appCollector) Collect(ch chan<- prometheus.Metric) {
+	// scrapes run concurrently with handlers adding and removing listeners
+	for _, listener := range c.app.listeners.All() {
+		sensor := listener.GetSensor()
+		sensorId := sensor.ID.String()
+
+		// values of enum and text sensors are not numbers
+		values := listener.GetCurrentValue()
+		if len(values) > 0 && !values[len(values)-1].IsText() {
+			ch <- prometheus.MustNewConstMetric(sensorValueDesc, prometheus.GaugeValue, values[len(values)-1].Number, sensorId, sensor.Name, string(sensor.Type))
+		}
+
+		// active sensors are not polled
+		if !sensor.Active {
+			polls, failures := listener.GetPollCounts()
+			ch <- prometheus.MustNewConstMetric(listenerPollsDesc, prometheus.CounterValue, float64(polls), sensorId, sensor.Name, "success")
+			ch <- prometheus.MustNewConstMetric(listenerPollsDesc, prometheus.CounterValue, float64(failures), sensorId, sensor.Name, "failure")
+			ch <- prometheus.MustNewConstMetric(listenerRestartsDesc, prometheus.CounterValue, float64(listener.GetRestarts()), sensorId, sensor.Name)
+		}
+
+		up := map[data.SensorHealthStatus]float64{data.HealthOnline: 1, data.HealthOffline: 0, data.HealthUnknown: -1}
+		ch <- prometheus.MustNewConstMetric(sensorUpDesc, prometheus.GaugeValue, up[c.app.health.Get(sensor.ID).Status], sensorId, sensor.Name)
+
+		ch <- prometheus.MustNewConstMetric(sensorSubscribersDesc, prometheus.GaugeValue, float64(listener.GetBroker().Subscribers()), sensorId, sensor.Name)
+	}
+
+	ch <- prometheus.MustNewConstMetric(notificationSubscribersDesc, prometheus.GaugeValue, float64(c.app.notificationBroker.Subscribers()))
+
+	stats := c.app.measurementWriter.Stats()
+	ch <- prometheus.MustNewConstMetric(measurementQueueDepthDesc, prometheus.GaugeValue, float64(stats.QueueDepth))
+	ch <- prometheus.MustNewConstMetric(measurementsWrittenDesc, prometheus.CounterValue, float64(stats.Written), "written")
+	ch <- prometheus.MustNewConstMetric(measurementsWrittenDesc, prometheus.CounterValue, float64(stats.Dropped), "dropped")
+	ch <- prometheus.MustNewConstMetric(measurementsWrittenDesc, prometheus.CounterValue, float64(stats.Failed), "failed")
+}
+
+var (
+	dbConnsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "db", "connections"),
+		"Number of connections in the pool, by state.",
+		[]string{"state"}, nil,
+	)
+	dbMaxConnsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "db", "max_connections"),
+		"Maximum size of the pool.",
+		nil, nil,
+	)
+	dbAcquiresDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "db", "acquires_total"),
+		"Number of connection acquires from the pool, by result.",
+		[]string{"result"}, nil,
+	)
+	dbAcquireDurationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "db", "acquire_duration_seconds_total"),
+		"Total time spent waiting for a connection.",
+		nil, nil,
+	)
+)
+
+type poolCollector struct {
+	db *pgxpool.Pool
+}
+
+func (c poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dbConnsDesc
+	ch <- dbMaxConnsDesc
+	ch <- dbAcquiresDesc
+	ch <- dbAcquireDurationDesc
+}
+
+func (c poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.db.Stat()
+
+	ch <- prometheus.MustNewConstMetric(dbConnsDesc, prometheus.GaugeValue, float64(stat.AcquiredConns()), "acquired")
+	ch <- prometheus.MustNewConstMetric(dbConnsDesc, prometheus.GaugeValue, float64(stat.IdleConns()), "idle")
+	ch <- prometheus.MustNewConstMetric(dbConnsDesc, prometheus.GaugeValue, float64(stat.ConstructingConns()), "constructing")
+	ch <- prometheus.MustNewConstMetric(dbMaxConnsDesc, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(dbAcquiresDesc, prometheus.CounterValue, float64(stat.AcquireCount()), "success")
+	ch <- prometheus.MustNewConstMetric(dbAcquiresDesc, prometheus.CounterValue, float64(stat.CanceledAcquireCount()), "canceled")
+	ch <- prometheus.MustNewConstMetric(dbAcquiresDesc, prometheus.CounterValue, float64(stat.EmptyAcquireCount()), "waited")
+	ch <- prometheus.MustNewConstMetric(dbAcquireDurationDesc, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
+
+// registers collectors which need the app to be fully set up
+func (app *App) registerMetricCollectors() {
+	app.metrics.registry.MustRegister(appCollector{app: app}, poolCollector{db: app.db})
+}
+
+// records duration of every request labeled with the route pattern it matched
+func (app *App) recordMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		app.metrics.httpDuration.WithLabelValues(r.Method, route, strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// serves metrics, requiring bearer token if one is configured, signed in user otherwise
+func (app *App) metricsHandler() http.Handler {
+	handler := promhttp.HandlerFor(app.metrics.registry, promhttp.HandlerOpts{})
+
+	token := app.config.metrics.token
+	if token == "" {
+		return app.authenticate(app.requireAuthenticated(handler))
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.errorResponse(w, r, http.StatusUnauthorized, "invalid or missing metrics token")
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"inzynierka/internal/data"
@@ -36,6 +37,14 @@ func (app *App) authenticate(next http.Handler) http.Handler {
 		}
 
 		authHeaderParts := strings.Split(authHeader, " ")
+
+		// line protocol clients authenticate with ingest token, checked by requireIngestToken
+		if len(authHeaderParts) == 2 && (authHeaderParts[0] == "Token" || authHeaderParts[0] == "Basic") {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		if len(authHeaderParts) != 2 || authHeaderParts[0] != "Bearer" {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
@@ -90,3 +99,30 @@ func (app *App) requireRole(role data.UserRole, next http.Handler) http.HandlerF
 		next.ServeHTTP(w, r)
 	})
 }
+
+// lets through admins and clients presenting configured ingest token, either as
+// "Token <token>" header (InfluxDB 2.x clients) or basic auth password (InfluxDB 1.x clients)
+func (app *App) requireIngestToken(next http.Handler) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.contextGetUser(r).Role == data.UserRoleAdmin {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := ""
+		if _, password, ok := r.BasicAuth(); ok {
+			token = password
+		} else if value, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Token "); ok {
+			token = value
+		}
+
+		expected := app.config.ingest.token
+		if expected == "" || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="ingest"`)
+			app.errorResponse(w, r, http.StatusUnauthorized, "invalid or missing ingest token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
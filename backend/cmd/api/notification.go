@@ -2,6 +2,7 @@ package main
 
 import (
 	"inzynierka/internal/data"
+	"inzynierka/internal/data/validator"
 	"net/http"
 	"time"
 
@@ -9,6 +10,36 @@ import (
 	"github.com/google/uuid"
 )
 
+func (app *App) listNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+	v := validator.New()
+
+	read := app.readBool(qs, "read", v)
+
+	level := data.NotificationLevel(app.readString(qs, "level", ""))
+	v.Check(level == "" || validator.PermittedValue(level, data.NotificationLevels...), "level", "must be either 'error', 'success', 'warning' or 'info'")
+
+	filters := app.readFilters(qs, 0, v, "-created_at", "level")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	notifications, metadata, err := app.models.Notifications.GetForUser(user.ID, read, level, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": notifications, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *App) readNotificationHandler(w http.ResponseWriter, r *http.Request) {
 	notifId, err := uuid.Parse(chi.URLParam(r, "id"))
 	if err != nil {
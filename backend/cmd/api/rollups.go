@@ -0,0 +1,27 @@
+package main
+
+import "time"
+
+// periodically rolls up measurements past their retention into aggregates
+func (app *App) runMeasurementRollups() {
+	for {
+		app.rollupMeasurements()
+		time.Sleep(app.config.measurements.rollupInterval)
+	}
+}
+
+func (app *App) rollupMeasurements() {
+	raw, err := app.models.SensorMeasurements.RollupRaw()
+	if err != nil {
+		app.logger.Error("rollupMeasurements raw", "error", err)
+	}
+
+	minutes, err := app.models.SensorMeasurements.RollupMinutes(app.config.measurements.minuteRetention)
+	if err != nil {
+		app.logger.Error("rollupMeasurements minutes", "error", err)
+	}
+
+	if raw > 0 || minutes > 0 {
+		app.logger.Info("rolled up measurements", "raw", raw, "minutes", minutes)
+	}
+}
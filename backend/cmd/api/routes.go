@@ -11,18 +11,20 @@ import (
 func (app *App) routes() http.Handler {
 	r := chi.NewRouter()
 
-	r.Use(app.recoverPanic, cors.Handler(cors.Options{
+	r.Use(app.recordMetrics, app.recoverPanic, cors.Handler(cors.Options{
 		// AllowedOrigins:   []string{"https://foo.com"}, // Use this to allow specific origin hosts
 		AllowedOrigins: app.config.cors.trustedOrigins,
 		// AllowOriginFunc:  func(r *http.Request, origin string) bool { return true },
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "If-Match"},
+		ExposedHeaders:   []string{"Link", "ETag"},
 		AllowCredentials: false,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
-	}), app.authenticate)
+	}))
 
 	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(app.authenticate)
+
 		r.Get("/healthcheck", app.healthcheckHandler)
 
 		r.Get("/sensor/measurements", app.upgradeSensorWebsocket)
@@ -31,16 +33,28 @@ func (app *App) routes() http.Handler {
 		r.Post("/sensor/init-ack", app.initAckHandler)
 		r.Post("/sensor/re-init/{id}", app.reInitSensorHandler)
 
+		r.Post("/write", app.requireIngestToken(http.HandlerFunc(app.writeLineProtocolHandler)))
+
 		r.Route("/", func(r chi.Router) {
 			r.Use(app.requireAuthenticated)
 
 			r.Get("/sensor", app.listSensorsHandler)
 			r.Get("/sensor/{id}", app.getSensorHandler)
+			r.Get("/sensor/{id}/measurements", app.sensorMeasurementsHandler)
+			r.Get("/measurement/export", app.exportMeasurementsHandler)
+			r.Get("/unit", app.listUnitsHandler)
 			r.Put("/sensor/{id}/value", app.setSensorValue)
+			r.Post("/sensor/group/value", app.setGroupValueHandler)
 
 			r.Post("/sensor", app.requireRole(data.UserRoleAdmin, http.HandlerFunc(app.createSensorHandler)))
 			r.Put("/sensor/{id}", app.requireRole(data.UserRoleAdmin, http.HandlerFunc(app.updateSensorHandler)))
 			r.Delete("/sensor/{id}", app.requireRole(data.UserRoleAdmin, http.HandlerFunc(app.deleteSensorHandler)))
+			r.Post("/measurement/import", app.requireRole(data.UserRoleAdmin, http.HandlerFunc(app.importMeasurementsHandler)))
+
+			r.Get("/discovery", app.listDiscoveredDevicesHandler)
+
+			r.Post("/discovery/scan", app.requireRole(data.UserRoleAdmin, http.HandlerFunc(app.scanDevicesHandler)))
+			r.Post("/discovery/adopt", app.requireRole(data.UserRoleAdmin, http.HandlerFunc(app.adoptDeviceHandler)))
 
 			r.Get("/rule", app.listRulesHandler)
 			r.Get("/rule/{id}", app.getRuleHandler)
@@ -52,11 +66,39 @@ func (app *App) routes() http.Handler {
 			r.Get("/sequence", app.listSequencesHandler)
 			r.Get("/sequence/{id}", app.getSequenceHandler)
 			r.Post("/sequence/{id}/start", app.startSequenceHandler)
+			r.Get("/sequence/{id}/runs", app.listSequenceRunsHandler)
+			r.Get("/sequence/{id}/schedule", app.listSequenceSchedulesHandler)
 
 			r.Post("/sequence", app.requireRole(data.UserRoleAdmin, http.HandlerFunc(app.createSequenceHandler)))
 			r.Put("/sequence/{id}", app.requireRole(data.UserRoleAdmin, http.HandlerFunc(app.updateSequenceHandler)))
 			r.Delete("/sequence/{id}", app.requireRole(data.UserRoleAdmin, http.HandlerFunc(app.deleteSequenceHandler)))
+			r.Post("/sequence/{id}/schedule", app.requireRole(data.UserRoleAdmin, http.HandlerFunc(app.createScheduleHandler)))
+
+			r.Get("/schedule/{id}", app.getScheduleHandler)
+			r.Get("/schedule/{id}/next", app.nextScheduleRunsHandler)
+
+			r.Put("/schedule/{id}", app.requireRole(data.UserRoleAdmin, http.HandlerFunc(app.updateScheduleHandler)))
+			r.Delete("/schedule/{id}", app.requireRole(data.UserRoleAdmin, http.HandlerFunc(app.deleteScheduleHandler)))
+			r.Post("/schedule/{id}/skip", app.requireRole(data.UserRoleAdmin, http.HandlerFunc(app.skipScheduleHandler)))
+			r.Delete("/schedule/{id}/skip", app.requireRole(data.UserRoleAdmin, http.HandlerFunc(app.unskipScheduleHandler)))
 
+			r.Get("/scene", app.listScenesHandler)
+			r.Get("/scene/{id}", app.getSceneHandler)
+			r.Post("/scene/{id}/apply", app.applySceneHandler)
+
+			r.Post("/scene", app.requireRole(data.UserRoleAdmin, http.HandlerFunc(app.createSceneHandler)))
+			r.Post("/scene/snapshot", app.requireRole(data.UserRoleAdmin, http.HandlerFunc(app.snapshotSceneHandler)))
+			r.Put("/scene/{id}", app.requireRole(data.UserRoleAdmin, http.HandlerFunc(app.updateSceneHandler)))
+			r.Delete("/scene/{id}", app.requireRole(data.UserRoleAdmin, http.HandlerFunc(app.deleteSceneHandler)))
+
+			r.Get("/area", app.listAreasHandler)
+			r.Get("/area/{id}", app.getAreaHandler)
+
+			r.Post("/area", app.requireRole(data.UserRoleAdmin, http.HandlerFunc(app.createAreaHandler)))
+			r.Put("/area/{id}", app.requireRole(data.UserRoleAdmin, http.HandlerFunc(app.updateAreaHandler)))
+			r.Delete("/area/{id}", app.requireRole(data.UserRoleAdmin, http.HandlerFunc(app.deleteAreaHandler)))
+
+			r.Get("/notification", app.listNotificationsHandler)
 			r.Put("/notification/{id}", app.readNotificationHandler)
 			r.Put("/notification", app.readAllNotificationHandler)
 			r.Post("/notification/debug", app.requestAllNotifsHandler)
@@ -78,6 +120,9 @@ func (app *App) routes() http.Handler {
 		r.MethodNotAllowed(app.methodNotAllowed)
 	})
 
+	// authenticates on its own, metrics token is not a user token
+	r.Handle("/metrics", app.metricsHandler())
+
 	r.NotFound(app.spaHandler)
 
 	return r
@@ -20,7 +20,13 @@ func (app *App) createRuleHandler(w http.ResponseWriter, r *http.Request) {
 
 	v := validator.New()
 
-	if data.ValidateRule(v, &rule); !v.Valid() {
+	data.ValidateRule(v, &rule)
+	if err = app.validateAreaReference(v, rule.AreaID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
@@ -66,20 +72,31 @@ func (app *App) getRuleHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"rule": rule}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"rule": rule}, versionHeaders(rule.Version))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *App) listRulesHandler(w http.ResponseWriter, r *http.Request) {
-	rule, err := app.models.Rules.GetAllInfo()
+	qs := r.URL.Query()
+	v := validator.New()
+
+	filter := app.readGroupFilter(qs, v)
+	filters := app.readFilters(qs, 0, v, "id", "name", "created_at")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	rule, metadata, err := app.models.Rules.GetAllInfo(filter, filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"data": rule}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": rule, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -111,6 +128,10 @@ func (app *App) updateRuleHanlder(w http.ResponseWriter, r *http.Request) {
 		Description *string                 `json:"description"`
 		Internal    *map[string]interface{} `json:"internal"`
 		OnValid     *data.ValidRuleAction   `json:"on_valid"`
+		// nil uuid removes the rule from its area
+		AreaID  *uuid.UUID `json:"area_id"`
+		Tags    *[]string  `json:"tags"`
+		Version *int       `json:"version"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -119,6 +140,10 @@ func (app *App) updateRuleHanlder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !app.versionMatches(w, r, rule.Version, input.Version) {
+		return
+	}
+
 	if input.Name != nil {
 		rule.Name = *input.Name
 	}
@@ -141,8 +166,23 @@ func (app *App) updateRuleHanlder(w http.ResponseWriter, r *http.Request) {
 		rule.OnValid = *input.OnValid
 	}
 
+	if input.AreaID != nil {
+		rule.AreaID = areaUpdate(*input.AreaID)
+	}
+
+	if input.Tags != nil {
+		rule.Tags = *input.Tags
+	}
+
 	v := validator.New()
-	if data.ValidateRule(v, rule); !v.Valid() {
+
+	data.ValidateRule(v, rule)
+	if err = app.validateAreaReference(v, rule.AreaID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
@@ -154,13 +194,15 @@ func (app *App) updateRuleHanlder(w http.ResponseWriter, r *http.Request) {
 		case errors.Is(err, data.ErrNonExistingTo):
 			v.AddError("on_valid.id", "referencing non existing device")
 			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"data": rule}, nil)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"data": rule}, versionHeaders(rule.Version))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
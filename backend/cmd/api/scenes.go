@@ -0,0 +1,354 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"inzynierka/internal/data"
+	"inzynierka/internal/data/validator"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// loads all sensors keyed by id, used to validate scene values
+func (app *App) sensorsById() (map[uuid.UUID]*data.Sensor, error) {
+	sensors, err := app.models.Sensors.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	sensorsById := make(map[uuid.UUID]*data.Sensor, len(sensors))
+	for _, sensor := range sensors {
+		sensorsById[sensor.ID] = sensor
+	}
+
+	return sensorsById, nil
+}
+
+// validates scene against existing sensors, writes error response and returns false if the scene is invalid
+func (app *App) validateScene(w http.ResponseWriter, r *http.Request, scene *data.Scene) bool {
+	sensors, err := app.sensorsById()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	v := validator.New()
+
+	if data.ValidateScene(v, scene, sensors); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	return true
+}
+
+func (app *App) createSceneHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string            `json:"name"`
+		Description string            `json:"description"`
+		Values      []data.SceneValue `json:"values"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	scene := &data.Scene{
+		Name:        input.Name,
+		Description: input.Description,
+		Values:      input.Values,
+	}
+
+	if !app.validateScene(w, r, scene) {
+		return
+	}
+
+	err = app.models.Scenes.Insert(scene)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"data": scene}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// creates scene from last known values of selected writable sensors
+func (app *App) snapshotSceneHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name        string      `json:"name"`
+		Description string      `json:"description"`
+		Sensors     []uuid.UUID `json:"sensors"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	sensors, err := app.sensorsById()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Sensors) > 0, "sensors", "must have at least one sensor")
+
+	scene := &data.Scene{
+		Name:        input.Name,
+		Description: input.Description,
+		Values:      make([]data.SceneValue, 0, len(input.Sensors)),
+	}
+
+	for i, sensorId := range input.Sensors {
+		field := fmt.Sprintf("sensors[%d]", i)
+
+		sensor, ok := sensors[sensorId]
+		if !ok {
+			v.AddError(field, "must reference existing sensor")
+			continue
+		}
+
+		if !sensor.Type.IsWritable() {
+			v.AddError(field, "must reference binary, decimal or enum switch")
+			continue
+		}
+
+		var values []data.SensorValue
+		if listener, ok := app.listeners.Get(sensorId); ok {
+			values = listener.GetCurrentValue()
+		}
+
+		if len(values) == 0 {
+			v.AddError(field, "has no known value")
+			continue
+		}
+
+		scene.Values = append(scene.Values, data.SceneValueFor(sensor, values[len(values)-1]))
+	}
+
+	if data.ValidateScene(v, scene, sensors); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Scenes.Insert(scene)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"data": scene}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *App) listScenesHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	filters := app.readFilters(r.URL.Query(), 0, v, "name", "id", "created_at")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	scenes, metadata, err := app.models.Scenes.GetAllInfo(filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": scenes, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// reads scene from id url param, writes error response and returns nil if it fails
+func (app *App) readSceneParam(w http.ResponseWriter, r *http.Request) *data.Scene {
+	sceneIdStr := chi.URLParam(r, "id")
+	sceneId, err := uuid.Parse(sceneIdStr)
+
+	if err != nil {
+		app.writeJSON(w, http.StatusBadRequest, envelope{"error": "not a valid uuid"}, nil)
+		return nil
+	}
+
+	scene, err := app.models.Scenes.Get(sceneId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	return scene
+}
+
+func (app *App) getSceneHandler(w http.ResponseWriter, r *http.Request) {
+	scene := app.readSceneParam(w, r)
+	if scene == nil {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"scene": scene}, versionHeaders(scene.Version))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *App) updateSceneHandler(w http.ResponseWriter, r *http.Request) {
+	scene := app.readSceneParam(w, r)
+	if scene == nil {
+		return
+	}
+
+	var input struct {
+		Name        *string            `json:"name"`
+		Description *string            `json:"description"`
+		Values      *[]data.SceneValue `json:"values"`
+		Version     *int               `json:"version"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if !app.versionMatches(w, r, scene.Version, input.Version) {
+		return
+	}
+
+	if input.Name != nil {
+		scene.Name = *input.Name
+	}
+	if input.Description != nil {
+		scene.Description = *input.Description
+	}
+	if input.Values != nil {
+		scene.Values = *input.Values
+	}
+
+	if !app.validateScene(w, r, scene) {
+		return
+	}
+
+	err = app.models.Scenes.Update(scene)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": scene}, versionHeaders(scene.Version))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *App) deleteSceneHandler(w http.ResponseWriter, r *http.Request) {
+	sceneIdStr := chi.URLParam(r, "id")
+	sceneId, err := uuid.Parse(sceneIdStr)
+
+	if err != nil {
+		app.writeJSON(w, http.StatusBadRequest, envelope{"error": "not a valid uuid"}, nil)
+		return
+	}
+
+	err = app.models.Scenes.Delete(sceneId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "scene successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *App) applySceneHandler(w http.ResponseWriter, r *http.Request) {
+	scene := app.readSceneParam(w, r)
+	if scene == nil {
+		return
+	}
+
+	_, failed := app.applyScene(scene, 0, 0)
+
+	errs := make(map[uuid.UUID]string, len(failed))
+	for id, err := range failed {
+		errs[id] = err.Error()
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"applied": len(scene.Values) - len(failed), "failed": errs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// writes all scene values at the same time, retrying every write on its own.
+// Returns the highest number of attempts made and errors keyed by sensor id
+func (app *App) applyScene(scene *data.Scene, retries int, backoff time.Duration) (int, map[uuid.UUID]error) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		attempts int
+		failed   = make(map[uuid.UUID]error)
+	)
+
+	for _, value := range scene.Values {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			n, err := app.applySceneValue(value, retries, backoff)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			attempts = max(attempts, n)
+			if err != nil {
+				app.logger.Warn("applyScene", "scene", scene.ID, "sensor", value.Sensor, "error", err)
+				failed[value.Sensor] = err
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return attempts, failed
+}
+
+func (app *App) applySceneValue(value data.SceneValue, retries int, backoff time.Duration) (int, error) {
+	sensor, err := app.models.Sensors.Get(value.Sensor)
+	if err != nil {
+		return 0, err
+	}
+
+	return app.sendValueWithRetries(sensor, value.Value, retries, backoff)
+}
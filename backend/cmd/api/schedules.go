@@ -0,0 +1,434 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"inzynierka/internal/data"
+	"inzynierka/internal/data/validator"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	// how long after planned time a missed run (e.g. while server was down) is still executed
+	scheduleMisfireGrace = 5 * time.Minute
+	// schedules are reloaded from the db at least this often
+	scheduleMaxWait = time.Minute
+)
+
+func (app *App) createScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	sequenceIdStr := chi.URLParam(r, "id")
+	sequenceId, err := uuid.Parse(sequenceIdStr)
+
+	if err != nil {
+		app.writeJSON(w, http.StatusBadRequest, envelope{"error": "not a valid uuid"}, nil)
+		return
+	}
+
+	_, err = app.models.Sequences.Get(sequenceId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Type     data.ScheduleType `json:"type"`
+		RunAt    *time.Time        `json:"run_at"`
+		Cron     string            `json:"cron"`
+		Enabled  *bool             `json:"enabled"`
+		Timezone *string           `json:"timezone"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	schedule := &data.Schedule{
+		SequenceID: sequenceId,
+		Type:       input.Type,
+		RunAt:      input.RunAt,
+		Cron:       input.Cron,
+		Enabled:    input.Enabled == nil || *input.Enabled,
+		Timezone:   app.config.schedules.timezone,
+	}
+
+	if input.Timezone != nil {
+		schedule.Timezone = *input.Timezone
+	}
+
+	v := validator.New()
+	data.ValidateScheduleRunAt(v, schedule)
+	if data.ValidateSchedule(v, schedule); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Schedules.Insert(schedule)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.reloadSchedules()
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"data": schedule}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *App) listSequenceSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	sequenceIdStr := chi.URLParam(r, "id")
+	sequenceId, err := uuid.Parse(sequenceIdStr)
+
+	if err != nil {
+		app.writeJSON(w, http.StatusBadRequest, envelope{"error": "not a valid uuid"}, nil)
+		return
+	}
+
+	v := validator.New()
+
+	filters := app.readFilters(r.URL.Query(), 0, v, "created_at", "id", "run_at", "last_run_at", "enabled")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	schedules, metadata, err := app.models.Schedules.GetForSequence(sequenceId, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": schedules, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// reads schedule from id url param, writes error response and returns nil if it fails
+func (app *App) readScheduleParam(w http.ResponseWriter, r *http.Request) *data.Schedule {
+	scheduleIdStr := chi.URLParam(r, "id")
+	scheduleId, err := uuid.Parse(scheduleIdStr)
+
+	if err != nil {
+		app.writeJSON(w, http.StatusBadRequest, envelope{"error": "not a valid uuid"}, nil)
+		return nil
+	}
+
+	schedule, err := app.models.Schedules.Get(scheduleId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	return schedule
+}
+
+func (app *App) getScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	schedule := app.readScheduleParam(w, r)
+	if schedule == nil {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"schedule": schedule}, versionHeaders(schedule.Version))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *App) updateScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	schedule := app.readScheduleParam(w, r)
+	if schedule == nil {
+		return
+	}
+
+	var input struct {
+		Type     *data.ScheduleType `json:"type"`
+		RunAt    *time.Time         `json:"run_at"`
+		Cron     *string            `json:"cron"`
+		Enabled  *bool              `json:"enabled"`
+		Timezone *string            `json:"timezone"`
+		Version  *int               `json:"version"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if !app.versionMatches(w, r, schedule.Version, input.Version) {
+		return
+	}
+
+	if input.Type != nil {
+		schedule.Type = *input.Type
+	}
+
+	if input.RunAt != nil {
+		schedule.RunAt = input.RunAt
+	}
+
+	if input.Cron != nil {
+		schedule.Cron = *input.Cron
+	}
+
+	if input.Enabled != nil {
+		schedule.Enabled = *input.Enabled
+	}
+
+	if input.Timezone != nil {
+		schedule.Timezone = *input.Timezone
+	}
+
+	// skipped occurrence makes no sense after timing has changed
+	if input.Type != nil || input.RunAt != nil || input.Cron != nil || input.Timezone != nil {
+		schedule.SkipAt = nil
+	}
+
+	v := validator.New()
+	// one-shot schedule which has already run can still be edited, e.g. disabled
+	if input.Type != nil || input.RunAt != nil {
+		data.ValidateScheduleRunAt(v, schedule)
+	}
+	if data.ValidateSchedule(v, schedule); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Schedules.Update(schedule)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.reloadSchedules()
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": schedule}, versionHeaders(schedule.Version))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *App) deleteScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	scheduleIdStr := chi.URLParam(r, "id")
+	scheduleId, err := uuid.Parse(scheduleIdStr)
+
+	if err != nil {
+		app.writeJSON(w, http.StatusBadRequest, envelope{"error": "not a valid uuid"}, nil)
+		return
+	}
+
+	err = app.models.Schedules.Delete(scheduleId)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.reloadSchedules()
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "schedule successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *App) nextScheduleRunsHandler(w http.ResponseWriter, r *http.Request) {
+	schedule := app.readScheduleParam(w, r)
+	if schedule == nil {
+		return
+	}
+
+	v := validator.New()
+	count := app.readInt(r.URL.Query(), "count", 5, v)
+	v.Check(count > 0, "count", "must be a positive integer")
+	v.Check(count <= 100, "count", "must not be more than 100")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	runs := []time.Time{}
+	if schedule.Enabled {
+		runs = schedule.NextRuns(time.Now(), count)
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"data": runs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *App) skipScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	schedule := app.readScheduleParam(w, r)
+	if schedule == nil {
+		return
+	}
+
+	skipped, ok := schedule.SkipNext(time.Now())
+	if !ok {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "schedule has no upcoming runs")
+		return
+	}
+
+	if !app.versionMatches(w, r, schedule.Version, nil) {
+		return
+	}
+
+	err := app.models.Schedules.Update(schedule)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.reloadSchedules()
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": schedule, "skipped": skipped}, versionHeaders(schedule.Version))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *App) unskipScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	schedule := app.readScheduleParam(w, r)
+	if schedule == nil {
+		return
+	}
+
+	if !app.versionMatches(w, r, schedule.Version, nil) {
+		return
+	}
+
+	schedule.SkipAt = nil
+
+	err := app.models.Schedules.Update(schedule)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.reloadSchedules()
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": schedule}, versionHeaders(schedule.Version))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// wakes up scheduler so it picks up changed schedules
+func (app *App) reloadSchedules() {
+	select {
+	case app.schedules.reloadCh <- struct{}{}:
+	default:
+	}
+}
+
+// runs due schedules, sleeping until the closest upcoming run, schedule change or scheduleMaxWait
+func (app *App) runScheduler() {
+	started := time.Now()
+
+	for {
+		wait := scheduleMaxWait
+
+		schedules, err := app.models.Schedules.GetAllEnabled()
+		if err != nil {
+			app.logger.Error("runScheduler query", "error", err)
+		}
+
+		now := time.Now()
+
+		for _, schedule := range schedules {
+			// runs missed while server was down are executed only within grace period
+			from := started.Add(-scheduleMisfireGrace)
+			if schedule.CreatedAt.After(from) {
+				from = schedule.CreatedAt
+			}
+			if schedule.LastRunAt != nil && schedule.LastRunAt.After(from) {
+				from = *schedule.LastRunAt
+			}
+
+			next, ok := schedule.Next(from)
+			if !ok {
+				continue
+			}
+
+			if !next.After(now) {
+				app.runScheduledSequence(schedule, next)
+				continue
+			}
+
+			if next.Sub(now) < wait {
+				wait = next.Sub(now)
+			}
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-app.schedules.reloadCh:
+		}
+	}
+}
+
+func (app *App) runScheduledSequence(schedule *data.Schedule, at time.Time) {
+	// marking first, so the run is not repeated if anything below fails
+	err := app.models.Schedules.MarkRun(schedule, at)
+	if err != nil {
+		app.logger.Error("runScheduledSequence mark run", "schedule", schedule.ID, "error", err)
+		return
+	}
+
+	sequence, err := app.models.Sequences.Get(schedule.SequenceID)
+	if err != nil {
+		app.logger.Error("runScheduledSequence query", "schedule", schedule.ID, "error", err)
+		return
+	}
+
+	app.logger.Debug("runScheduledSequence", "schedule", schedule.ID, "sequence", sequence.Name, "at", at)
+
+	targets := make(sequenceTargets)
+	err = app.prepareSequenceTargets(sequence.Actions, targets)
+	if err != nil {
+		app.logger.Error("runScheduledSequence prepareSequenceTargets", "schedule", schedule.ID, "error", err)
+		_ = app.sendNotificationToAll("Scheduled sequence failed", fmt.Sprintf("Sequence %q could not be started: %v", sequence.Name, err), data.NotificationLevelError)
+		return
+	}
+
+	go app.executeSequence(sequence, targets)
+}
@@ -1,8 +1,7 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"inzynierka/internal/data"
@@ -16,13 +15,39 @@ import (
 )
 
 func (app *App) listSensorsHandler(w http.ResponseWriter, r *http.Request) {
-	sensors, err := app.models.Sensors.GetAllInfo()
+	qs := r.URL.Query()
+	v := validator.New()
+
+	filter := data.SensorFilter{
+		GroupFilter: app.readGroupFilter(qs, v),
+		Hidden:      app.readBool(qs, "hidden", v),
+		Active:      app.readBool(qs, "active", v),
+	}
+
+	for _, sensorType := range app.readCSV(qs, "type", nil) {
+		v.Check(validator.PermittedValue(data.SensorType(sensorType), data.SensorTypes...), "type", "must be known")
+		filter.Types = append(filter.Types, data.SensorType(sensorType))
+	}
+
+	filters := app.readFilters(qs, 0, v, "id", "name", "type", "created_at")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	sensors, metadata, err := app.models.Sensors.GetAllInfo(filter, filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"data": sensors}, nil)
+	for _, sensor := range sensors {
+		health := app.health.Get(sensor.ID)
+		sensor.Health = &health
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": sensors, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -49,7 +74,17 @@ func (app *App) getSensorHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"sensor": sensor}, nil)
+	health := app.health.Get(sensor.ID)
+	sensor.Health = &health
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sensor": sensor}, versionHeaders(sensor.Version))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *App) listUnitsHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"data": data.Units}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -57,12 +92,26 @@ func (app *App) getSensorHandler(w http.ResponseWriter, r *http.Request) {
 
 func (app *App) createSensorHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Name        string          `json:"name"`
-		URI         string          `json:"uri"`
-		Type        data.SensorType `json:"type"`
-		Hidden      bool            `json:"hidden"`
-		RefreshRate int             `json:"refresh_rate"`
-		Active      bool            `json:"active"`
+		Name          string               `json:"name"`
+		URI           string               `json:"uri"`
+		Type          data.SensorType      `json:"type"`
+		Hidden        bool                 `json:"hidden"`
+		RefreshRate   int                  `json:"refresh_rate"`
+		Active        bool                 `json:"active"`
+		RetentionDays int                  `json:"retention_days"`
+		Transport     data.SensorTransport `json:"transport"`
+		MQTT          *data.MQTTOptions    `json:"mqtt"`
+		Anomaly       *data.AnomalyOptions `json:"anomaly"`
+		Transforms    []data.Transform     `json:"transforms"`
+		StoreRaw      bool                 `json:"store_raw"`
+		Unit          string               `json:"unit"`
+		Decimals      *int                 `json:"decimals"`
+		Min           *float64             `json:"min"`
+		Max           *float64             `json:"max"`
+		Options       []string             `json:"options"`
+		Icon          string               `json:"icon"`
+		AreaID        *uuid.UUID           `json:"area_id"`
+		Tags          []string             `json:"tags"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -72,27 +121,74 @@ func (app *App) createSensorHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	sensor := &data.Sensor{
-		ID:          uuid.Nil,
-		Name:        input.Name,
-		URI:         input.URI,
-		Type:        input.Type,
-		Hidden:      input.Hidden,
-		RefreshRate: input.RefreshRate,
-		Active:      input.Active,
-	}
+		ID:            uuid.Nil,
+		Name:          input.Name,
+		URI:           input.URI,
+		Type:          input.Type,
+		Hidden:        input.Hidden,
+		RefreshRate:   input.RefreshRate,
+		Active:        input.Active,
+		RetentionDays: input.RetentionDays,
+		Transport:     input.Transport,
+		MQTT:          input.MQTT,
+		Anomaly:       input.Anomaly,
+		Transforms:    input.Transforms,
+		StoreRaw:      input.StoreRaw,
+		Unit:          input.Unit,
+		Decimals:      input.Decimals,
+		Min:           input.Min,
+		Max:           input.Max,
+		Options:       input.Options,
+		Icon:          input.Icon,
+		AreaID:        input.AreaID,
+		Tags:          input.Tags,
+	}
+
+	if sensor.Transport == "" {
+		sensor.Transport = data.TransportHTTP
+	}
+
+	app.createSensor(w, r, sensor)
+}
 
+// initializes active sensor or inserts the sensor and starts listening to it, writes response in both cases
+func (app *App) createSensor(w http.ResponseWriter, r *http.Request, sensor *data.Sensor) {
+	// active sensors are inserted once they acknowledge the init request,
+	// unless their transport needs no initialization
 	if sensor.Active {
-		app.initSensor(*sensor)
-	} else {
-		app.validateAndInsertSensor(sensor, w, r)
-		app.setupSensorListener(sensor)
+		err := app.initSensor(*sensor)
+		if !errors.Is(err, data.ErrTransportUnsupported) {
+			return
+		}
+	}
+
+	_, err := app.validateAndInsertSensor(sensor, w, r)
+	if err != nil {
+		return
+	}
+
+	app.setupSensorListener(sensor)
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"data": sensor}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *App) validateAndInsertSensor(sensor *data.Sensor, w http.ResponseWriter, r *http.Request) (data.Sensor, error) {
 	v := validator.New()
 
-	if data.ValidateSensor(v, sensor); !v.Valid() {
+	data.ValidateSensor(v, sensor)
+	if err := app.validateVirtualSources(v, sensor); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return data.Sensor{}, err
+	}
+	if err := app.validateAreaReference(v, sensor.AreaID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return data.Sensor{}, err
+	}
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return data.Sensor{}, errors.New("validation failed")
 	}
@@ -125,6 +221,8 @@ func (app *App) validateAndUpdateSensor(sensor *data.Sensor, w http.ResponseWrit
 		case errors.Is(err, data.ErrDuplicateUri):
 			v.AddError("uri", "a sensor with this URI already exists")
 			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -132,55 +230,41 @@ func (app *App) validateAndUpdateSensor(sensor *data.Sensor, w http.ResponseWrit
 	}
 }
 
-// function to initialize active (for now) sensor.
-// Sends init request to the sensor and adds it to initBuffer.
+// function to initialize active sensor.
+// Sends init request over sensor transport and adds it to initBuffer.
 // Sensor should send init-ack request to init-ack endpoint to be removed from initBuffer and further processed
 func (app *App) initSensor(sensor data.Sensor) error {
 	app.logger.Debug("init sensor", "sensor", sensor.Name)
-	sensorEndpoint := fmt.Sprintf("http://%v/init", sensor.URI)
-	measurementsEndpoint := "/api/v1/sensor/measurements"
-	initAckEndpoint := "/api/v1/sensor/init-ack"
-	idToken, err := uuid.NewRandom()
+
+	transport, err := app.transports.For(&sensor)
 	if err != nil {
-		app.logger.Error("init sensor id token generation", "error", err.Error())
 		return err
 	}
-	app.initBuffer[idToken] = sensor
 
-	var requestBody struct {
-		IdToken              uuid.UUID `json:"id-token"`
-		ServerUri            string    `json:"server-uri"`
-		InitAckEndpoint      string    `json:"init-ack-endpoint"`
-		MeasurementsEndpoint string    `json:"measurements-endpoint"`
-	}
-
-	requestBody.IdToken = idToken
-	requestBody.ServerUri = fmt.Sprintf("%s:%d", app.config.host, app.config.port)
-	requestBody.InitAckEndpoint = initAckEndpoint
-	requestBody.MeasurementsEndpoint = measurementsEndpoint
-
-	client := &http.Client{}
-	client.Timeout = 5 * time.Second
-
-	body := new(bytes.Buffer)
-	err = json.NewEncoder(body).Encode(requestBody)
+	idToken, err := uuid.NewRandom()
 	if err != nil {
-		app.logger.Error("sendInitRequest marshall", "error", err.Error())
+		app.logger.Error("init sensor id token generation", "error", err.Error())
 		return err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, sensorEndpoint, body)
-	if err != nil {
-		app.logger.Error("handleRuleRequests request creation", "error", err.Error())
-		return err
+	request := data.InitRequest{
+		IdToken:              idToken,
+		ServerUri:            fmt.Sprintf("%s:%d", app.config.host, app.config.port),
+		InitAckEndpoint:      "/api/v1/sensor/init-ack",
+		MeasurementsEndpoint: "/api/v1/sensor/measurements",
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	app.initBuffer[idToken] = sensor
 
-	_, err = client.Do(req)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
+	err = transport.Init(ctx, &sensor, request)
 	if err != nil {
-		app.logger.Error("handleInitRequest request", "error", err.Error())
+		delete(app.initBuffer, idToken)
+		if !errors.Is(err, data.ErrTransportUnsupported) {
+			app.logger.Error("handleInitRequest request", "error", err.Error())
+		}
 		return err
 	}
 
@@ -208,12 +292,29 @@ func (app *App) updateSensorHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var input struct {
-		Name        *string          `json:"name"`
-		URI         *string          `json:"uri"`
-		Type        *data.SensorType `json:"type"`
-		Hidden      *bool            `json:"hidden"`
-		RefreshRate *int             `json:"refresh_rate"`
-		Active      *bool            `json:"active"`
+		Name          *string               `json:"name"`
+		URI           *string               `json:"uri"`
+		Type          *data.SensorType      `json:"type"`
+		Hidden        *bool                 `json:"hidden"`
+		RefreshRate   *int                  `json:"refresh_rate"`
+		Active        *bool                 `json:"active"`
+		RetentionDays *int                  `json:"retention_days"`
+		Transport     *data.SensorTransport `json:"transport"`
+		// null removes mqtt options, anomaly detection, precision and range
+		MQTT       nullable[data.MQTTOptions]    `json:"mqtt"`
+		Anomaly    nullable[data.AnomalyOptions] `json:"anomaly"`
+		Transforms *[]data.Transform             `json:"transforms"`
+		StoreRaw   *bool                         `json:"store_raw"`
+		Unit       *string                       `json:"unit"`
+		Decimals   nullable[int]                 `json:"decimals"`
+		Min        nullable[float64]             `json:"min"`
+		Max        nullable[float64]             `json:"max"`
+		Options    *[]string                     `json:"options"`
+		Icon       *string                       `json:"icon"`
+		// nil uuid removes the sensor from its area
+		AreaID  *uuid.UUID `json:"area_id"`
+		Tags    *[]string  `json:"tags"`
+		Version *int       `json:"version"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -222,6 +323,10 @@ func (app *App) updateSensorHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !app.versionMatches(w, r, sensor.Version, input.Version) {
+		return
+	}
+
 	if input.Name != nil {
 		sensor.Name = *input.Name
 	}
@@ -246,8 +351,75 @@ func (app *App) updateSensorHandler(w http.ResponseWriter, r *http.Request) {
 		sensor.Active = *input.Active
 	}
 
+	if input.RetentionDays != nil {
+		sensor.RetentionDays = *input.RetentionDays
+	}
+
+	if input.Transport != nil {
+		sensor.Transport = *input.Transport
+	}
+
+	if input.MQTT.Set {
+		sensor.MQTT = input.MQTT.Value
+	}
+
+	if input.Anomaly.Set {
+		sensor.Anomaly = input.Anomaly.Value
+	}
+
+	if input.Transforms != nil {
+		sensor.Transforms = *input.Transforms
+	}
+
+	if input.StoreRaw != nil {
+		sensor.StoreRaw = *input.StoreRaw
+	}
+
+	if input.Unit != nil {
+		sensor.Unit = *input.Unit
+	}
+
+	if input.Decimals.Set {
+		sensor.Decimals = input.Decimals.Value
+	}
+
+	if input.Min.Set {
+		sensor.Min = input.Min.Value
+	}
+
+	if input.Max.Set {
+		sensor.Max = input.Max.Value
+	}
+
+	if input.Options != nil {
+		sensor.Options = *input.Options
+	}
+
+	if input.Icon != nil {
+		sensor.Icon = *input.Icon
+	}
+
+	if input.AreaID != nil {
+		sensor.AreaID = areaUpdate(*input.AreaID)
+	}
+
+	if input.Tags != nil {
+		sensor.Tags = *input.Tags
+	}
+
 	v := validator.New()
-	if data.ValidateSensor(v, sensor); !v.Valid() {
+
+	data.ValidateSensor(v, sensor)
+	if err = app.validateVirtualSources(v, sensor); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if err = app.validateAreaReference(v, sensor.AreaID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
@@ -258,6 +430,8 @@ func (app *App) updateSensorHandler(w http.ResponseWriter, r *http.Request) {
 		case errors.Is(err, data.ErrDuplicateUri):
 			v.AddError("uri", "a sensor with this URI already exists")
 			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
@@ -267,7 +441,7 @@ func (app *App) updateSensorHandler(w http.ResponseWriter, r *http.Request) {
 	app.stopAndDeleteSensorListener(sensorId)
 	app.setupSensorListener(sensor)
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"sensor": sensor}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"sensor": sensor}, versionHeaders(sensor.Version))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -283,6 +457,15 @@ func (app *App) deleteSensorHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	app.stopAndDeleteSensorListener(sensorId)
+	app.health.Forget(sensorId)
+
+	// queued measurements of deleted sensor would fail the batch they are written in
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	err = app.measurementWriter.Discard(ctx, sensorId)
+	cancel()
+	if err != nil {
+		app.logger.Warn("deleteSensorHandler discard measurements", "sensor", sensorId, "error", err)
+	}
 
 	err = app.models.Sensors.DeleteSensorAndMeasurements(sensorId)
 	if err != nil {
@@ -295,6 +478,8 @@ func (app *App) deleteSensorHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.restartVirtualDependents(sensorId)
+
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "sensor successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
@@ -305,10 +490,10 @@ func (app *App) activeSensorHandler(w http.ResponseWriter, r *http.Request) {
 	app.logger.Debug("received active sensor measurement", "sensor address", r.RemoteAddr)
 
 	var requestBody struct {
-		MessageType string    `json:"message-type"`
-		SensorType  string    `json:"sensor-type"`
-		Value       float64   `json:"value"`
-		IdToken     uuid.UUID `json:"id-token"`
+		MessageType string           `json:"message-type"`
+		SensorType  string           `json:"sensor-type"`
+		Value       data.SensorValue `json:"value"`
+		IdToken     uuid.UUID        `json:"id-token"`
 	}
 
 	err := app.readJSON(w, r, &requestBody)
@@ -324,18 +509,18 @@ func (app *App) activeSensorHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	app.listeners[id].Broker.Publish([]float64{requestBody.Value})
-
-	measurement := data.SensorMeasurement{
-		SensorID:      id,
-		MeasuredAt:    time.Now(),
-		MeasuredValue: requestBody.Value,
+	err = app.deliverSensorValue(data.TransportHTTP, id, requestBody.Value, time.Now())
+	if errors.Is(err, data.ErrInvalidSensorValue) {
+		app.logger.Warn("active sensor value rejected", "sensor", id, "error", err)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
 	}
-
-	err = app.models.SensorMeasurements.Insert(&measurement)
 	if err != nil {
-		app.logger.Error("Writing measurement to DB", "error", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		app.logger.Error("Queueing measurement", "sensor", id, "error", err)
+		// sensor should retry later, when the queue has drained
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
 	}
 
 	w.WriteHeader(http.StatusAccepted)
@@ -424,7 +609,10 @@ func (app *App) reInitSensorHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	app.initSensor(*sensor)
+	err = app.initSensor(*sensor)
+	if errors.Is(err, data.ErrTransportUnsupported) {
+		app.errorResponse(w, r, http.StatusUnprocessableEntity, "sensor transport does not support initialization")
+	}
 }
 
 func (app *App) setSensorValue(w http.ResponseWriter, r *http.Request) {
@@ -436,7 +624,7 @@ func (app *App) setSensorValue(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var input struct {
-		Value float64 `json:"value"`
+		Value data.SequenceValue `json:"value"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -445,23 +633,30 @@ func (app *App) setSensorValue(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	uri, err := app.models.Sensors.GetUri(sensorId)
+	sensor, err := app.models.Sensors.Get(sensorId)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
-	url := fmt.Sprintf("http://%s/value", uri)
 
-	app.logger.Debug("setSensorValue", "id", sensorId, "value", input.Value, "uri", url)
-
-	body := new(bytes.Buffer)
-	err = json.NewEncoder(body).Encode(input)
-	if err != nil {
-		app.serverErrorResponse(w, r, err)
+	v := validator.New()
+	if data.ValidateSensorValue(v, "value", sensor, input.Value); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	go app.sendValue(url, body)
+	app.logger.Debug("setSensorValue", "id", sensorId, "value", input.Value, "transport", sensor.Transport, "uri", sensor.URI)
+
+	go func() {
+		if err := app.writeSensorValue(sensor, input.Value); err != nil {
+			app.logger.Error("setSensorValue request", "sensor", sensorId, "error", err)
+		}
+	}()
 
 	app.writeJSON(w, http.StatusOK, envelope{"message": "sent"}, nil)
 }
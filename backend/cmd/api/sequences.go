@@ -1,24 +1,19 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"inzynierka/internal/data"
+	"inzynierka/internal/data/validator"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 )
 
-type actionData struct {
-	Body  bytes.Buffer
-	Url   string
-	Delay time.Duration
-}
-
 func (app *App) createSequenceHandler(w http.ResponseWriter, r *http.Request) {
 	var sequence data.Sequence
 
@@ -28,6 +23,10 @@ func (app *App) createSequenceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !app.validateSequence(w, r, &sequence) {
+		return
+	}
+
 	err = app.models.Sequences.Insert(&sequence)
 
 	if err != nil {
@@ -42,14 +41,79 @@ func (app *App) createSequenceHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// validates sequence against existing sensors and makes sure called sequences exist and do not call back into it.
+// Writes error response and returns false if the sequence is invalid
+func (app *App) validateSequence(w http.ResponseWriter, r *http.Request, sequence *data.Sequence) bool {
+	sensorsById, err := app.sensorsById()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	v := validator.New()
+
+	data.ValidateSequence(v, sequence, sensorsById)
+	if err = app.validateAreaReference(v, sequence.AreaID); err != nil {
+		app.serverErrorResponse(w, r, err)
+		return false
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return false
+	}
+
+	err = app.models.Sequences.CheckCalls(sequence)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrSequenceRecursion):
+			v.AddError("actions", "sequence calls must not form a cycle")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("actions", "calls non existing sequence")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+
+	for _, sceneId := range data.SequenceScenes(sequence.Actions) {
+		_, err = app.models.Scenes.Get(sceneId)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.AddError("actions", "activates non existing scene")
+				app.failedValidationResponse(w, r, v.Errors)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return false
+		}
+	}
+
+	return true
+}
+
 func (app *App) listSequencesHandler(w http.ResponseWriter, r *http.Request) {
-	sequencesInfo, err := app.models.Sequences.GetAllInfo()
+	qs := r.URL.Query()
+	v := validator.New()
+
+	filter := app.readGroupFilter(qs, v)
+	filters := app.readFilters(qs, 0, v, "id", "name", "created_at")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	sequencesInfo, metadata, err := app.models.Sequences.GetAllInfo(filter, filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"data": sequencesInfo}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": sequencesInfo, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -75,7 +139,7 @@ func (app *App) getSequenceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"sequence": sequence}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"sequence": sequence}, versionHeaders(sequence.Version))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -105,11 +169,19 @@ func (app *App) updateSequenceHandler(w http.ResponseWriter, r *http.Request) {
 		Name        *string                `json:"name"`
 		Description *string                `json:"description"`
 		Actions     *[]data.SequenceAction `json:"actions"`
+		// nil uuid removes the sequence from its area
+		AreaID  *uuid.UUID `json:"area_id"`
+		Tags    *[]string  `json:"tags"`
+		Version *int       `json:"version"`
 	}
 
 	err = app.readJSON(w, r, &input)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if !app.versionMatches(w, r, sequence.Version, input.Version) {
 		return
 	}
 
@@ -122,14 +194,29 @@ func (app *App) updateSequenceHandler(w http.ResponseWriter, r *http.Request) {
 	if input.Actions != nil {
 		sequence.Actions = *input.Actions
 	}
+	if input.AreaID != nil {
+		sequence.AreaID = areaUpdate(*input.AreaID)
+	}
+	if input.Tags != nil {
+		sequence.Tags = *input.Tags
+	}
+
+	if !app.validateSequence(w, r, sequence) {
+		return
+	}
 
 	err = app.models.Sequences.Update(sequence)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"data": sequence}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": sequence}, versionHeaders(sequence.Version))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -182,7 +269,8 @@ func (app *App) startSequenceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	preparedData, err := app.prepareActionData(sequence.Actions)
+	targets := make(sequenceTargets)
+	err = app.prepareSequenceTargets(sequence.Actions, targets)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -190,49 +278,473 @@ func (app *App) startSequenceHandler(w http.ResponseWriter, r *http.Request) {
 
 	app.writeJSON(w, http.StatusOK, envelope{"success": "sequence execution has started"}, nil)
 
-	go app.executeSequence(preparedData)
+	go app.executeSequence(sequence, targets)
 }
 
-func (app *App) executeSequence(preparedData []actionData) error {
-	for _, actionData := range preparedData {
-		time.Sleep(actionData.Delay)
+func (app *App) listSequenceRunsHandler(w http.ResponseWriter, r *http.Request) {
+	sequenceIdStr := chi.URLParam(r, "id")
+	sequenceId, err := uuid.Parse(sequenceIdStr)
 
-		app.sendValue(actionData.Url, &actionData.Body)
+	if err != nil {
+		app.writeJSON(w, http.StatusBadRequest, envelope{"error": "not a valid uuid"}, nil)
+		return
 	}
 
-	return nil
+	qs := r.URL.Query()
+	v := validator.New()
+
+	status := data.SequenceRunStatus(app.readString(qs, "status", ""))
+	v.Check(status == "" || validator.PermittedValue(status, data.SequenceRunRunning, data.SequenceRunCompleted, data.SequenceRunFailed), "status", "must be either 'running', 'completed' or 'failed'")
+
+	filters := app.readFilters(qs, 50, v, "-started_at", "finished_at", "status")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	runs, metadata, err := app.models.SequenceRuns.GetForSequence(sequenceId, status, filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": runs, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
-func (app *App) prepareActionData(actions []data.SequenceAction) ([]actionData, error) {
-	var preparedData []actionData
+const (
+	// safety net for nested call steps, cycles are rejected when sequence is saved
+	maxSequenceCallDepth = 8
+	// how often wait_until steps re-evaluate their condition
+	conditionPollInterval = 500 * time.Millisecond
+)
 
-	for _, action := range actions {
-		var data actionData
+var (
+	errConditionTimeout = errors.New("condition not met before timeout")
+	errCallDepth        = errors.New("maximum sequence call depth exceeded")
+)
+
+// sensors targeted by set steps, keyed by sensor id
+type sequenceTargets map[uuid.UUID]*data.Sensor
+
+// state of a single sequence run shared by all of its branches
+type sequenceRunState struct {
+	mu      sync.Mutex
+	run     *data.SequenceRun
+	targets sequenceTargets
+	aborted bool
+}
+
+type sequenceRunner struct {
+	app      *App
+	sequence *data.Sequence
+	state    *sequenceRunState
+	depth    int
+	// branches of parallel steps not awaited by the step itself,
+	// joined by the next join step or at the end of the current branch
+	detached *sync.WaitGroup
+}
+
+// executes sequence actions, applying retry and failure policy of each step.
+// Result of every step is stored in the run history, failures are also sent as notifications
+func (app *App) executeSequence(sequence *data.Sequence, targets sequenceTargets) {
+	run := &data.SequenceRun{SequenceID: sequence.ID}
+
+	err := app.models.SequenceRuns.Insert(run)
+	if err != nil {
+		app.logger.Error("executeSequence run insert", "sequence", sequence.ID, "error", err)
+	}
+
+	run.Status = data.SequenceRunCompleted
+
+	state := &sequenceRunState{
+		run:     run,
+		targets: targets,
+	}
+
+	runner := sequenceRunner{
+		app:      app,
+		sequence: sequence,
+		state:    state,
+		detached: &sync.WaitGroup{},
+	}
+	runner.runActions(sequence.Actions, "")
+
+	// run is finished only after all of its branches are done
+	runner.detached.Wait()
+
+	app.metrics.sequenceRuns.WithLabelValues(sequence.ID.String(), string(run.Status)).Inc()
+
+	if run.Status == data.SequenceRunFailed {
+		_ = app.sendNotificationToAll("Sequence failed", fmt.Sprintf("Sequence %q failed: %s", sequence.Name, *run.Error), data.NotificationLevelError)
+	}
+
+	if run.ID == uuid.Nil {
+		return
+	}
+
+	err = app.models.SequenceRuns.Finish(run)
+	if err != nil {
+		app.logger.Error("executeSequence run finish", "sequence", sequence.ID, "run", run.ID, "error", err)
+	}
+}
+
+// runs block of actions one after another, returns false if the run has been aborted
+func (s *sequenceRunner) runActions(actions []data.SequenceAction, path string) bool {
+	for i, action := range actions {
+		time.Sleep(time.Duration(action.MsDelay) * time.Millisecond)
 
-		uri, err := app.models.Sensors.GetUri(action.Target)
+		// other branch could have aborted the run in the meantime
+		if s.isAborted() {
+			return false
+		}
+
+		stepPath := strconv.Itoa(i)
+		if path != "" {
+			stepPath = path + "." + stepPath
+		}
+
+		if !s.runAction(action, i, stepPath) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (s *sequenceRunner) runAction(action data.SequenceAction, index int, path string) bool {
+	step := data.SequenceStepResult{
+		Index:    index,
+		Path:     path,
+		Type:     action.Kind(),
+		Target:   action.Target,
+		Attempts: 1,
+		Status:   data.SequenceStepSucceeded,
+	}
+
+	switch action.Kind() {
+	case data.ActionSet:
+		attempts, err := s.setValue(action)
+		step.Attempts = attempts
+		return s.finishStep(action, step, err)
+
+	case data.ActionWaitUntil:
+		err := s.waitUntil(action.Condition, time.Duration(action.MsTimeout)*time.Millisecond)
+		return s.finishStep(action, step, err)
+
+	case data.ActionIf:
+		ok, err := s.app.evaluateCondition(action.Condition)
 		if err != nil {
-			return nil, err
+			return s.finishStep(action, step, err)
+		}
+
+		branch, name := action.Else, "else"
+		if ok {
+			branch, name = action.Then, "then"
+		}
+
+		step.Branch = name
+		s.finishStep(action, step, nil)
+		return s.runActions(branch, path+"."+name)
+
+	case data.ActionRepeat:
+		times := action.Times
+		if times == 0 {
+			times = data.MaxRepeatIterations
+		}
+
+		for {
+			// until is checked before every iteration, so body is not run when condition already holds
+			if action.Until != nil {
+				done, err := s.app.evaluateCondition(action.Until)
+				if err != nil {
+					return s.finishStep(action, step, err)
+				}
+				if done {
+					break
+				}
+			}
+
+			if step.Iterations >= times {
+				// without times limit only the condition can end the loop successfully
+				if action.Times == 0 {
+					return s.finishStep(action, step, fmt.Errorf("until condition not met after %d iterations", times))
+				}
+				break
+			}
+
+			step.Iterations++
+			if !s.runActions(action.Body, fmt.Sprintf("%s.body", path)) {
+				return false
+			}
+		}
+
+		return s.finishStep(action, step, nil)
+
+	case data.ActionCall:
+		step.Target = action.Sequence
+
+		if s.depth >= maxSequenceCallDepth {
+			return s.finishStep(action, step, errCallDepth)
+		}
+
+		called, err := s.app.models.Sequences.Get(action.Sequence)
+		if err != nil {
+			return s.finishStep(action, step, err)
+		}
+
+		s.state.mu.Lock()
+		err = s.app.prepareSequenceTargets(called.Actions, s.state.targets)
+		s.state.mu.Unlock()
+		if err != nil {
+			return s.finishStep(action, step, err)
+		}
+
+		s.finishStep(action, step, nil)
+
+		s.depth++
+		defer func() { s.depth-- }()
+
+		return s.runActions(called.Actions, path+".call")
+
+	case data.ActionParallel:
+		if !action.Wait() {
+			s.finishStep(action, step, nil)
+			s.startBranches(action.Branches, path, s.detached)
+			return true
+		}
+
+		var wg sync.WaitGroup
+		s.startBranches(action.Branches, path, &wg)
+		wg.Wait()
+
+		if s.isAborted() {
+			return false
+		}
+
+		return s.finishStep(action, step, nil)
+
+	case data.ActionScene:
+		step.Target = action.Scene
+
+		scene, err := s.app.models.Scenes.Get(action.Scene)
+		if err != nil {
+			return s.finishStep(action, step, err)
+		}
+
+		attempts, failed := s.app.applyScene(scene, action.Retries, time.Duration(action.MsBackoff)*time.Millisecond)
+		step.Attempts = attempts
+		if len(failed) > 0 {
+			err = fmt.Errorf("%d of %d scene values could not be set", len(failed), len(scene.Values))
+		}
+		return s.finishStep(action, step, err)
+
+	case data.ActionJoin:
+		s.detached.Wait()
+
+		if s.isAborted() {
+			return false
+		}
+
+		return s.finishStep(action, step, nil)
+	}
+
+	return s.finishStep(action, step, fmt.Errorf("unknown action type %q", action.Type))
+}
+
+// starts every branch in its own goroutine, each branch runs its actions sequentially
+func (s *sequenceRunner) startBranches(branches [][]data.SequenceAction, path string, wg *sync.WaitGroup) {
+	for i, branch := range branches {
+		wg.Add(1)
+
+		// every branch keeps its own call depth and detached branches
+		runner := *s
+		runner.detached = &sync.WaitGroup{}
+		branchPath := fmt.Sprintf("%s.branches.%d", path, i)
+
+		go func() {
+			defer wg.Done()
+			runner.runActions(branch, branchPath)
+			runner.detached.Wait()
+		}()
+	}
+}
+
+func (s *sequenceRunner) isAborted() bool {
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	return s.state.aborted
+}
+
+// records step result and applies failure policy, returns false if the run has to be aborted
+func (s *sequenceRunner) finishStep(action data.SequenceAction, step data.SequenceStepResult, err error) bool {
+	if err != nil {
+		s.app.logger.Warn("executeSequence step failed", "sequence", s.sequence.ID, "step", step.Path, "attempts", step.Attempts, "error", err)
+		step.Error = err.Error()
+
+		if action.OnFailure == data.FailureSkip {
+			_ = s.app.sendNotificationToAll("Sequence step skipped", fmt.Sprintf("Sequence %q skipped step %s: %v", s.sequence.Name, step.Path, err), data.NotificationLevelWarning)
 		}
+	}
+
+	s.state.mu.Lock()
+	defer s.state.mu.Unlock()
+
+	run := s.state.run
+
+	if err == nil {
+		run.Steps = append(run.Steps, step)
+		return true
+	}
+
+	switch action.OnFailure {
+	case data.FailureSkip:
+		step.Status = data.SequenceStepSkipped
+		run.Steps = append(run.Steps, step)
+		return true
+	case data.FailureContinue:
+		step.Status = data.SequenceStepFailed
+		run.Steps = append(run.Steps, step)
+		s.markFailed(step.Path, err)
+		return true
+	default:
+		step.Status = data.SequenceStepFailed
+		run.Steps = append(run.Steps, step)
+		s.markFailed(step.Path, err)
+		s.state.aborted = true
+		return false
+	}
+}
+
+// marks run as failed, keeping the error of the first failed step. Caller must hold state lock
+func (s *sequenceRunner) markFailed(path string, err error) {
+	run := s.state.run
 
-		url := fmt.Sprintf("http://%s/value", uri)
+	run.Status = data.SequenceRunFailed
+	if run.Error == nil {
+		msg := fmt.Sprintf("step %s: %v", path, err)
+		run.Error = &msg
+	}
+}
 
-		data.Url = url
-		data.Delay = time.Duration(action.MsDelay) * time.Millisecond
+func (s *sequenceRunner) setValue(action data.SequenceAction) (int, error) {
+	s.state.mu.Lock()
+	sensor, ok := s.state.targets[action.Target]
+	s.state.mu.Unlock()
 
-		var payload struct {
-			Value float32 `json:"value"`
+	if !ok {
+		return 0, fmt.Errorf("target %v was not prepared", action.Target)
+	}
+
+	return s.app.sendValueWithRetries(sensor, action.Value, action.Retries, time.Duration(action.MsBackoff)*time.Millisecond)
+}
+
+// polls condition until it holds, missing sensor values are treated as not fulfilled
+func (s *sequenceRunner) waitUntil(condition data.RuleInternal, timeout time.Duration) error {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(conditionPollInterval)
+	defer ticker.Stop()
+
+	for {
+		ok, err := s.app.evaluateCondition(condition)
+		if err != nil && !errors.Is(err, data.ErrMissingVal) {
+			return err
+		}
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-deadline:
+			return errConditionTimeout
+		case <-ticker.C:
+		}
+	}
+}
+
+// evaluates rule condition against the current values of its dependencies.
+// Falls back to the last stored measurement if listener has no value yet (e.g. active sensors)
+func (app *App) evaluateCondition(condition data.RuleInternal) (bool, error) {
+	values := make(data.RuleData)
+
+	for _, dep := range condition.Dependencies() {
+		if listener, ok := app.listeners.Get(dep); ok {
+			cur := listener.GetCurrentValue()
+			if len(cur) > 0 {
+				values[dep] = cur[len(cur)-1]
+				continue
+			}
 		}
 
-		payload.Value = action.Value
-		body := new(bytes.Buffer)
-		err = json.NewEncoder(body).Encode(payload)
+		measurement, err := app.models.SensorMeasurements.GetLastMeasurement(dep)
 		if err != nil {
-			return nil, err
+			if errors.Is(err, data.ErrRecordNotFound) {
+				continue
+			}
+			return false, err
+		}
+		values[dep] = measurement.Value()
+	}
+
+	return condition.Process(values, &app.models.SensorMeasurements)
+}
+
+// writes value, retrying with exponential backoff capped at data.MaxMsBackoff. Returns number of attempts made
+func (app *App) sendValueWithRetries(sensor *data.Sensor, value data.SequenceValue, retries int, backoff time.Duration) (int, error) {
+	attempts := 0
+
+	for {
+		attempts++
+
+		err := app.writeSensorValue(sensor, value)
+		if err == nil || attempts > retries {
+			return attempts, err
 		}
-		data.Body = *body
 
-		preparedData = append(preparedData, data)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, data.MaxMsBackoff*time.Millisecond)
+	}
+}
+
+// resolves sensors targeted by set steps (including nested blocks) into targets.
+// Called sequences are resolved when the call step is executed
+func (app *App) prepareSequenceTargets(actions []data.SequenceAction, targets sequenceTargets) error {
+	for _, action := range actions {
+		switch action.Kind() {
+		case data.ActionSet:
+			if _, ok := targets[action.Target]; ok {
+				continue
+			}
+
+			sensor, err := app.models.Sensors.Get(action.Target)
+			if err != nil {
+				return err
+			}
+
+			targets[action.Target] = sensor
+		case data.ActionIf:
+			if err := app.prepareSequenceTargets(action.Then, targets); err != nil {
+				return err
+			}
+			if err := app.prepareSequenceTargets(action.Else, targets); err != nil {
+				return err
+			}
+		case data.ActionRepeat:
+			if err := app.prepareSequenceTargets(action.Body, targets); err != nil {
+				return err
+			}
+		case data.ActionParallel:
+			for _, branch := range action.Branches {
+				if err := app.prepareSequenceTargets(branch, targets); err != nil {
+					return err
+				}
+			}
+		}
 	}
 
-	return preparedData, nil
+	return nil
 }
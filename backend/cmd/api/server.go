@@ -1,11 +1,14 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"inzynierka/internal/data"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -25,12 +28,25 @@ func (app *App) serve() error {
 		ErrorLog:     app.logger.StandardLog(),
 	}
 
+	app.registerMetricCollectors()
+
+	go app.measurementWriter.Run()
+	go app.healthBroker.Start()
+
+	if app.mqtt != nil {
+		// subscriptions of sensors set up below are made once the broker becomes available
+		if err := app.mqtt.Connect(5 * time.Second); err != nil {
+			app.logger.Warn("mqtt broker unavailable, retrying in background", "broker", app.config.mqtt.broker, "error", err)
+		}
+	}
+
 	sensors, err := app.models.Sensors.GetAll()
 	if err != nil {
 		return err
 	}
 
-	for _, sensor := range sensors {
+	// virtual sensors subscribe to listeners of their sources, so these have to be set up first
+	for _, sensor := range data.SensorSetupOrder(sensors) {
 		app.setupSensorListener(sensor)
 	}
 
@@ -46,36 +62,71 @@ func (app *App) serve() error {
 	}
 
 	go app.handleRuleRequests()
+	go app.runScheduler()
+	go app.runMeasurementRollups()
+	go app.runDiscovery()
+	go app.runHealthChecks()
+
+	shutdownError := make(chan error)
+
+	go func() {
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		app.logger.Info("shutting down server", "signal", s.String())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			shutdownError <- err
+			return
+		}
+
+		if app.mqtt != nil {
+			app.mqtt.Close()
+		}
+
+		app.logger.Info("flushing measurements", "queued", app.measurementWriter.Stats().QueueDepth)
+		shutdownError <- app.measurementWriter.Close(ctx)
+	}()
 
 	app.logger.Info("starting server", "addr", srv.Addr)
 
-	return srv.ListenAndServe()
+	err = srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("stopped server", "addr", srv.Addr)
+
+	return nil
 }
 
 func (app *App) handleRuleRequests() {
 	// reading from channel and handling rule requests
 	for message := range app.rules.channel {
+		app.metrics.ruleFirings.WithLabelValues(message.RuleID.String(), string(message.TargetType)).Inc()
+
 		switch message.TargetType {
 		case data.SensorTarget:
 			_ = app.sendNotificationToAll("Rule passed!", fmt.Sprintf("Sent message %v to sensor %v", message.Payload, message.TargetId), data.NotificationLevelSuccess)
 
-			uri, err := app.models.Sensors.GetUri(message.TargetId)
+			sensor, err := app.models.Sensors.Get(message.TargetId)
 			if err != nil {
 				app.logger.Error("handleRuleRequests query", "error", err.Error(), "uuid", message.TargetId)
 				continue
 			}
 
-			url := fmt.Sprintf("http://%s/value", uri)
-
-			body := new(bytes.Buffer)
-			err = json.NewEncoder(body).Encode(message.Payload)
-			if err != nil {
-				app.logger.Error("handleRuleRequests marshall", "error", err.Error())
-				continue
-			}
-
-			if err = app.sendValue(url, body); err != nil {
-				app.logger.Error("handleRuleRequests request", "url", url, "error", err)
+			if err = app.sendRulePayload(sensor, message); err != nil {
+				app.logger.Error("handleRuleRequests write", "uuid", message.TargetId, "transport", sensor.Transport, "error", err)
 			}
 
 		case data.SequenceTarget:
@@ -87,12 +138,47 @@ func (app *App) handleRuleRequests() {
 				continue
 			}
 
-			preparedData, err := app.prepareActionData(sequence.Actions)
+			targets := make(sequenceTargets)
+			err = app.prepareSequenceTargets(sequence.Actions, targets)
 			if err != nil {
-				app.logger.Error("handleRuleRequests prepareActionData", "error", err.Error())
+				app.logger.Error("handleRuleRequests prepareSequenceTargets", "error", err.Error())
 				continue
 			}
-			go app.executeSequence(preparedData)
+			go app.executeSequence(sequence, targets)
+
+		case data.SceneTarget:
+			scene, err := app.models.Scenes.Get(message.TargetId)
+			if err != nil {
+				app.logger.Error("handleRuleRequests query", "error", err.Error(), "uuid", message.TargetId)
+				continue
+			}
+
+			_ = app.sendNotificationToAll("Rule passed!", fmt.Sprintf("Activating scene: %v", scene.Name), data.NotificationLevelSuccess)
+
+			go func() {
+				if _, failed := app.applyScene(scene, 0, 0); len(failed) > 0 {
+					_ = app.sendNotificationToAll("Scene failed", fmt.Sprintf("Scene %q: %d of %d values could not be set", scene.Name, len(failed), len(scene.Values)), data.NotificationLevelError)
+				}
+			}()
 		}
 	}
 }
+
+// payload is passed as is to transports able to send it, others only get its value
+func (app *App) sendRulePayload(sensor *data.Sensor, action data.ValidRuleAction) error {
+	transport, err := app.transports.For(sensor)
+	if err != nil {
+		return err
+	}
+
+	if writer, ok := transport.(data.PayloadWriter); ok {
+		return writer.WritePayload(context.Background(), sensor, action.Payload)
+	}
+
+	value, ok := action.PayloadValue()
+	if !ok {
+		return errors.New("payload has no numeric, boolean or string value")
+	}
+
+	return app.writeSensorValue(sensor, value)
+}
@@ -90,6 +90,10 @@ func (app *App) updateUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !app.versionMatches(w, r, user.Version, nil) {
+		return
+	}
+
 	if curUser.Role != data.UserRoleAdmin {
 		input.Role = nil
 	}
@@ -117,11 +121,16 @@ func (app *App) updateUserHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err = app.models.Users.Update(user); err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, versionHeaders(user.Version))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -157,20 +166,31 @@ func (app *App) getCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *App) getAllUsersHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	filters := app.readFilters(r.URL.Query(), 0, v, "username", "created_at", "role")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	var users []*data.User
+	var metadata data.Metadata
 	curUser := app.contextGetUser(r)
 	if curUser.Role == data.UserRoleAdmin {
-		usersTMP, err := app.models.Users.GetAllUsers()
+		usersTMP, metadataTMP, err := app.models.Users.GetAllUsers(filters)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
 		}
 		users = usersTMP
+		metadata = metadataTMP
 	} else {
 		users = []*data.User{curUser}
+		metadata = data.Metadata{CurrentPage: 1, PageSize: 1, FirstPage: 1, LastPage: 1, TotalRecords: 1}
 	}
 
-	err := app.writeJSON(w, http.StatusOK, envelope{"data": users}, nil)
+	err := app.writeJSON(w, http.StatusOK, envelope{"data": users, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -197,7 +217,7 @@ func (app *App) getUserHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, versionHeaders(user.Version))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
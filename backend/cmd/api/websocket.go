@@ -39,6 +39,9 @@ func (app *App) upgradeSensorWebsocket(w http.ResponseWriter, r *http.Request) {
 
 	defer conn.CloseNow()
 
+	app.metrics.websocketConnections.Inc()
+	defer app.metrics.websocketConnections.Dec()
+
 	app.logger.Debug("new connection", "subprotocol", conn.Subprotocol())
 
 	if conn.Subprotocol() != subprotocol {
@@ -92,7 +95,7 @@ func (app *App) sendSensorUpdates(conn *websocket.Conn, status *connStatus) {
 	// TODO: brokers sending only current value?
 	type wsListener struct {
 		id    uuid.UUID
-		msgCh chan []float64
+		msgCh chan []data.SensorValue
 	}
 
 	for {
@@ -108,11 +111,14 @@ func (app *App) sendSensorUpdates(conn *websocket.Conn, status *connStatus) {
 	notificationChan := app.notificationBroker.Subscribe()
 	defer app.notificationBroker.Unsubscribe(notificationChan)
 
+	healthChan := app.healthBroker.Subscribe()
+	defer app.healthBroker.Unsubscribe(healthChan)
+
 	listeners := make([]wsListener, 0)
 
 	defer (func() {
 		for _, tmp := range listeners {
-			if listener, ok := app.listeners[tmp.id]; ok {
+			if listener, ok := app.listeners.Get(tmp.id); ok {
 				listener.Broker.Unsubscribe(tmp.msgCh)
 				app.logger.Debug("sendSensorUpdates", "action", "cleanup", "sensorID", tmp.id)
 			} else {
@@ -122,9 +128,12 @@ func (app *App) sendSensorUpdates(conn *websocket.Conn, status *connStatus) {
 	})()
 
 	defer app.logger.Debug("sendSensorUpdates", "action", "closing")
-	channels := make([]reflect.SelectCase, 2)
+	// channels of subscribed listeners follow these
+	const fixedChannels = 3
+	channels := make([]reflect.SelectCase, fixedChannels)
 	channels[0] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(status.ch)}
 	channels[1] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(notificationChan)}
+	channels[2] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(healthChan)}
 
 	for {
 		i, msg, ok := reflect.Select(channels)
@@ -146,7 +155,7 @@ func (app *App) sendSensorUpdates(conn *websocket.Conn, status *connStatus) {
 					continue
 				}
 
-				listener, ok := app.listeners[action.id] // should be in listeners
+				listener, ok := app.listeners.Get(action.id) // should be in listeners
 				if !ok {
 					app.logger.Error("sendSensorUpdates", "action", "subscribe", "sensorID", action.id, "error", "listener not found")
 					continue
@@ -163,9 +172,12 @@ func (app *App) sendSensorUpdates(conn *websocket.Conn, status *connStatus) {
 					continue
 				}
 
-				app.listeners[action.id].Broker.Unsubscribe(listeners[idx].msgCh)
+				// listener could have been removed since
+				if listener, ok := app.listeners.Get(action.id); ok {
+					listener.Broker.Unsubscribe(listeners[idx].msgCh)
+				}
 				listeners = slices.Delete(listeners, idx, idx+1)
-				channels = slices.Delete(channels, idx+1, idx+2)
+				channels = slices.Delete(channels, idx+fixedChannels, idx+fixedChannels+1)
 			default:
 				app.logger.Debug("sendSensorUpdates", "action", action.action, "error", "unhandled")
 			}
@@ -183,12 +195,23 @@ func (app *App) sendSensorUpdates(conn *websocket.Conn, status *connStatus) {
 			}
 			continue
 		}
+		if i == 2 {
+			change := msg.Interface().(data.HealthChange)
+			if slices.IndexFunc(listeners, func(e wsListener) bool { return e.id == change.SensorID }) == -1 {
+				continue
+			}
+			err := wsjson.Write(context.Background(), conn, map[string]any{"type": sensorHealthMsg, "sensor_id": change.SensorID, "data": change.Health})
+			if err != nil {
+				app.logger.Error("sendSensorUpdates", "action", "sendHealth", "error", err)
+			}
+			continue
+		}
 		// NOTE: obrzydliwy sposob na trzymanie tego tbh...
-		idx := i - 2
+		idx := i - fixedChannels
 		// message fron sensor listener
-		values := msg.Interface().([]float64)
+		values := msg.Interface().([]data.SensorValue)
 		if values == nil {
-			// TODO: SEND SENSOR UNAVAILABLE
+			// failed poll, sensor going offline is sent as health message
 			continue
 		}
 		err := sendSensorUpdate(conn, listeners[idx].id, values[len(values)-1])
@@ -198,12 +221,13 @@ func (app *App) sendSensorUpdates(conn *websocket.Conn, status *connStatus) {
 	}
 }
 
-func sendSensorUpdate(conn *websocket.Conn, id uuid.UUID, value float64) error {
+// value is sent as json boolean, number or string, depending on the kind of the sensor
+func sendSensorUpdate(conn *websocket.Conn, id uuid.UUID, value data.SensorValue) error {
 	type Msg struct {
-		Type     messageType `json:"type"`
-		SensorId uuid.UUID   `json:"sensor_id"`
-		Time     time.Time   `json:"time"`
-		Value    float64     `json:"value"`
+		Type     messageType      `json:"type"`
+		SensorId uuid.UUID        `json:"sensor_id"`
+		Time     time.Time        `json:"time"`
+		Value    data.SensorValue `json:"value"`
 	}
 
 	msg := Msg{
@@ -226,6 +250,7 @@ const (
 	measurementMsg         messageType = "measurment"
 	measurmentsReq         messageType = "measurement_req"
 	notificationMsg        messageType = "notification"
+	sensorHealthMsg        messageType = "sensor_health"
 	unreadNotificationsMsg messageType = "notifications_unread"
 )
 
@@ -373,12 +398,12 @@ func (app *App) handleSensorSubcribtion(id uuid.UUID) (map[string]interface{}, e
 		return nil, err
 	}
 
-	values := make(map[string]float64)
-	for _, measurement := range measurements {
-		values[measurement.MeasuredAt.Format(time.RFC3339)] = measurement.MeasuredValue
+	values, err := app.measurementValues(id, measurements)
+	if err != nil {
+		return nil, err
 	}
 
-	return map[string]interface{}{"status": "ok", "values": values}, nil
+	return map[string]interface{}{"status": "ok", "values": values, "health": app.health.Get(id)}, nil
 }
 
 func (app *App) handleUnsubscribeMsg(conn *websocket.Conn, status *connStatus, input json.RawMessage) error {
@@ -427,14 +452,33 @@ func (app *App) handleMeasurementReqMsg(conn *websocket.Conn, input json.RawMess
 		return serverErrorResponse(conn)
 	}
 
-	values := make(map[string]float64)
-	for _, measurement := range measurements {
-		values[measurement.MeasuredAt.Format(time.RFC3339)] = measurement.MeasuredValue
+	values, err := app.measurementValues(data.ID, measurements)
+	if err != nil {
+		return serverErrorResponse(conn)
 	}
 
 	return wsjson.Write(context.Background(), conn, map[string]interface{}{"type": measurmentsReq, "id": data.ID, "values": values})
 }
 
+// returns measurements keyed by time, with values of the same kind as the ones sent by sendSensorUpdate
+func (app *App) measurementValues(id uuid.UUID, measurements []*data.SensorMeasurement) (map[string]data.SensorValue, error) {
+	sensor, err := app.models.Sensors.Get(id)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	values := make(map[string]data.SensorValue, len(measurements))
+	for _, measurement := range measurements {
+		value := measurement.Value()
+		if sensor != nil {
+			value = measurement.ValueFor(sensor)
+		}
+		values[measurement.MeasuredAt.Format(time.RFC3339)] = value
+	}
+
+	return values, nil
+}
+
 func sensorErrorMsg(msg string) map[string]interface{} {
 	return map[string]interface{}{"status": "error", "message": msg}
 }
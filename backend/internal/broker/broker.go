@@ -1,11 +1,15 @@
 package broker
 
+import "sync/atomic"
+
 // https://stackoverflow.com/a/49877632
 type Broker[T any] struct {
 	stopCh    chan struct{}
 	publishCh chan T
 	subCh     chan chan T
 	unsubCh   chan chan T
+	// number of current subscribers, kept for metrics
+	subscribers atomic.Int64
 }
 
 func NewBroker[T any]() *Broker[T] {
@@ -25,8 +29,10 @@ func (b *Broker[T]) Start() {
 			return
 		case msgCh := <-b.subCh:
 			subs[msgCh] = struct{}{}
+			b.subscribers.Store(int64(len(subs)))
 		case msgCh := <-b.unsubCh:
 			delete(subs, msgCh)
+			b.subscribers.Store(int64(len(subs)))
 		case msg := <-b.publishCh:
 			for msgChn := range subs {
 				select {
@@ -42,16 +48,31 @@ func (b *Broker[T]) Stop() {
 	close(b.stopCh)
 }
 
+// Subscribe, Unsubscribe and Publish do nothing once the broker is stopped, so they never block on it.
+// Channel subscribed to stopped broker never receives anything
 func (b *Broker[T]) Subscribe() chan T {
 	msgCh := make(chan T, 5)
-	b.subCh <- msgCh
+	select {
+	case b.subCh <- msgCh:
+	case <-b.stopCh:
+	}
 	return msgCh
 }
 
 func (b *Broker[T]) Unsubscribe(msgCh chan T) {
-	b.unsubCh <- msgCh
+	select {
+	case b.unsubCh <- msgCh:
+	case <-b.stopCh:
+	}
 }
 
 func (b *Broker[T]) Publish(msg T) {
-	b.publishCh <- msg
+	select {
+	case b.publishCh <- msg:
+	case <-b.stopCh:
+	}
+}
+
+func (b *Broker[T]) Subscribers() int {
+	return int(b.subscribers.Load())
 }
@@ -0,0 +1,203 @@
+package data
+
+import (
+	"fmt"
+	"inzynierka/internal/data/validator"
+	"math"
+	"sync"
+	"time"
+)
+
+type AnomalyKind string
+
+const (
+	// value far from the rolling mean of recent values
+	AnomalyZScore AnomalyKind = "z_score"
+	// value did not change for configured time
+	AnomalyStuck AnomalyKind = "stuck"
+	// value outside of configured range
+	AnomalyOutOfRange AnomalyKind = "out_of_range"
+)
+
+const (
+	defaultAnomalyWindow   = 100
+	defaultAnomalyCooldown = 60
+	// z-score of values is not checked until the window has this many values
+	minAnomalyZScoreSamples = 10
+)
+
+// AnomalyOptions configure anomaly detection of a sensor, every check is disabled when left empty
+type AnomalyOptions struct {
+	// values further than this many standard deviations from the mean of the window are anomalies
+	ZScore float64 `json:"z_score,omitempty"`
+	// number of recent values mean and standard deviation are computed over, 100 by default
+	Window int `json:"window,omitempty"`
+	// value not changing for this many minutes is reported as stuck
+	StuckMinutes int `json:"stuck_minutes,omitempty"`
+	// values outside of the range are anomalies
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+	// minimum time between notifications about the same kind of anomaly, 60 by default
+	CooldownMinutes int `json:"cooldown_minutes,omitempty"`
+}
+
+func (o *AnomalyOptions) Enabled() bool {
+	return o != nil && (o.ZScore > 0 || o.StuckMinutes > 0 || o.Min != nil || o.Max != nil)
+}
+
+// number of values in the window, measurements to seed the detector with
+func (o *AnomalyOptions) WindowSize() int {
+	if o.Window == 0 {
+		return defaultAnomalyWindow
+	}
+	return o.Window
+}
+
+func validateAnomalyOptions(v *validator.Validator, sensor *Sensor) {
+	o := sensor.Anomaly
+
+	v.Check(o.ZScore >= 0, "anomaly.z_score", "must not be negative")
+	v.Check(o.ZScore <= 100, "anomaly.z_score", "must not be more than 100")
+	v.Check(o.Window == 0 || o.Window >= minAnomalyZScoreSamples, "anomaly.window", fmt.Sprintf("must be at least %d", minAnomalyZScoreSamples))
+	v.Check(o.Window <= 10000, "anomaly.window", "must not be more than 10000")
+	v.Check(o.StuckMinutes >= 0, "anomaly.stuck_minutes", "must not be negative")
+	v.Check(o.StuckMinutes <= 10080, "anomaly.stuck_minutes", "must not be more than 10080 (a week)")
+	v.Check(o.CooldownMinutes >= 0, "anomaly.cooldown_minutes", "must not be negative")
+	v.Check(o.CooldownMinutes <= 10080, "anomaly.cooldown_minutes", "must not be more than 10080 (a week)")
+
+	if o.Min != nil && o.Max != nil {
+		v.Check(*o.Min <= *o.Max, "anomaly.min", "must not be greater than max")
+	}
+
+	// binary values have no meaningful distribution or range
+	if sensor.Type == BinarySensor || sensor.Type == BinarySwitch || sensor.Type == Button {
+		v.Check(o.ZScore == 0, "anomaly.z_score", "must not be set for binary sensors")
+		v.Check(o.Min == nil && o.Max == nil, "anomaly.min", "must not be set for binary sensors")
+	}
+}
+
+type Anomaly struct {
+	Kind  AnomalyKind `json:"kind"`
+	Value float64     `json:"value"`
+	At    time.Time   `json:"at"`
+	// human readable details, eg. how far the value is from the mean
+	Details string `json:"details"`
+}
+
+// AnomalyDetector checks values of a single sensor against its AnomalyOptions,
+// keeping rolling window of recent values. Anomalies of the same kind are reported at most once per cooldown
+type AnomalyDetector struct {
+	options AnomalyOptions
+	mu      sync.Mutex
+	// ring buffer of recent values
+	window []float64
+	next   int
+	// last value and since when it did not change
+	last         float64
+	unchanged    time.Time
+	hasLast      bool
+	lastReported map[AnomalyKind]time.Time
+}
+
+func NewAnomalyDetector(options AnomalyOptions) *AnomalyDetector {
+	options.Window = options.WindowSize()
+	if options.CooldownMinutes == 0 {
+		options.CooldownMinutes = defaultAnomalyCooldown
+	}
+
+	return &AnomalyDetector{
+		options:      options,
+		window:       make([]float64, 0, options.Window),
+		lastReported: make(map[AnomalyKind]time.Time),
+	}
+}
+
+// Seed fills the window with stored measurements, newest first (as returned by GetLastNMeasurements),
+// so detection works right after the server starts
+func (d *AnomalyDetector) Seed(measurements []*SensorMeasurement) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i := len(measurements) - 1; i >= 0; i-- {
+		d.add(measurements[i].MeasuredValue, measurements[i].MeasuredAt)
+	}
+}
+
+// Check returns anomalies of the value (which is then added to the window)
+func (d *AnomalyDetector) Check(value float64, at time.Time) []Anomaly {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	anomalies := []Anomaly{}
+
+	report := func(kind AnomalyKind, details string) {
+		cooldown := time.Duration(d.options.CooldownMinutes) * time.Minute
+		if last, ok := d.lastReported[kind]; ok && at.Sub(last) < cooldown {
+			return
+		}
+
+		d.lastReported[kind] = at
+		anomalies = append(anomalies, Anomaly{Kind: kind, Value: value, At: at, Details: details})
+	}
+
+	if d.options.Min != nil && value < *d.options.Min {
+		report(AnomalyOutOfRange, fmt.Sprintf("value %g is below minimum %g", value, *d.options.Min))
+	}
+
+	if d.options.Max != nil && value > *d.options.Max {
+		report(AnomalyOutOfRange, fmt.Sprintf("value %g is above maximum %g", value, *d.options.Max))
+	}
+
+	if d.options.ZScore > 0 && len(d.window) >= minAnomalyZScoreSamples {
+		mean, stdDev := d.stats()
+		// flat window makes any change infinitely unusual, stuck check covers that case
+		if stdDev > 0 {
+			if z := math.Abs(value-mean) / stdDev; z > d.options.ZScore {
+				report(AnomalyZScore, fmt.Sprintf("value %g is %.1f standard deviations from mean %.4g", value, z, mean))
+			}
+		}
+	}
+
+	d.add(value, at)
+
+	stuckFor := time.Duration(d.options.StuckMinutes) * time.Minute
+	if stuckFor > 0 && at.Sub(d.unchanged) >= stuckFor {
+		report(AnomalyStuck, fmt.Sprintf("value %g did not change since %s", value, d.unchanged.Format(time.RFC3339)))
+	}
+
+	return anomalies
+}
+
+func (d *AnomalyDetector) add(value float64, at time.Time) {
+	if !d.hasLast || value != d.last {
+		d.last = value
+		d.unchanged = at
+		d.hasLast = true
+	}
+
+	if len(d.window) < cap(d.window) {
+		d.window = append(d.window, value)
+		return
+	}
+
+	d.window[d.next] = value
+	d.next = (d.next + 1) % len(d.window)
+}
+
+// mean and population standard deviation of the window
+func (d *AnomalyDetector) stats() (float64, float64) {
+	n := float64(len(d.window))
+
+	var sum float64
+	for _, value := range d.window {
+		sum += value
+	}
+	mean := sum / n
+
+	var squares float64
+	for _, value := range d.window {
+		squares += (value - mean) * (value - mean)
+	}
+
+	return mean, math.Sqrt(squares / n)
+}
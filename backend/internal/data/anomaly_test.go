@@ -0,0 +1,104 @@
+package data_test
+
+import (
+	"inzynierka/internal/data"
+	"inzynierka/internal/data/validator"
+	"testing"
+	"time"
+)
+
+func kinds(anomalies []data.Anomaly) []data.AnomalyKind {
+	result := []data.AnomalyKind{}
+	for _, anomaly := range anomalies {
+		result = append(result, anomaly.Kind)
+	}
+	return result
+}
+
+func TestAnomalyDetectorZScore(t *testing.T) {
+	detector := data.NewAnomalyDetector(data.AnomalyOptions{ZScore: 3, Window: 20})
+	start := time.Now()
+
+	for i := 0; i < 20; i++ {
+		value := 4.0 + float64(i%3)*0.1
+		if anomalies := detector.Check(value, start.Add(time.Duration(i)*time.Minute)); len(anomalies) != 0 {
+			t.Fatalf("value %v: Expected no anomalies; Got: %v", value, kinds(anomalies))
+		}
+	}
+
+	anomalies := detector.Check(12, start.Add(20*time.Minute))
+	if len(anomalies) != 1 || anomalies[0].Kind != data.AnomalyZScore {
+		t.Fatalf("Expected: [%s]; Got: %v", data.AnomalyZScore, kinds(anomalies))
+	}
+
+	// same anomaly is not reported again until cooldown passes
+	if anomalies = detector.Check(15, start.Add(21*time.Minute)); len(anomalies) != 0 {
+		t.Errorf("Expected no anomalies during cooldown; Got: %v", kinds(anomalies))
+	}
+}
+
+func TestAnomalyDetectorStuckAndRange(t *testing.T) {
+	min, max := 0.0, 8.0
+	detector := data.NewAnomalyDetector(data.AnomalyOptions{StuckMinutes: 30, Min: &min, Max: &max, CooldownMinutes: 10})
+	start := time.Now()
+
+	detector.Seed([]*data.SensorMeasurement{
+		{MeasuredAt: start.Add(-10 * time.Minute), MeasuredValue: 5},
+		{MeasuredAt: start.Add(-20 * time.Minute), MeasuredValue: 4},
+	})
+
+	tests := []struct {
+		value    float64
+		at       time.Duration
+		expected []data.AnomalyKind
+	}{
+		{5, 0, []data.AnomalyKind{}},
+		{5, 15 * time.Minute, []data.AnomalyKind{}},
+		{5, 20 * time.Minute, []data.AnomalyKind{data.AnomalyStuck}},
+		{5, 25 * time.Minute, []data.AnomalyKind{}},
+		{9, 26 * time.Minute, []data.AnomalyKind{data.AnomalyOutOfRange}},
+		{-1, 30 * time.Minute, []data.AnomalyKind{}},
+		{-1, 40 * time.Minute, []data.AnomalyKind{data.AnomalyOutOfRange}},
+	}
+
+	for _, test := range tests {
+		got := kinds(detector.Check(test.value, start.Add(test.at)))
+		if len(got) != len(test.expected) || (len(got) > 0 && got[0] != test.expected[0]) {
+			t.Errorf("%v at %v: Expected: %v; Got: %v", test.value, test.at, test.expected, got)
+		}
+	}
+}
+
+func TestValidateAnomalyOptions(t *testing.T) {
+	min, max := 10.0, 5.0
+
+	tests := []struct {
+		sensorType data.SensorType
+		options    data.AnomalyOptions
+		valid      bool
+	}{
+		{data.DecimalSensor, data.AnomalyOptions{ZScore: 3, StuckMinutes: 60}, true},
+		{data.DecimalSensor, data.AnomalyOptions{ZScore: -1}, false},
+		{data.DecimalSensor, data.AnomalyOptions{ZScore: 3, Window: 5}, false},
+		{data.DecimalSensor, data.AnomalyOptions{Min: &min, Max: &max}, false},
+		{data.BinarySensor, data.AnomalyOptions{StuckMinutes: 60}, true},
+		{data.BinarySensor, data.AnomalyOptions{ZScore: 3}, false},
+	}
+
+	for _, test := range tests {
+		sensor := data.Sensor{
+			Name:        "sensor",
+			URI:         "192.168.1.10:9000",
+			Type:        test.sensorType,
+			RefreshRate: 5,
+			Transport:   data.TransportHTTP,
+			Anomaly:     &test.options,
+		}
+
+		v := validator.New()
+		data.ValidateSensor(v, &sensor)
+		if v.Valid() != test.valid {
+			t.Errorf("%+v: Expected valid: %v; Got errors: %v", test.options, test.valid, v.Errors)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"inzynierka/internal/data/validator"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrAreaNotEmpty = errors.New("area has sub-areas")
+)
+
+type AreaKind string
+
+const (
+	AreaHouse AreaKind = "house"
+	AreaFloor AreaKind = "floor"
+	AreaRoom  AreaKind = "room"
+)
+
+var AreaKinds = []AreaKind{
+	AreaHouse,
+	AreaFloor,
+	AreaRoom,
+}
+
+const maxTags = 16
+
+// lowercase words joined with - or _, eg. "lights" or "ground-floor"
+var tagRX = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Area is a part of the house sensors, rules and sequences are assigned to.
+// Houses have no parent, floors belong to houses and rooms to floors (or directly to houses)
+type Area struct {
+	ID        uuid.UUID  `json:"id"`
+	Name      string     `json:"name"`
+	Kind      AreaKind   `json:"kind"`
+	ParentID  *uuid.UUID `json:"parent_id"`
+	CreatedAt time.Time  `json:"created_at"`
+	Version   int        `json:"version"`
+}
+
+// kinds of areas child of given kind can belong to
+func parentKinds(kind AreaKind) []AreaKind {
+	switch kind {
+	case AreaFloor:
+		return []AreaKind{AreaHouse}
+	case AreaRoom:
+		return []AreaKind{AreaHouse, AreaFloor}
+	}
+	return nil
+}
+
+// validates area against its parent (nil when it has none) and its current children,
+// kinds only allow nesting downwards so areas can not form a cycle
+func ValidateArea(v *validator.Validator, area *Area, parent *Area, children []*Area) {
+	v.Check(utf8.RuneCountInString(area.Name) > 0, "name", "must not be empty")
+	v.Check(utf8.RuneCountInString(area.Name) <= 64, "name", "must not be longer than 64 characters")
+
+	if !validator.PermittedValue(area.Kind, AreaKinds...) {
+		v.AddError("kind", "must be either 'house', 'floor' or 'room'")
+		return
+	}
+
+	switch {
+	case area.ParentID == nil:
+		v.Check(area.Kind == AreaHouse, "parent_id", fmt.Sprintf("must be provided for %s", area.Kind))
+	case parent == nil:
+		v.AddError("parent_id", "must reference existing area")
+	case area.Kind == AreaHouse:
+		v.AddError("parent_id", "must not be provided for house")
+	default:
+		v.Check(validator.PermittedValue(parent.Kind, parentKinds(area.Kind)...), "parent_id", fmt.Sprintf("must not reference %s for %s", parent.Kind, area.Kind))
+	}
+
+	for _, child := range children {
+		if !validator.PermittedValue(area.Kind, parentKinds(child.Kind)...) {
+			v.AddError("kind", fmt.Sprintf("must allow %s %q to stay in the area", child.Kind, child.Name))
+			break
+		}
+	}
+}
+
+func validateTags(v *validator.Validator, tags []string) {
+	v.Check(len(tags) <= maxTags, "tags", fmt.Sprintf("must not have more than %d tags", maxTags))
+	v.Check(validator.Unique(tags), "tags", "must not be repeated")
+
+	for i, tag := range tags {
+		field := fmt.Sprintf("tags[%d]", i)
+		v.Check(len(tag) <= 32, field, "must not be more than 32 bytes long")
+		v.Check(validator.Matches(tag, tagRX), field, "must be lowercase letters, digits, - or _")
+	}
+}
+
+// array columns (tags, options) can not be null, nil slices are stored as empty arrays
+func nonNilTags(tags []string) []string {
+	if tags == nil {
+		return []string{}
+	}
+	return tags
+}
+
+// GroupFilter selects sensors, rules or sequences by area (including its sub-areas) and tags (all of them)
+type GroupFilter struct {
+	Area *uuid.UUID
+	Tags []string
+}
+
+func (f GroupFilter) IsEmpty() bool {
+	return f.Area == nil && len(f.Tags) == 0
+}
+
+// apply adds conditions (over area_id and tags columns) matching the filter to the query
+func (f GroupFilter) apply(q *listQuery) {
+	if f.Area != nil {
+		q.where(`area_id IN (
+        WITH RECURSIVE subareas AS (
+            SELECT id FROM areas WHERE id = ` + q.arg(*f.Area) + `
+            UNION
+            SELECT areas.id FROM areas JOIN subareas ON areas.parent_id = subareas.id
+        )
+        SELECT id FROM subareas
+    )`)
+	}
+
+	if len(f.Tags) > 0 {
+		q.where("tags @> " + q.arg(f.Tags))
+	}
+}
+
+type AreaModel struct {
+	DB *pgxpool.Pool
+}
+
+func (m AreaModel) Insert(area *Area) error {
+	query := `INSERT INTO areas (id, name, kind, parent_id)
+	VALUES ($1, $2, $3, $4)
+	RETURNING created_at, version`
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return err
+	}
+
+	area.ID = id
+
+	args := []any{area.ID, area.Name, area.Kind, area.ParentID}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRow(ctx, query, args...).Scan(&area.CreatedAt, &area.Version)
+}
+
+func (m AreaModel) GetAll() ([]*Area, error) {
+	query := `SELECT id, name, kind, parent_id, created_at, version
+	FROM areas
+	ORDER BY name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	areas := []*Area{}
+
+	for rows.Next() {
+		var area Area
+
+		err := rows.Scan(&area.ID, &area.Name, &area.Kind, &area.ParentID, &area.CreatedAt, &area.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		areas = append(areas, &area)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return areas, nil
+}
+
+// GetAllAreas returns page of areas, searched by name
+func (m AreaModel) GetAllAreas(filters Filters) ([]*Area, Metadata, error) {
+	q := &listQuery{}
+	filters.search(q, "name")
+	pagination := filters.pagination(q)
+
+	query := `SELECT count(*) OVER(), id, name, kind, parent_id, created_at, version
+	FROM areas
+	` + q.whereClause() + `
+	` + filters.orderBy() + `
+	` + pagination
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, q.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	areas := []*Area{}
+
+	for rows.Next() {
+		var area Area
+
+		err := rows.Scan(&totalRecords, &area.ID, &area.Name, &area.Kind, &area.ParentID, &area.CreatedAt, &area.Version)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		areas = append(areas, &area)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return areas, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func (m AreaModel) Get(id uuid.UUID) (*Area, error) {
+	query := `SELECT id, name, kind, parent_id, created_at, version
+	FROM areas
+	WHERE id = $1`
+
+	var area Area
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, id).Scan(
+		&area.ID,
+		&area.Name,
+		&area.Kind,
+		&area.ParentID,
+		&area.CreatedAt,
+		&area.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &area, nil
+}
+
+func (m AreaModel) Update(area *Area) error {
+	query := `UPDATE areas
+	SET name = $2, kind = $3, parent_id = $4, version = version + 1
+	WHERE id = $1 AND version = $5
+	RETURNING version`
+
+	args := []any{area.ID, area.Name, area.Kind, area.ParentID, area.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, args...).Scan(&area.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes the area, sensors, rules and sequences in it are left without area.
+// Returns ErrAreaNotEmpty if the area still has sub-areas
+func (m AreaModel) Delete(id uuid.UUID) error {
+	query := `DELETE FROM areas
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, id)
+	if err != nil {
+		switch {
+		case strings.Contains(err.Error(), "violates foreign key constraint \"areas_parent_id_fkey\""):
+			return ErrAreaNotEmpty
+		default:
+			return err
+		}
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package data_test
+
+import (
+	"inzynierka/internal/data"
+	"inzynierka/internal/data/validator"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+var (
+	house = &data.Area{ID: uuid.New(), Name: "Dom", Kind: data.AreaHouse}
+	floor = &data.Area{ID: uuid.New(), Name: "Parter", Kind: data.AreaFloor, ParentID: &house.ID}
+	room  = &data.Area{ID: uuid.New(), Name: "Salon", Kind: data.AreaRoom, ParentID: &floor.ID}
+)
+
+var areaValidationTests = []struct {
+	name     string
+	area     data.Area
+	parent   *data.Area
+	children []*data.Area
+	key      string
+}{
+	{"no name", data.Area{Kind: data.AreaHouse}, nil, nil, "name"},
+	{"unknown kind", data.Area{Name: "Garaż", Kind: "garage"}, nil, nil, "kind"},
+	{"room without parent", data.Area{Name: "Kuchnia", Kind: data.AreaRoom}, nil, nil, "parent_id"},
+	{"missing parent", data.Area{Name: "Kuchnia", Kind: data.AreaRoom, ParentID: &floor.ID}, nil, nil, "parent_id"},
+	{"house with parent", data.Area{Name: "Domek", Kind: data.AreaHouse, ParentID: &house.ID}, house, nil, "parent_id"},
+	{"floor in room", data.Area{Name: "Piętro", Kind: data.AreaFloor, ParentID: &room.ID}, room, nil, "parent_id"},
+	{"floor with floors", data.Area{ID: house.ID, Name: "Dom", Kind: data.AreaFloor}, nil, []*data.Area{floor}, "kind"},
+}
+
+func TestValidateArea(t *testing.T) {
+	for _, test := range areaValidationTests {
+		v := validator.New()
+		data.ValidateArea(v, &test.area, test.parent, test.children)
+
+		if _, ok := v.Errors[test.key]; !ok {
+			t.Errorf("%s: expected error for %q, got %v", test.name, test.key, v.Errors)
+		}
+	}
+
+	valid := []struct {
+		area     *data.Area
+		parent   *data.Area
+		children []*data.Area
+	}{
+		{house, nil, []*data.Area{floor}},
+		{floor, house, []*data.Area{room}},
+		{room, floor, nil},
+		{&data.Area{Name: "Garaż", Kind: data.AreaRoom, ParentID: &house.ID}, house, nil},
+	}
+
+	for _, test := range valid {
+		v := validator.New()
+		data.ValidateArea(v, test.area, test.parent, test.children)
+
+		if !v.Valid() {
+			t.Errorf("%s: expected valid area, got %v", test.area.Name, v.Errors)
+		}
+	}
+}
+
+func TestValidateTags(t *testing.T) {
+	tests := []struct {
+		tags []string
+		key  string
+	}{
+		{nil, ""},
+		{[]string{"lights", "ground-floor", "night_mode"}, ""},
+		{[]string{"Lights"}, "tags[0]"},
+		{[]string{"lights", "lights"}, "tags"},
+		{[]string{"lights", "-lights"}, "tags[1]"},
+	}
+
+	for _, test := range tests {
+		v := validator.New()
+		data.ValidateSequence(v, &data.Sequence{Name: "Noc", Tags: test.tags}, validationSensors)
+
+		if test.key == "" && !v.Valid() {
+			t.Errorf("%v: expected valid tags, got %v", test.tags, v.Errors)
+		}
+
+		if _, ok := v.Errors[test.key]; test.key != "" && !ok {
+			t.Errorf("%v: expected error for %q, got %v", test.tags, test.key, v.Errors)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package data
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrCronInvalidFormat = errors.New("cron expression must have 5 fields: minute hour day month weekday")
+	ErrCronInvalidField  = errors.New("cron expression contains invalid field")
+	ErrCronNoOccurrence  = errors.New("cron expression has no occurrence")
+)
+
+// Cron is a parsed standard 5 field cron expression (minute hour day month weekday).
+// Fields support "*", single values, ranges ("1-5"), lists ("1,3,5") and steps ("*/15", "8-18/2").
+// Weekdays are 0-7 with both 0 and 7 meaning sunday
+type Cron struct {
+	Format   string
+	Minutes  []int
+	Hours    []int
+	Days     []int
+	Months   []int
+	Weekdays []int
+	// standard cron semantics: if both day and weekday are restricted, either of them has to match
+	daysAny     bool
+	weekdaysAny bool
+}
+
+func parseCronField(field string, min, max int) ([]int, error) {
+	res := make([]int, 0)
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if rangePart, stepPart, ok := strings.Cut(part, "/"); ok {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step < 1 {
+				return nil, ErrCronInvalidField
+			}
+			part = rangePart
+		}
+
+		left, right := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			leftStr, rightStr, _ := strings.Cut(part, "-")
+
+			var err error
+			left, err = strconv.Atoi(leftStr)
+			if err != nil {
+				return nil, ErrCronInvalidField
+			}
+
+			right, err = strconv.Atoi(rightStr)
+			if err != nil {
+				return nil, ErrCronInvalidField
+			}
+		default:
+			x, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, ErrCronInvalidField
+			}
+
+			left = x
+			// "5/10" means every 10 starting from 5
+			right = x
+			if step > 1 {
+				right = max
+			}
+		}
+
+		if left < min || right > max || left > right {
+			return nil, ErrCronInvalidField
+		}
+
+		for i := left; i <= right; i += step {
+			if !slices.Contains(res, i) {
+				res = append(res, i)
+			}
+		}
+	}
+
+	slices.Sort(res)
+	return res, nil
+}
+
+func ParseCron(format string) (*Cron, error) {
+	fields := strings.Fields(format)
+	if len(fields) != 5 {
+		return nil, ErrCronInvalidFormat
+	}
+
+	minutes, err := parseCronField(fields[0], 0, 59)
+	if err != nil {
+		return nil, err
+	}
+
+	hours, err := parseCronField(fields[1], 0, 23)
+	if err != nil {
+		return nil, err
+	}
+
+	days, err := parseCronField(fields[2], 1, 31)
+	if err != nil {
+		return nil, err
+	}
+
+	months, err := parseCronField(fields[3], 1, 12)
+	if err != nil {
+		return nil, err
+	}
+
+	weekdays, err := parseCronField(fields[4], 0, 7)
+	if err != nil {
+		return nil, err
+	}
+
+	// 7 is an alias for sunday
+	if slices.Contains(weekdays, 7) {
+		weekdays = slices.DeleteFunc(weekdays, func(d int) bool { return d == 7 })
+		if !slices.Contains(weekdays, 0) {
+			weekdays = slices.Insert(weekdays, 0, 0)
+		}
+	}
+
+	return &Cron{
+		Format:      format,
+		Minutes:     minutes,
+		Hours:       hours,
+		Days:        days,
+		Months:      months,
+		Weekdays:    weekdays,
+		daysAny:     fields[2] == "*",
+		weekdaysAny: fields[4] == "*",
+	}, nil
+}
+
+func (c *Cron) matchesDay(t time.Time) bool {
+	dayOk := slices.Contains(c.Days, t.Day())
+	weekdayOk := slices.Contains(c.Weekdays, int(t.Weekday()))
+
+	switch {
+	case c.daysAny && c.weekdaysAny:
+		return true
+	case c.daysAny:
+		return weekdayOk
+	case c.weekdaysAny:
+		return dayOk
+	default:
+		return dayOk || weekdayOk
+	}
+}
+
+// Next returns the first occurrence strictly after given time, in its location
+func (c *Cron) Next(after time.Time) (time.Time, error) {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	// expressions like "0 0 30 2 *" never match, give up after a few years
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !slices.Contains(c.Months, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !slices.Contains(c.Hours, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !slices.Contains(c.Minutes, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t, nil
+	}
+
+	return time.Time{}, ErrCronNoOccurrence
+}
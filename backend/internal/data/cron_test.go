@@ -0,0 +1,170 @@
+package data_test
+
+import (
+	"inzynierka/internal/data"
+	"inzynierka/internal/data/validator"
+	"slices"
+	"testing"
+	"time"
+)
+
+var cronParseTests = []struct {
+	format   string
+	minutes  []int
+	weekdays []int
+}{
+	{"*/15 * * * *", []int{0, 15, 30, 45}, []int{0, 1, 2, 3, 4, 5, 6}},
+	{"5,10 6 * * 1-5", []int{5, 10}, []int{1, 2, 3, 4, 5}},
+	{"50/5 * * * 7", []int{50, 55}, []int{0}},
+	{"0 0 * * 0,7", []int{0}, []int{0}},
+}
+
+func TestParseCron(t *testing.T) {
+	for _, test := range cronParseTests {
+		cron, err := data.ParseCron(test.format)
+		if err != nil {
+			t.Errorf("%q: expected success, found %v", test.format, err)
+			continue
+		}
+
+		if !slices.Equal(cron.Minutes, test.minutes) {
+			t.Errorf("%q: Expected minutes: %v; Got: %v", test.format, test.minutes, cron.Minutes)
+		}
+
+		if !slices.Equal(cron.Weekdays, test.weekdays) {
+			t.Errorf("%q: Expected weekdays: %v; Got: %v", test.format, test.weekdays, cron.Weekdays)
+		}
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, format := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		if _, err := data.ParseCron(format); err == nil {
+			t.Errorf("%q: expected error", format)
+		}
+	}
+}
+
+var cronNextTests = []struct {
+	format   string
+	after    time.Time
+	expected time.Time
+}{
+	// friday evening -> monday morning
+	{"45 6 * * 1-5", time.Date(2024, 5, 10, 20, 0, 0, 0, time.UTC), time.Date(2024, 5, 13, 6, 45, 0, 0, time.UTC)},
+	// strictly after
+	{"0 * * * *", time.Date(2024, 5, 10, 20, 0, 0, 0, time.UTC), time.Date(2024, 5, 10, 21, 0, 0, 0, time.UTC)},
+	{"0 0 1 * *", time.Date(2024, 12, 15, 0, 0, 0, 0, time.UTC), time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+	{"0 12 29 2 *", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC)},
+	// day or weekday
+	{"0 8 15 * 1", time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 13, 8, 0, 0, 0, time.UTC)},
+}
+
+func TestCronNext(t *testing.T) {
+	for _, test := range cronNextTests {
+		cron, err := data.ParseCron(test.format)
+		if err != nil {
+			t.Fatalf("%q: expected success, found %v", test.format, err)
+		}
+
+		next, err := cron.Next(test.after)
+		if err != nil {
+			t.Errorf("%q: expected success, found %v", test.format, err)
+			continue
+		}
+
+		if !next.Equal(test.expected) {
+			t.Errorf("%q: Expected: %v; Got: %v", test.format, test.expected, next)
+		}
+	}
+
+	cron, _ := data.ParseCron("0 0 30 2 *")
+	if _, err := cron.Next(time.Now()); err == nil {
+		t.Error("expected error for expression without occurrence")
+	}
+}
+
+func TestScheduleNextRuns(t *testing.T) {
+	time.Local = time.UTC
+
+	skip := time.Date(2024, 5, 11, 7, 0, 0, 0, time.UTC)
+	schedule := data.Schedule{Type: data.ScheduleCron, Cron: "0 7 * * *", SkipAt: &skip}
+
+	runs := schedule.NextRuns(time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC), 2)
+	expected := []time.Time{
+		time.Date(2024, 5, 12, 7, 0, 0, 0, time.UTC),
+		time.Date(2024, 5, 13, 7, 0, 0, 0, time.UTC),
+	}
+
+	if !slices.EqualFunc(runs, expected, time.Time.Equal) {
+		t.Errorf("Expected: %v; Got: %v", expected, runs)
+	}
+
+	runAt := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	once := data.Schedule{Type: data.ScheduleOnce, RunAt: &runAt}
+
+	if runs := once.NextRuns(runAt.Add(-time.Minute), 5); len(runs) != 1 {
+		t.Errorf("got %d runs, wanted 1", len(runs))
+	}
+
+	if _, ok := once.Next(runAt); ok {
+		t.Error("one-shot schedule should not run after its time")
+	}
+}
+
+func TestScheduleTimezone(t *testing.T) {
+	schedule := data.Schedule{Type: data.ScheduleCron, Cron: "45 6 * * 1-5", Timezone: "Europe/Warsaw"}
+
+	// friday, 06:45 in Warsaw is 04:45 UTC in summer
+	next, ok := schedule.Next(time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC))
+	expected := time.Date(2024, 5, 10, 4, 45, 0, 0, time.UTC)
+
+	if !ok || !next.Equal(expected) {
+		t.Errorf("Expected: %v; Got: %v", expected, next)
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name     string
+		schedule data.Schedule
+		key      string
+	}{
+		{"missing run", data.Schedule{Type: data.ScheduleOnce}, "run_at"},
+		{"unknown time zone", data.Schedule{Type: data.ScheduleOnce, RunAt: &future, Timezone: "Mars/Olympus"}, "timezone"},
+		{"invalid cron", data.Schedule{Type: data.ScheduleCron, Cron: "* *"}, "cron"},
+	}
+
+	for _, test := range tests {
+		v := validator.New()
+		data.ValidateSchedule(v, &test.schedule)
+
+		if _, ok := v.Errors[test.key]; !ok {
+			t.Errorf("%s: expected error for %q, got %v", test.name, test.key, v.Errors)
+		}
+	}
+
+	v := validator.New()
+	data.ValidateSchedule(v, &data.Schedule{Type: data.ScheduleCron, Cron: "45 6 * * 1-5", Timezone: "Europe/Warsaw"})
+	if !v.Valid() {
+		t.Errorf("expected valid schedule, got %v", v.Errors)
+	}
+
+	// schedule which has already run stays valid, only its new time has to be in the future
+	ran := data.Schedule{Type: data.ScheduleOnce, RunAt: &past}
+
+	v = validator.New()
+	data.ValidateSchedule(v, &ran)
+	if !v.Valid() {
+		t.Errorf("expected valid schedule, got %v", v.Errors)
+	}
+
+	v = validator.New()
+	data.ValidateScheduleRunAt(v, &ran)
+	if _, ok := v.Errors["run_at"]; !ok {
+		t.Errorf("expected error for %q, got %v", "run_at", v.Errors)
+	}
+}
@@ -0,0 +1,520 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+var (
+	ErrNotADevice          = errors.New("address is not a household device")
+	ErrSubnetTooLarge      = errors.New("subnet is too large to scan")
+	ErrScanInProgress      = errors.New("scan already in progress")
+	ErrInvalidAnnouncement = errors.New("invalid device announcement")
+)
+
+type DiscoverySource string
+
+const (
+	DiscoveryScan         DiscoverySource = "scan"
+	DiscoveryAnnouncement DiscoverySource = "announcement"
+	DiscoveryMDNS         DiscoverySource = "mdns"
+)
+
+const (
+	// service advertised over mDNS by devices, instances are named after the device, eg. "Thermometer._household._tcp.local."
+	MDNSService = "_household._tcp.local."
+	// largest subnet scanned, /22 in IPv4
+	maxScanHosts = 1024
+	// how long responses to mDNS query are collected
+	mdnsWait = 2 * time.Second
+)
+
+var mdnsAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+type DiscoveryConfig struct {
+	// CIDR scanned for devices, eg. 192.168.1.0/24
+	Subnet string
+	// ports probed on every host of the subnet
+	Ports []int
+	// how long each candidate has to answer its /status endpoint
+	ProbeTimeout time.Duration
+	// candidates probed at once
+	Concurrency int
+}
+
+// DiscoveredDevice is a device answering its /status endpoint, uri is in the format used by http sensors
+type DiscoveredDevice struct {
+	URI  string     `json:"uri"`
+	Type SensorType `json:"type"`
+	// name announced by the device, empty for devices found by scanning
+	Name      string          `json:"name,omitempty"`
+	Source    DiscoverySource `json:"source"`
+	FirstSeen time.Time       `json:"first_seen"`
+	LastSeen  time.Time       `json:"last_seen"`
+}
+
+// Announcement is broadcast by devices over udp when they start, eg. `{"port": 80, "name": "Thermometer"}`
+type Announcement struct {
+	// port of device http api, 80 if missing
+	Port int    `json:"port"`
+	Name string `json:"name"`
+}
+
+func ParseAnnouncement(payload []byte) (Announcement, error) {
+	var announcement Announcement
+
+	if err := json.Unmarshal(payload, &announcement); err != nil {
+		return Announcement{}, fmt.Errorf("%w: %v", ErrInvalidAnnouncement, err)
+	}
+
+	if announcement.Port == 0 {
+		announcement.Port = 80
+	}
+
+	if announcement.Port < 0 || announcement.Port > 65535 {
+		return Announcement{}, fmt.Errorf("%w: port %d out of range", ErrInvalidAnnouncement, announcement.Port)
+	}
+
+	return announcement, nil
+}
+
+// MDNSDevice is an instance of MDNSService resolved to its address
+type MDNSDevice struct {
+	URI  string
+	Name string
+}
+
+// ParseMDNSResponse returns devices advertised in mDNS response, instances are resolved
+// using address records of the same message (devices send them as additional records)
+func ParseMDNSResponse(payload []byte) ([]MDNSDevice, error) {
+	var p dnsmessage.Parser
+
+	if _, err := p.Start(payload); err != nil {
+		return nil, err
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return nil, err
+	}
+
+	answers, err := p.AllAnswers()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.SkipAllAuthorities(); err != nil {
+		return nil, err
+	}
+	additionals, err := p.AllAdditionals()
+	if err != nil {
+		return nil, err
+	}
+
+	type service struct {
+		instance string
+		target   string
+		port     uint16
+	}
+
+	addrs := make(map[string]netip.Addr)
+	services := []service{}
+
+	for _, resource := range append(answers, additionals...) {
+		name := strings.ToLower(resource.Header.Name.String())
+
+		switch body := resource.Body.(type) {
+		case *dnsmessage.AResource:
+			addrs[name] = netip.AddrFrom4(body.A)
+		case *dnsmessage.SRVResource:
+			if strings.HasSuffix(name, "."+MDNSService) {
+				services = append(services, service{
+					instance: strings.TrimSuffix(resource.Header.Name.String(), "."+MDNSService),
+					target:   strings.ToLower(body.Target.String()),
+					port:     body.Port,
+				})
+			}
+		}
+	}
+
+	devices := []MDNSDevice{}
+
+	for _, s := range services {
+		addr, ok := addrs[s.target]
+		if !ok {
+			continue
+		}
+
+		devices = append(devices, MDNSDevice{URI: netip.AddrPortFrom(addr, s.port).String(), Name: s.instance})
+	}
+
+	return devices, nil
+}
+
+// PTR query for MDNSService, asking for unicast response
+func mdnsQuery() ([]byte, error) {
+	name, err := dnsmessage.NewName(MDNSService)
+	if err != nil {
+		return nil, err
+	}
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	if err = b.StartQuestions(); err != nil {
+		return nil, err
+	}
+
+	err = b.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET | 1<<15})
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Finish()
+}
+
+// SubnetHosts returns addresses of hosts in IPv4 subnet, without its network and broadcast address
+func SubnetHosts(subnet string) ([]netip.Addr, error) {
+	prefix, err := netip.ParsePrefix(subnet)
+	if err != nil {
+		return nil, err
+	}
+
+	if !prefix.Addr().Is4() {
+		return nil, fmt.Errorf("%s is not an IPv4 subnet", subnet)
+	}
+
+	prefix = prefix.Masked()
+
+	if size := 1 << (32 - prefix.Bits()); size > maxScanHosts {
+		return nil, fmt.Errorf("%w: %s has %d addresses, at most %d are scanned", ErrSubnetTooLarge, subnet, size, maxScanHosts)
+	}
+
+	hosts := []netip.Addr{}
+	for addr := prefix.Addr(); addr.IsValid() && prefix.Contains(addr); addr = addr.Next() {
+		hosts = append(hosts, addr)
+	}
+
+	// /31 and /32 subnets have no network and broadcast address
+	if len(hosts) > 2 {
+		hosts = hosts[1 : len(hosts)-1]
+	}
+
+	return hosts, nil
+}
+
+// ParsePorts parses comma separated ports and port ranges, eg. "80,8080,9000-9010"
+func ParsePorts(s string) ([]int, error) {
+	ports := []int{}
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		fromStr, toStr, isRange := strings.Cut(part, "-")
+		if !isRange {
+			toStr = fromStr
+		}
+
+		from, err := parsePort(fromStr)
+		if err != nil {
+			return nil, err
+		}
+		to, err := parsePort(toStr)
+		if err != nil {
+			return nil, err
+		}
+
+		if from > to {
+			return nil, fmt.Errorf("invalid port range %q", part)
+		}
+
+		for port := from; port <= to; port++ {
+			ports = append(ports, port)
+		}
+	}
+
+	if len(ports) == 0 {
+		return nil, errors.New("no ports given")
+	}
+
+	return ports, nil
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
+
+// Discovery finds devices by scanning the subnet, listening for their announcements and browsing mDNS,
+// every candidate is probed through its /status endpoint before it is reported
+type Discovery struct {
+	config    DiscoveryConfig
+	transport *HTTPTransport
+	// called (outside of the lock) when device is found for the first time
+	onFound func(DiscoveredDevice)
+
+	mu       sync.Mutex
+	devices  map[string]*DiscoveredDevice
+	scanning sync.Mutex
+}
+
+func NewDiscovery(config DiscoveryConfig, client *http.Client, onFound func(DiscoveredDevice)) *Discovery {
+	if config.ProbeTimeout == 0 {
+		config.ProbeTimeout = time.Second
+	}
+	if config.Concurrency == 0 {
+		config.Concurrency = 32
+	}
+
+	return &Discovery{
+		config:    config,
+		transport: NewHTTPTransport(client),
+		onFound:   onFound,
+		devices:   make(map[string]*DiscoveredDevice),
+	}
+}
+
+// Probe asks device at uri for its status, returns its sensor type if it is online
+func (d *Discovery) Probe(ctx context.Context, uri string) (SensorType, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.config.ProbeTimeout)
+	defer cancel()
+
+	return d.transport.deviceType(ctx, uri)
+}
+
+func (d *Discovery) found(uri string, sensorType SensorType, name string, source DiscoverySource) {
+	d.mu.Lock()
+
+	now := time.Now()
+	device, known := d.devices[uri]
+	if !known {
+		device = &DiscoveredDevice{URI: uri, FirstSeen: now}
+		d.devices[uri] = device
+	}
+
+	device.Type = sensorType
+	device.Source = source
+	device.LastSeen = now
+	if name != "" {
+		device.Name = name
+	}
+
+	found := *device
+	d.mu.Unlock()
+
+	if !known && d.onFound != nil {
+		d.onFound(found)
+	}
+}
+
+// probes the candidate and records it if it is a device
+func (d *Discovery) probeCandidate(ctx context.Context, uri, name string, source DiscoverySource) bool {
+	sensorType, err := d.Probe(ctx, uri)
+	if err != nil {
+		return false
+	}
+
+	d.found(uri, sensorType, name, source)
+	return true
+}
+
+// Devices returns found devices sorted by uri
+func (d *Discovery) Devices() []DiscoveredDevice {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	devices := make([]DiscoveredDevice, 0, len(d.devices))
+	for _, device := range d.devices {
+		devices = append(devices, *device)
+	}
+
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].URI < devices[j].URI
+	})
+
+	return devices
+}
+
+func (d *Discovery) Device(uri string) (DiscoveredDevice, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	device, ok := d.devices[uri]
+	if !ok {
+		return DiscoveredDevice{}, false
+	}
+	return *device, true
+}
+
+func (d *Discovery) Forget(uri string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.devices, uri)
+}
+
+// Refresh probes found devices again and forgets those no longer answering, returns how many were forgotten
+func (d *Discovery) Refresh(ctx context.Context) int {
+	forgotten := 0
+
+	for _, device := range d.Devices() {
+		if !d.probeCandidate(ctx, device.URI, "", device.Source) {
+			d.Forget(device.URI)
+			forgotten++
+		}
+	}
+
+	return forgotten
+}
+
+func (d *Discovery) Scanning() bool {
+	if d.scanning.TryLock() {
+		d.scanning.Unlock()
+		return false
+	}
+	return true
+}
+
+// Scan probes configured ports of every host in the subnet, returns number of devices that answered
+func (d *Discovery) Scan(ctx context.Context) (int, error) {
+	if !d.scanning.TryLock() {
+		return 0, ErrScanInProgress
+	}
+	defer d.scanning.Unlock()
+
+	hosts, err := SubnetHosts(d.config.Subnet)
+	if err != nil {
+		return 0, err
+	}
+
+	candidates := make(chan string)
+	var found atomic.Int32
+	var wg sync.WaitGroup
+
+	for range d.config.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for uri := range candidates {
+				if d.probeCandidate(ctx, uri, "", DiscoveryScan) {
+					found.Add(1)
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, host := range hosts {
+		for _, port := range d.config.Ports {
+			select {
+			case candidates <- netip.AddrPortFrom(host, uint16(port)).String():
+			case <-ctx.Done():
+				break feed
+			}
+		}
+	}
+
+	close(candidates)
+	wg.Wait()
+
+	return int(found.Load()), ctx.Err()
+}
+
+// ListenAnnouncements receives announcements broadcast to the port until ctx is cancelled,
+// the device is expected at the address announcement was sent from
+func (d *Discovery) ListenAnnouncements(ctx context.Context, port int) error {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: port})
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buf := make([]byte, 1500)
+
+	for {
+		n, addr, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		announcement, err := ParseAnnouncement(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		uri := netip.AddrPortFrom(addr.Addr().Unmap(), uint16(announcement.Port)).String()
+		go d.probeCandidate(ctx, uri, announcement.Name, DiscoveryAnnouncement)
+	}
+}
+
+// QueryMDNS asks for instances of MDNSService and probes devices answering within mdnsWait
+func (d *Discovery) QueryMDNS(ctx context.Context) error {
+	query, err := mdnsQuery()
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err = conn.WriteToUDP(query, mdnsAddr); err != nil {
+		return err
+	}
+
+	if err = conn.SetReadDeadline(time.Now().Add(mdnsWait)); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	buf := make([]byte, 9000)
+
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil
+			}
+			return err
+		}
+
+		devices, err := ParseMDNSResponse(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		for _, device := range devices {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				d.probeCandidate(ctx, device.URI, device.Name, DiscoveryMDNS)
+			}()
+		}
+	}
+}
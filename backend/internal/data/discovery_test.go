@@ -0,0 +1,155 @@
+package data_test
+
+import (
+	"context"
+	"errors"
+	"inzynierka/internal/data"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestSubnetHosts(t *testing.T) {
+	tests := []struct {
+		subnet string
+		first  string
+		last   string
+		count  int
+	}{
+		{"192.168.1.0/24", "192.168.1.1", "192.168.1.254", 254},
+		{"192.168.1.77/24", "192.168.1.1", "192.168.1.254", 254},
+		{"10.0.0.0/30", "10.0.0.1", "10.0.0.2", 2},
+		{"127.0.0.1/32", "127.0.0.1", "127.0.0.1", 1},
+	}
+
+	for _, test := range tests {
+		hosts, err := data.SubnetHosts(test.subnet)
+		if err != nil {
+			t.Fatalf("%s: %v", test.subnet, err)
+		}
+
+		if len(hosts) != test.count || hosts[0].String() != test.first || hosts[len(hosts)-1].String() != test.last {
+			t.Errorf("%s: expected %d hosts %s - %s; got %d hosts %v - %v", test.subnet, test.count, test.first, test.last, len(hosts), hosts[0], hosts[len(hosts)-1])
+		}
+	}
+
+	if _, err := data.SubnetHosts("10.0.0.0/8"); !errors.Is(err, data.ErrSubnetTooLarge) {
+		t.Errorf("Expected: %v; Got: %v", data.ErrSubnetTooLarge, err)
+	}
+
+	for _, subnet := range []string{"192.168.1.0", "fd00::/120"} {
+		if _, err := data.SubnetHosts(subnet); err == nil {
+			t.Errorf("%s: expected error", subnet)
+		}
+	}
+}
+
+func TestParsePorts(t *testing.T) {
+	ports, err := data.ParsePorts("80, 9000-9002")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if len(ports) != 4 || ports[0] != 80 || ports[3] != 9002 {
+		t.Errorf("Expected: [80 9000 9001 9002]; Got: %v", ports)
+	}
+
+	for _, invalid := range []string{"", "http", "0", "65536", "9002-9000"} {
+		if _, err := data.ParsePorts(invalid); err == nil {
+			t.Errorf("%q: expected error", invalid)
+		}
+	}
+}
+
+func TestParseAnnouncement(t *testing.T) {
+	announcement, err := data.ParseAnnouncement([]byte(`{"name": "Termometr"}`))
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if announcement.Port != 80 || announcement.Name != "Termometr" {
+		t.Errorf("Expected: {80 Termometr}; Got: %v", announcement)
+	}
+
+	for _, invalid := range []string{`status online`, `{"port": 70000}`} {
+		if _, err := data.ParseAnnouncement([]byte(invalid)); !errors.Is(err, data.ErrInvalidAnnouncement) {
+			t.Errorf("%s: Expected: %v; Got: %v", invalid, data.ErrInvalidAnnouncement, err)
+		}
+	}
+}
+
+func TestParseMDNSResponse(t *testing.T) {
+	instance := dnsmessage.MustNewName("Termometr." + data.MDNSService)
+	host := dnsmessage.MustNewName("termometr.local.")
+	unresolved := dnsmessage.MustNewName("Lampa." + data.MDNSService)
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{Response: true, Authoritative: true})
+	b.StartAnswers()
+	b.PTRResource(dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(data.MDNSService), Class: dnsmessage.ClassINET}, dnsmessage.PTRResource{PTR: instance})
+	b.SRVResource(dnsmessage.ResourceHeader{Name: instance, Class: dnsmessage.ClassINET}, dnsmessage.SRVResource{Target: host, Port: 9001})
+	b.SRVResource(dnsmessage.ResourceHeader{Name: unresolved, Class: dnsmessage.ClassINET}, dnsmessage.SRVResource{Target: dnsmessage.MustNewName("lampa.local."), Port: 80})
+	b.StartAdditionals()
+	b.AResource(dnsmessage.ResourceHeader{Name: host, Class: dnsmessage.ClassINET}, dnsmessage.AResource{A: [4]byte{192, 168, 1, 10}})
+
+	payload, err := b.Finish()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	devices, err := data.ParseMDNSResponse(payload)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if len(devices) != 1 || devices[0].URI != "192.168.1.10:9001" || devices[0].Name != "Termometr" {
+		t.Errorf("Expected: [{192.168.1.10:9001 Termometr}]; Got: %v", devices)
+	}
+}
+
+func TestDiscoveryScan(t *testing.T) {
+	device := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "online", "type": "decimal_sensor"}`))
+	}))
+	defer device.Close()
+
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status": "ok"}`))
+	}))
+	defer other.Close()
+
+	ports := []int{}
+	for _, server := range []*httptest.Server{device, other} {
+		addr := netip.MustParseAddrPort(strings.TrimPrefix(server.URL, "http://"))
+		ports = append(ports, int(addr.Port()))
+	}
+
+	found := []data.DiscoveredDevice{}
+	discovery := data.NewDiscovery(data.DiscoveryConfig{Subnet: "127.0.0.1/32", Ports: ports}, http.DefaultClient, func(device data.DiscoveredDevice) {
+		found = append(found, device)
+	})
+
+	count, err := discovery.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	uri := strings.TrimPrefix(device.URL, "http://")
+	if count != 1 || len(found) != 1 || found[0].URI != uri || found[0].Type != data.DecimalSensor || found[0].Source != data.DiscoveryScan {
+		t.Fatalf("Expected decimal sensor at %s; Got: %d %v", uri, count, found)
+	}
+
+	// found again by the next scan, but reported only once
+	if _, err = discovery.Scan(context.Background()); err != nil || len(found) != 1 || len(discovery.Devices()) != 1 {
+		t.Errorf("Expected single device; Got: %v %v", found, err)
+	}
+
+	device.Close()
+
+	if forgotten := discovery.Refresh(context.Background()); forgotten != 1 || len(discovery.Devices()) != 0 {
+		t.Errorf("Expected device to be forgotten; Got: %d %v", forgotten, discovery.Devices())
+	}
+}
@@ -0,0 +1,524 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidExpression  = errors.New("invalid expression")
+	ErrMissingSourceValue = errors.New("no value of source sensor")
+)
+
+// Expression computes value of virtual sensor from values of other sensors, referenced by id in braces,
+// eg. `avg({id1}, {id2}, {id3})` for average temperature or `{id1} * {id2}` for power from voltage and current.
+// Supports numbers, + - * / % ^, comparisons and && || ! (true is 1, false is 0) and functions listed in expressionFunctions
+type Expression struct {
+	root    exprNode
+	sources []uuid.UUID
+}
+
+type expressionFunction struct {
+	// minimum and maximum number of arguments, -1 for no maximum
+	minArgs, maxArgs int
+	call             func(args []float64) (float64, error)
+}
+
+var expressionFunctions = map[string]expressionFunction{
+	"avg": {1, -1, func(args []float64) (float64, error) {
+		sum := 0.0
+		for _, arg := range args {
+			sum += arg
+		}
+		return sum / float64(len(args)), nil
+	}},
+	"sum": {1, -1, func(args []float64) (float64, error) {
+		sum := 0.0
+		for _, arg := range args {
+			sum += arg
+		}
+		return sum, nil
+	}},
+	"min": {1, -1, func(args []float64) (float64, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Min(result, arg)
+		}
+		return result, nil
+	}},
+	"max": {1, -1, func(args []float64) (float64, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Max(result, arg)
+		}
+		return result, nil
+	}},
+	"abs":   {1, 1, func(args []float64) (float64, error) { return math.Abs(args[0]), nil }},
+	"sqrt":  {1, 1, func(args []float64) (float64, error) { return math.Sqrt(args[0]), nil }},
+	"ln":    {1, 1, func(args []float64) (float64, error) { return math.Log(args[0]), nil }},
+	"log10": {1, 1, func(args []float64) (float64, error) { return math.Log10(args[0]), nil }},
+	"exp":   {1, 1, func(args []float64) (float64, error) { return math.Exp(args[0]), nil }},
+	"floor": {1, 1, func(args []float64) (float64, error) { return math.Floor(args[0]), nil }},
+	"ceil":  {1, 1, func(args []float64) (float64, error) { return math.Ceil(args[0]), nil }},
+	"pow":   {2, 2, func(args []float64) (float64, error) { return math.Pow(args[0], args[1]), nil }},
+	// round(x) or round(x, decimals)
+	"round": {1, 2, func(args []float64) (float64, error) {
+		if len(args) == 1 {
+			return math.Round(args[0]), nil
+		}
+		scale := math.Pow(10, math.Round(args[1]))
+		return math.Round(args[0]*scale) / scale, nil
+	}},
+	// if(condition, then, else)
+	"if": {3, 3, func(args []float64) (float64, error) {
+		if args[0] != 0 {
+			return args[1], nil
+		}
+		return args[2], nil
+	}},
+}
+
+// ParseExpression parses expression of virtual sensor
+func ParseExpression(input string) (*Expression, error) {
+	tokens, err := tokenizeExpression(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &expressionParser{tokens: tokens, seen: make(map[uuid.UUID]struct{})}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind != tokenEnd {
+		return nil, p.errorf("unexpected %q", p.peek().text)
+	}
+
+	return &Expression{root: root, sources: p.sources}, nil
+}
+
+// Sources returns ids of sensors referenced by the expression, in order of first appearance
+func (e *Expression) Sources() []uuid.UUID {
+	return e.sources
+}
+
+// Eval computes the expression, values must contain every source
+func (e *Expression) Eval(values map[uuid.UUID]float64) (float64, error) {
+	value, err := e.root.eval(values)
+	if err != nil {
+		return 0, err
+	}
+
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, errors.New("expression result is not a finite number")
+	}
+
+	return value, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenNumber
+	tokenSensor
+	tokenIdent
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type expressionToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+var expressionOperators = []string{"&&", "||", "<=", ">=", "==", "!=", "+", "-", "*", "/", "%", "^", "<", ">", "!"}
+
+func tokenizeExpression(input string) ([]expressionToken, error) {
+	tokens := []expressionToken{}
+
+	for i := 0; i < len(input); {
+		c := rune(input[i])
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, expressionToken{tokenLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, expressionToken{tokenRParen, ")", i})
+			i++
+		case c == ',':
+			tokens = append(tokens, expressionToken{tokenComma, ",", i})
+			i++
+		case c == '{':
+			end := strings.IndexByte(input[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: unclosed { at %d", ErrInvalidExpression, i)
+			}
+			tokens = append(tokens, expressionToken{tokenSensor, input[i+1 : i+end], i})
+			i += end + 1
+		case unicode.IsDigit(c) || c == '.':
+			start := i
+			for i < len(input) && (unicode.IsDigit(rune(input[i])) || input[i] == '.') {
+				i++
+			}
+			// exponent, eg. 1e-3
+			if i < len(input) && (input[i] == 'e' || input[i] == 'E') {
+				i++
+				if i < len(input) && (input[i] == '+' || input[i] == '-') {
+					i++
+				}
+				for i < len(input) && unicode.IsDigit(rune(input[i])) {
+					i++
+				}
+			}
+			tokens = append(tokens, expressionToken{tokenNumber, input[start:i], start})
+		case unicode.IsLetter(c):
+			start := i
+			for i < len(input) && (unicode.IsLetter(rune(input[i])) || unicode.IsDigit(rune(input[i]))) {
+				i++
+			}
+			tokens = append(tokens, expressionToken{tokenIdent, input[start:i], start})
+		default:
+			matched := false
+			for _, op := range expressionOperators {
+				if strings.HasPrefix(input[i:], op) {
+					tokens = append(tokens, expressionToken{tokenOperator, op, i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("%w: unexpected %q at %d", ErrInvalidExpression, c, i)
+			}
+		}
+	}
+
+	return append(tokens, expressionToken{tokenEnd, "end of expression", len(input)}), nil
+}
+
+// recursive descent parser, from the lowest precedence: || && comparisons + - * / % unary ^
+type expressionParser struct {
+	tokens  []expressionToken
+	pos     int
+	sources []uuid.UUID
+	seen    map[uuid.UUID]struct{}
+}
+
+func (p *expressionParser) peek() expressionToken {
+	return p.tokens[p.pos]
+}
+
+func (p *expressionParser) next() expressionToken {
+	token := p.tokens[p.pos]
+	if token.kind != tokenEnd {
+		p.pos++
+	}
+	return token
+}
+
+func (p *expressionParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s at %d", ErrInvalidExpression, fmt.Sprintf(format, args...), p.peek().pos)
+}
+
+// parses left associative binary operators of one precedence level
+func (p *expressionParser) parseBinary(operand func() (exprNode, error), operators ...string) (exprNode, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		token := p.peek()
+		if token.kind != tokenOperator || !slices.Contains(operators, token.text) {
+			return left, nil
+		}
+		p.next()
+
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+
+		left = binaryNode{op: token.text, left: left, right: right}
+	}
+}
+
+func (p *expressionParser) parseOr() (exprNode, error) {
+	return p.parseBinary(p.parseAnd, "||")
+}
+
+func (p *expressionParser) parseAnd() (exprNode, error) {
+	return p.parseBinary(p.parseComparison, "&&")
+}
+
+func (p *expressionParser) parseComparison() (exprNode, error) {
+	return p.parseBinary(p.parseAdditive, "<", "<=", ">", ">=", "==", "!=")
+}
+
+func (p *expressionParser) parseAdditive() (exprNode, error) {
+	return p.parseBinary(p.parseMultiplicative, "+", "-")
+}
+
+func (p *expressionParser) parseMultiplicative() (exprNode, error) {
+	return p.parseBinary(p.parseUnary, "*", "/", "%")
+}
+
+func (p *expressionParser) parseUnary() (exprNode, error) {
+	token := p.peek()
+	if token.kind == tokenOperator && (token.text == "-" || token.text == "+" || token.text == "!") {
+		p.next()
+
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return unaryNode{op: token.text, operand: operand}, nil
+	}
+
+	return p.parsePower()
+}
+
+// power is right associative and binds tighter than unary minus, so -2^2 is -4
+func (p *expressionParser) parsePower() (exprNode, error) {
+	base, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	if token := p.peek(); token.kind == tokenOperator && token.text == "^" {
+		p.next()
+
+		exponent, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return binaryNode{op: "^", left: base, right: exponent}, nil
+	}
+
+	return base, nil
+}
+
+func (p *expressionParser) parsePrimary() (exprNode, error) {
+	token := p.peek()
+
+	switch token.kind {
+	case tokenNumber:
+		p.next()
+		value, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid number %q at %d", ErrInvalidExpression, token.text, token.pos)
+		}
+		return numberNode(value), nil
+
+	case tokenSensor:
+		p.next()
+		id, err := uuid.Parse(strings.TrimSpace(token.text))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q at %d is not a valid sensor id", ErrInvalidExpression, token.text, token.pos)
+		}
+		if _, ok := p.seen[id]; !ok {
+			p.seen[id] = struct{}{}
+			p.sources = append(p.sources, id)
+		}
+		return sensorNode(id), nil
+
+	case tokenIdent:
+		return p.parseCall()
+
+	case tokenLParen:
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokenRParen {
+			return nil, p.errorf("expected )")
+		}
+		p.next()
+		return inner, nil
+	}
+
+	return nil, p.errorf("unexpected %s", token.text)
+}
+
+func (p *expressionParser) parseCall() (exprNode, error) {
+	name := p.next()
+
+	function, ok := expressionFunctions[strings.ToLower(name.text)]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown function %q at %d", ErrInvalidExpression, name.text, name.pos)
+	}
+
+	if p.peek().kind != tokenLParen {
+		return nil, p.errorf("expected ( after %s", name.text)
+	}
+	p.next()
+
+	args := []exprNode{}
+
+	if p.peek().kind != tokenRParen {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+
+			if p.peek().kind != tokenComma {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if p.peek().kind != tokenRParen {
+		return nil, p.errorf("expected ) or ,")
+	}
+	p.next()
+
+	if len(args) < function.minArgs || (function.maxArgs >= 0 && len(args) > function.maxArgs) {
+		return nil, fmt.Errorf("%w: wrong number of arguments of %s at %d", ErrInvalidExpression, name.text, name.pos)
+	}
+
+	return callNode{function: function, args: args}, nil
+}
+
+type exprNode interface {
+	eval(values map[uuid.UUID]float64) (float64, error)
+}
+
+type numberNode float64
+
+func (n numberNode) eval(map[uuid.UUID]float64) (float64, error) {
+	return float64(n), nil
+}
+
+type sensorNode uuid.UUID
+
+func (n sensorNode) eval(values map[uuid.UUID]float64) (float64, error) {
+	value, ok := values[uuid.UUID(n)]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrMissingSourceValue, uuid.UUID(n))
+	}
+	return value, nil
+}
+
+type unaryNode struct {
+	op      string
+	operand exprNode
+}
+
+func (n unaryNode) eval(values map[uuid.UUID]float64) (float64, error) {
+	value, err := n.operand.eval(values)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case "-":
+		return -value, nil
+	case "!":
+		return boolToFloat(value == 0), nil
+	}
+
+	return value, nil
+}
+
+type binaryNode struct {
+	op          string
+	left, right exprNode
+}
+
+func (n binaryNode) eval(values map[uuid.UUID]float64) (float64, error) {
+	left, err := n.left.eval(values)
+	if err != nil {
+		return 0, err
+	}
+
+	right, err := n.right.eval(values)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.op {
+	case "+":
+		return left + right, nil
+	case "-":
+		return left - right, nil
+	case "*":
+		return left * right, nil
+	case "/":
+		if right == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return left / right, nil
+	case "%":
+		if right == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return math.Mod(left, right), nil
+	case "^":
+		return math.Pow(left, right), nil
+	case "<":
+		return boolToFloat(left < right), nil
+	case "<=":
+		return boolToFloat(left <= right), nil
+	case ">":
+		return boolToFloat(left > right), nil
+	case ">=":
+		return boolToFloat(left >= right), nil
+	case "==":
+		return boolToFloat(left == right), nil
+	case "!=":
+		return boolToFloat(left != right), nil
+	case "&&":
+		return boolToFloat(left != 0 && right != 0), nil
+	case "||":
+		return boolToFloat(left != 0 || right != 0), nil
+	}
+
+	return 0, fmt.Errorf("unknown operator %s", n.op)
+}
+
+type callNode struct {
+	function expressionFunction
+	args     []exprNode
+}
+
+func (n callNode) eval(values map[uuid.UUID]float64) (float64, error) {
+	args := make([]float64, len(n.args))
+	for i, arg := range n.args {
+		value, err := arg.eval(values)
+		if err != nil {
+			return 0, err
+		}
+		args[i] = value
+	}
+
+	return n.function.call(args)
+}
+
+func boolToFloat(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
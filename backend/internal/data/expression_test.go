@@ -0,0 +1,150 @@
+package data_test
+
+import (
+	"errors"
+	"inzynierka/internal/data"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestExpressionEval(t *testing.T) {
+	temperature, humidity := uuid.New(), uuid.New()
+	values := map[uuid.UUID]float64{temperature: 20, humidity: 50}
+
+	tests := []struct {
+		expression string
+		expected   float64
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"-2 ^ 2", -4},
+		{"2 ^ 3 ^ 2", 512},
+		{"10 % 4 - 1.5e1", -13},
+		{"avg({" + temperature.String() + "}, {" + humidity.String() + "})", 35},
+		{"max({" + temperature.String() + "}, 25, 3)", 25},
+		{"{" + temperature.String() + "} > 18 && !({" + humidity.String() + "} >= 60)", 1},
+		{"if({" + humidity.String() + "} == 50, 1, 2) || 0", 1},
+		{"round(ln(exp(2.345)), 2)", 2.35},
+	}
+
+	for _, test := range tests {
+		expression, err := data.ParseExpression(test.expression)
+		if err != nil {
+			t.Errorf("%s: Error: %v", test.expression, err)
+			continue
+		}
+
+		value, err := expression.Eval(values)
+		if err != nil {
+			t.Errorf("%s: Error: %v", test.expression, err)
+			continue
+		}
+
+		if math.Abs(value-test.expected) > 1e-9 {
+			t.Errorf("%s: Expected: %v; Got: %v", test.expression, test.expected, value)
+		}
+	}
+}
+
+func TestExpressionSources(t *testing.T) {
+	a, b := uuid.New(), uuid.New()
+
+	expression, err := data.ParseExpression("{" + a.String() + "} * { " + b.String() + " } / {" + a.String() + "}")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	sources := expression.Sources()
+	if len(sources) != 2 || sources[0] != a || sources[1] != b {
+		t.Errorf("Expected: [%s %s]; Got: %v", a, b, sources)
+	}
+
+	_, err = expression.Eval(map[uuid.UUID]float64{a: 1})
+	if !errors.Is(err, data.ErrMissingSourceValue) {
+		t.Errorf("Expected: %v; Got: %v", data.ErrMissingSourceValue, err)
+	}
+
+	if _, err = expression.Eval(map[uuid.UUID]float64{a: 0, b: 1}); err == nil {
+		t.Error("Expected division by zero error")
+	}
+}
+
+func TestInvalidExpressions(t *testing.T) {
+	tests := []string{
+		"",
+		"1 +",
+		"(1 + 2",
+		"{not-a-uuid}",
+		"{" + uuid.NewString(),
+		"unknown(1)",
+		"pow(1)",
+		"avg()",
+		"1 2",
+		"1 # 2",
+	}
+
+	for _, test := range tests {
+		if _, err := data.ParseExpression(test); !errors.Is(err, data.ErrInvalidExpression) {
+			t.Errorf("%q: Expected: %v; Got: %v", test, data.ErrInvalidExpression, err)
+		}
+	}
+}
+
+func TestSensorSetupOrder(t *testing.T) {
+	physical := &data.Sensor{ID: uuid.New(), Transport: data.TransportHTTP}
+	average := &data.Sensor{ID: uuid.New(), Transport: data.TransportVirtual, URI: "avg({" + physical.ID.String() + "}, 1)"}
+	// depends on another virtual sensor, which comes after it
+	scaled := &data.Sensor{ID: uuid.New(), Transport: data.TransportVirtual, URI: "{" + average.ID.String() + "} * 2"}
+
+	ordered := data.SensorSetupOrder([]*data.Sensor{scaled, average, physical})
+
+	if len(ordered) != 3 || ordered[0] != physical || ordered[1] != average || ordered[2] != scaled {
+		t.Errorf("Expected physical, average, scaled; Got: %v", ordered)
+	}
+}
+
+func TestVirtualUnsubscribeFromStoppedSource(t *testing.T) {
+	source := &data.Sensor{ID: uuid.New(), Active: true, Transport: data.TransportLineProtocol, Type: data.DecimalSensor}
+	listener := data.NewListener(source, data.NewLineProtocolTransport(), nil, func(value, raw data.SensorValue, at time.Time) error {
+		return nil
+	})
+	if err := listener.Start(); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	listeners := data.NewSensorListeners()
+	listeners.Set(source.ID, listener)
+	transport := data.NewVirtualTransport(listeners)
+
+	unsubscribes := []func(){}
+	for i := 0; i < 3; i++ {
+		dependent := &data.Sensor{ID: uuid.New(), Transport: data.TransportVirtual, URI: "{" + source.ID.String() + "} * 2"}
+		unsubscribe, err := transport.Subscribe(dependent, func(value data.SensorValue, at time.Time) error { return nil })
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		unsubscribes = append(unsubscribes, unsubscribe)
+	}
+
+	// source is replaced before its dependents are restarted
+	listener.Stop()
+	// broker is stopped in the background
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, unsubscribe := range unsubscribes {
+			unsubscribe()
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("unsubscribing dependents of stopped source blocked")
+	}
+}
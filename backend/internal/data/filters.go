@@ -0,0 +1,135 @@
+package data
+
+import (
+	"fmt"
+	"inzynierka/internal/data/validator"
+	"math"
+	"strings"
+)
+
+const MaxPageSize = 100
+
+// Filters paginate, sort and search list queries
+type Filters struct {
+	Page int
+	// 0 returns all records on a single page
+	PageSize int
+	// column name, descending when prefixed with -
+	Sort         string
+	SortSafelist []string
+	// case insensitive part of the name
+	Search string
+}
+
+func ValidateFilters(v *validator.Validator, f Filters) {
+	v.Check(f.Page > 0, "page", "must be greater than zero")
+	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
+	v.Check(f.PageSize >= 0, "page_size", "must not be negative")
+	v.Check(f.PageSize <= MaxPageSize, "page_size", fmt.Sprintf("must be a maximum of %d", MaxPageSize))
+	v.Check(f.PageSize > 0 || f.Page == 1, "page", "must be 1 when page_size is missing")
+	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "must be one of "+strings.Join(f.SortSafelist, ", "))
+	v.Check(len(f.Search) <= 255, "search", "must not be more than 255 bytes long")
+}
+
+// sortColumn returns column to sort by, Sort must be validated against SortSafelist first
+func (f Filters) sortColumn() string {
+	for _, safeValue := range f.SortSafelist {
+		if f.Sort == safeValue {
+			return strings.TrimPrefix(f.Sort, "-")
+		}
+	}
+
+	panic("unsafe sort parameter: " + f.Sort)
+}
+
+func (f Filters) sortDirection() string {
+	if strings.HasPrefix(f.Sort, "-") {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+// orderBy sorts by the selected column, records with equal values are ordered by id so pages are stable
+func (f Filters) orderBy() string {
+	return fmt.Sprintf("ORDER BY %s %s, id ASC", f.sortColumn(), f.sortDirection())
+}
+
+// nil (no limit) when all records are returned
+func (f Filters) limit() *int {
+	if f.PageSize == 0 {
+		return nil
+	}
+	return &f.PageSize
+}
+
+func (f Filters) offset() int {
+	return (f.Page - 1) * f.PageSize
+}
+
+// pagination appends LIMIT and OFFSET of the page to the query
+func (f Filters) pagination(q *listQuery) string {
+	return fmt.Sprintf("LIMIT %s OFFSET %s", q.arg(f.limit()), q.arg(f.offset()))
+}
+
+// search adds condition matching column containing Search, if there is one
+func (f Filters) search(q *listQuery, column string) {
+	if f.Search == "" {
+		return
+	}
+
+	pattern := "%" + likeEscaper.Replace(f.Search) + "%"
+	q.where(fmt.Sprintf("%s ILIKE %s", column, q.arg(pattern)))
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// Metadata describes the page returned by list queries
+type Metadata struct {
+	CurrentPage  int `json:"current_page"`
+	PageSize     int `json:"page_size"`
+	FirstPage    int `json:"first_page"`
+	LastPage     int `json:"last_page"`
+	TotalRecords int `json:"total_records"`
+}
+
+func calculateMetadata(totalRecords, page, pageSize int) Metadata {
+	if totalRecords == 0 {
+		return Metadata{}
+	}
+
+	if pageSize == 0 {
+		return Metadata{CurrentPage: 1, PageSize: totalRecords, FirstPage: 1, LastPage: 1, TotalRecords: totalRecords}
+	}
+
+	return Metadata{
+		CurrentPage:  page,
+		PageSize:     pageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(totalRecords) / float64(pageSize))),
+		TotalRecords: totalRecords,
+	}
+}
+
+// listQuery collects conditions of list queries together with their arguments
+type listQuery struct {
+	conditions []string
+	args       []any
+}
+
+// arg adds argument to the query and returns its placeholder
+func (q *listQuery) arg(value any) string {
+	q.args = append(q.args, value)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+func (q *listQuery) where(condition string) {
+	q.conditions = append(q.conditions, condition)
+}
+
+// whereClause joins all conditions, matching every record if there are none
+func (q *listQuery) whereClause() string {
+	if len(q.conditions) == 0 {
+		return "WHERE TRUE"
+	}
+	return "WHERE " + strings.Join(q.conditions, " AND ")
+}
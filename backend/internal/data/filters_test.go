@@ -0,0 +1,40 @@
+package data_test
+
+import (
+	"inzynierka/internal/data"
+	"inzynierka/internal/data/validator"
+	"testing"
+)
+
+func TestValidateFilters(t *testing.T) {
+	safelist := []string{"id", "-id", "name", "-name"}
+
+	tests := []struct {
+		name    string
+		filters data.Filters
+		key     string
+	}{
+		{"all records", data.Filters{Page: 1, Sort: "id"}, ""},
+		{"second page", data.Filters{Page: 2, PageSize: 20, Sort: "-name"}, ""},
+		{"zero page", data.Filters{Page: 0, PageSize: 20, Sort: "id"}, "page"},
+		{"page without page size", data.Filters{Page: 2, Sort: "id"}, "page"},
+		{"page size too big", data.Filters{Page: 1, PageSize: data.MaxPageSize + 1, Sort: "id"}, "page_size"},
+		{"negative page size", data.Filters{Page: 1, PageSize: -1, Sort: "id"}, "page_size"},
+		{"unsafe sort", data.Filters{Page: 1, Sort: "id; DROP TABLE sensors"}, "sort"},
+	}
+
+	for _, test := range tests {
+		test.filters.SortSafelist = safelist
+
+		v := validator.New()
+		data.ValidateFilters(v, test.filters)
+
+		if test.key == "" && !v.Valid() {
+			t.Errorf("%s: expected valid filters, got %v", test.name, v.Errors)
+		}
+
+		if _, ok := v.Errors[test.key]; test.key != "" && !ok {
+			t.Errorf("%s: expected error for %q, got %v", test.name, test.key, v.Errors)
+		}
+	}
+}
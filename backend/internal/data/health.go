@@ -0,0 +1,192 @@
+package data
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrSensorSilent = errors.New("sensor has not sent any value recently")
+)
+
+type SensorHealthStatus string
+
+const (
+	// nothing has been heard from the sensor since the server started
+	HealthUnknown SensorHealthStatus = "unknown"
+	HealthOnline  SensorHealthStatus = "online"
+	HealthOffline SensorHealthStatus = "offline"
+)
+
+// SensorHealth describes whether the sensor is reachable, based on its polls, pushed values and status checks
+type SensorHealth struct {
+	Status SensorHealthStatus `json:"status"`
+	// nil if nothing has been heard from the sensor yet
+	LastSeen            *time.Time `json:"last_seen"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	// duration of the last successful request in milliseconds, nil for sensors which only push values
+	LatencyMs *float64 `json:"latency_ms"`
+	// cause of the last failure, empty once the sensor answers again
+	LastError string `json:"last_error,omitempty"`
+	// when silence of sensor never seen started to be checked
+	watchedSince time.Time
+}
+
+// HealthChange is reported when status of the sensor changes
+type HealthChange struct {
+	SensorID uuid.UUID
+	Previous SensorHealthStatus
+	Health   SensorHealth
+}
+
+type HealthConfig struct {
+	// failed checks in a row after which the sensor is offline
+	FailureThreshold int
+	// sensor which only pushes values fails its check when nothing was pushed for this long
+	SilenceTimeout time.Duration
+}
+
+// HealthTracker keeps health of every sensor, methods of nil tracker do nothing
+type HealthTracker struct {
+	config HealthConfig
+	// called (outside of the lock) whenever status of the sensor changes
+	onChange func(HealthChange)
+
+	mu      sync.Mutex
+	sensors map[uuid.UUID]*SensorHealth
+}
+
+func NewHealthTracker(config HealthConfig, onChange func(HealthChange)) *HealthTracker {
+	if config.FailureThreshold < 1 {
+		config.FailureThreshold = 3
+	}
+	if config.SilenceTimeout == 0 {
+		config.SilenceTimeout = 10 * time.Minute
+	}
+
+	return &HealthTracker{
+		config:   config,
+		onChange: onChange,
+		sensors:  make(map[uuid.UUID]*SensorHealth),
+	}
+}
+
+// returns health of the sensor, creating unknown one if there is none, mu must be held
+func (h *HealthTracker) health(id uuid.UUID) *SensorHealth {
+	health, ok := h.sensors[id]
+	if !ok {
+		health = &SensorHealth{Status: HealthUnknown}
+		h.sensors[id] = health
+	}
+	return health
+}
+
+// updates health of the sensor and reports status change
+func (h *HealthTracker) update(id uuid.UUID, apply func(health *SensorHealth)) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	health := h.health(id)
+	previous := health.Status
+	apply(health)
+	change := HealthChange{SensorID: id, Previous: previous, Health: *health}
+	h.mu.Unlock()
+
+	if change.Health.Status != previous && h.onChange != nil {
+		h.onChange(change)
+	}
+}
+
+// Success records request the sensor answered in given time
+func (h *HealthTracker) Success(id uuid.UUID, latency time.Duration) {
+	ms := float64(latency.Microseconds()) / 1000
+
+	h.update(id, func(health *SensorHealth) {
+		health.LatencyMs = &ms
+		health.seen()
+	})
+}
+
+// Seen records value pushed by the sensor
+func (h *HealthTracker) Seen(id uuid.UUID) {
+	h.update(id, (*SensorHealth).seen)
+}
+
+func (health *SensorHealth) seen() {
+	now := time.Now()
+	health.LastSeen = &now
+	health.ConsecutiveFailures = 0
+	health.LastError = ""
+	health.Status = HealthOnline
+}
+
+// Failure records failed request, the sensor is offline once it fails FailureThreshold times in a row
+func (h *HealthTracker) Failure(id uuid.UUID, err error) {
+	if h == nil {
+		return
+	}
+
+	h.update(id, func(health *SensorHealth) {
+		health.ConsecutiveFailures++
+		health.LastError = err.Error()
+
+		if health.ConsecutiveFailures >= h.config.FailureThreshold {
+			health.Status = HealthOffline
+		}
+	})
+}
+
+// CheckSilence records failure of sensor which only pushes values if it has not pushed any for SilenceTimeout,
+// the timeout starts with the first check of sensor never seen
+func (h *HealthTracker) CheckSilence(id uuid.UUID, now time.Time) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	health := h.health(id)
+	if health.watchedSince.IsZero() {
+		health.watchedSince = now
+	}
+	lastSeen := health.watchedSince
+	if health.LastSeen != nil {
+		lastSeen = *health.LastSeen
+	}
+	h.mu.Unlock()
+
+	if now.Sub(lastSeen) >= h.config.SilenceTimeout {
+		h.Failure(id, ErrSensorSilent)
+	}
+}
+
+// Get returns health of the sensor, unknown if nothing has been recorded
+func (h *HealthTracker) Get(id uuid.UUID) SensorHealth {
+	if h == nil {
+		return SensorHealth{Status: HealthUnknown}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	health, ok := h.sensors[id]
+	if !ok {
+		return SensorHealth{Status: HealthUnknown}
+	}
+	return *health
+}
+
+func (h *HealthTracker) Forget(id uuid.UUID) {
+	if h == nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.sensors, id)
+}
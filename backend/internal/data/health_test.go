@@ -0,0 +1,92 @@
+package data_test
+
+import (
+	"errors"
+	"inzynierka/internal/data"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestHealthTracker(t *testing.T) {
+	changes := []data.HealthChange{}
+	tracker := data.NewHealthTracker(data.HealthConfig{FailureThreshold: 2}, func(change data.HealthChange) {
+		changes = append(changes, change)
+	})
+
+	id := uuid.New()
+
+	if health := tracker.Get(id); health.Status != data.HealthUnknown || health.LastSeen != nil {
+		t.Fatalf("Expected unknown health; Got: %+v", health)
+	}
+
+	tracker.Success(id, 15*time.Millisecond)
+
+	health := tracker.Get(id)
+	if health.Status != data.HealthOnline || health.LastSeen == nil || health.LatencyMs == nil || *health.LatencyMs != 15 {
+		t.Fatalf("Expected online sensor with 15ms latency; Got: %+v", health)
+	}
+
+	tracker.Failure(id, errors.New("connection refused"))
+
+	if health = tracker.Get(id); health.Status != data.HealthOnline || health.ConsecutiveFailures != 1 {
+		t.Fatalf("Expected online sensor after single failure; Got: %+v", health)
+	}
+
+	tracker.Failure(id, errors.New("connection refused"))
+
+	if health = tracker.Get(id); health.Status != data.HealthOffline || health.LastError != "connection refused" {
+		t.Fatalf("Expected offline sensor; Got: %+v", health)
+	}
+
+	tracker.Seen(id)
+
+	if health = tracker.Get(id); health.Status != data.HealthOnline || health.ConsecutiveFailures != 0 || health.LastError != "" {
+		t.Fatalf("Expected sensor back online; Got: %+v", health)
+	}
+
+	expected := []data.SensorHealthStatus{data.HealthUnknown, data.HealthOnline, data.HealthOffline}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes; Got: %+v", len(expected), changes)
+	}
+	for i, previous := range expected {
+		if changes[i].Previous != previous || changes[i].SensorID != id {
+			t.Errorf("change %d: expected previous status %s; Got: %+v", i, previous, changes[i])
+		}
+	}
+}
+
+func TestHealthTrackerSilence(t *testing.T) {
+	tracker := data.NewHealthTracker(data.HealthConfig{FailureThreshold: 1, SilenceTimeout: time.Minute}, nil)
+	id := uuid.New()
+	now := time.Now()
+
+	// timeout of sensor never seen starts with its first check
+	tracker.CheckSilence(id, now)
+	tracker.CheckSilence(id, now.Add(30*time.Second))
+
+	if health := tracker.Get(id); health.Status != data.HealthUnknown || health.LastSeen != nil {
+		t.Fatalf("Expected unknown health; Got: %+v", health)
+	}
+
+	tracker.CheckSilence(id, now.Add(time.Minute))
+
+	if health := tracker.Get(id); health.Status != data.HealthOffline || health.LastError != data.ErrSensorSilent.Error() {
+		t.Fatalf("Expected silent sensor to be offline; Got: %+v", health)
+	}
+
+	tracker.Seen(id)
+	tracker.CheckSilence(id, time.Now())
+
+	if health := tracker.Get(id); health.Status != data.HealthOnline {
+		t.Errorf("Expected sensor which pushed value to be online; Got: %+v", health)
+	}
+
+	// nil tracker records nothing
+	var disabled *data.HealthTracker
+	disabled.Failure(id, data.ErrSensorSilent)
+	if health := disabled.Get(id); health.Status != data.HealthUnknown {
+		t.Errorf("Expected unknown health; Got: %+v", health)
+	}
+}
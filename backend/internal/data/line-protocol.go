@@ -0,0 +1,339 @@
+package data
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidLineProtocol = errors.New("invalid line protocol")
+)
+
+// timestamp precisions accepted by InfluxDB 1.x and 2.x write endpoints
+var LineProtocolPrecisions = map[string]time.Duration{
+	"n":  time.Nanosecond,
+	"ns": time.Nanosecond,
+	"u":  time.Microsecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+}
+
+type LineProtocolTag struct {
+	Key   string
+	Value string
+}
+
+// LineProtocolPoint is a single numeric field of a line protocol line,
+// every field of a line becomes separate point (and separate sensor)
+type LineProtocolPoint struct {
+	Measurement string
+	// sorted by key
+	Tags  []LineProtocolTag
+	Field string
+	Value float64
+	// value was written as boolean, it is stored as 1 or 0
+	IsBool bool
+	Time   time.Time
+}
+
+// SensorValue returns value of the point as passed to its sensor
+func (p *LineProtocolPoint) SensorValue() SensorValue {
+	if p.IsBool {
+		return BooleanValue(p.Value != 0)
+	}
+	return DecimalValue(p.Value)
+}
+
+// SeriesKey identifies the sensor point belongs to: escaped measurement and tags
+// (in line protocol syntax, sorted by key) followed by a space and escaped field key,
+// eg. `weather,location=garden temperature`
+func (p *LineProtocolPoint) SeriesKey() string {
+	var b strings.Builder
+
+	b.WriteString(escapeLineProtocol(p.Measurement, ", "))
+	for _, tag := range p.Tags {
+		b.WriteByte(',')
+		b.WriteString(escapeLineProtocol(tag.Key, ",= "))
+		b.WriteByte('=')
+		b.WriteString(escapeLineProtocol(tag.Value, ",= "))
+	}
+	b.WriteByte(' ')
+	b.WriteString(escapeLineProtocol(p.Field, ",= "))
+
+	return b.String()
+}
+
+// ParseSeriesKey parses series key of a point, the key has to be in canonical form (as returned by SeriesKey)
+func ParseSeriesKey(key string) (*LineProtocolPoint, error) {
+	sections := splitLineProtocol(key, ' ', false)
+	if len(sections) != 2 {
+		return nil, errors.New("series key must consist of measurement with tags and field key separated by a space")
+	}
+
+	point := &LineProtocolPoint{}
+
+	err := parseLineProtocolSeries(sections[0], point)
+	if err != nil {
+		return nil, err
+	}
+
+	point.Field = unescapeLineProtocol(sections[1])
+	if point.Field == "" {
+		return nil, errors.New("field key must not be empty")
+	}
+
+	if point.SeriesKey() != key {
+		return nil, errors.New("series key must be escaped and have tags sorted by key")
+	}
+
+	return point, nil
+}
+
+// LineProtocolReader returns numeric points one by one, io.EOF signals the end of input.
+// String fields are not supported by sensors, they are counted in Skipped
+type LineProtocolReader struct {
+	scanner   *bufio.Scanner
+	precision time.Duration
+	now       time.Time
+	line      int
+	pending   []LineProtocolPoint
+	Skipped   int
+}
+
+// points without timestamp are given time now
+func NewLineProtocolReader(r io.Reader, precision time.Duration, now time.Time) *LineProtocolReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	return &LineProtocolReader{scanner: scanner, precision: precision, now: now}
+}
+
+func (r *LineProtocolReader) Read() (*LineProtocolPoint, error) {
+	for len(r.pending) == 0 {
+		if !r.scanner.Scan() {
+			if err := r.scanner.Err(); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidLineProtocol, err)
+			}
+			return nil, io.EOF
+		}
+		r.line++
+
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		points, skipped, err := parseLineProtocolLine(line, r.precision, r.now)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidLineProtocol, r.line, err)
+		}
+
+		r.pending = points
+		r.Skipped += skipped
+	}
+
+	point := r.pending[0]
+	r.pending = r.pending[1:]
+
+	return &point, nil
+}
+
+func parseLineProtocolLine(line string, precision time.Duration, now time.Time) ([]LineProtocolPoint, int, error) {
+	// quotes are literal in measurement and tags, they only delimit string field values
+	seriesSection := splitLineProtocol(line, ' ', false)[0]
+	sections := splitLineProtocol(strings.TrimPrefix(line, seriesSection), ' ', true)[1:]
+	if len(sections) < 1 || len(sections) > 2 {
+		return nil, 0, errors.New("line must consist of measurement with tags, fields and optional timestamp")
+	}
+
+	var series LineProtocolPoint
+
+	err := parseLineProtocolSeries(seriesSection, &series)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	series.Time = now
+	if len(sections) == 2 {
+		timestamp, err := strconv.ParseInt(sections[1], 10, 64)
+		if err != nil {
+			return nil, 0, errors.New("timestamp must be an integer")
+		}
+		if timestamp > math.MaxInt64/int64(precision) || timestamp < math.MinInt64/int64(precision) {
+			return nil, 0, errors.New("timestamp is out of range")
+		}
+		series.Time = time.Unix(0, timestamp*int64(precision))
+	}
+
+	points := []LineProtocolPoint{}
+	skipped := 0
+
+	for _, field := range splitLineProtocol(sections[0], ',', true) {
+		key, value, ok := cutLineProtocol(field)
+		if !ok || key == "" || value == "" {
+			return nil, 0, fmt.Errorf("field %q must be in key=value form", field)
+		}
+
+		// strings can not be stored as measurements
+		if strings.HasPrefix(value, `"`) {
+			if len(value) < 2 || !strings.HasSuffix(value, `"`) {
+				return nil, 0, fmt.Errorf("field %q has unterminated string value", key)
+			}
+			skipped++
+			continue
+		}
+
+		point := series
+		point.Field = unescapeLineProtocol(key)
+
+		point.Value, point.IsBool, err = parseLineProtocolValue(value)
+		if err != nil {
+			return nil, 0, fmt.Errorf("field %q: %v", point.Field, err)
+		}
+
+		points = append(points, point)
+	}
+
+	return points, skipped, nil
+}
+
+// parses measurement and tags into given point
+func parseLineProtocolSeries(section string, point *LineProtocolPoint) error {
+	parts := splitLineProtocol(section, ',', false)
+
+	point.Measurement = unescapeLineProtocol(parts[0])
+	if point.Measurement == "" {
+		return errors.New("measurement must not be empty")
+	}
+
+	point.Tags = make([]LineProtocolTag, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		key, value, ok := cutLineProtocol(part)
+		if !ok || key == "" || value == "" {
+			return fmt.Errorf("tag %q must be in key=value form", part)
+		}
+
+		point.Tags = append(point.Tags, LineProtocolTag{Key: unescapeLineProtocol(key), Value: unescapeLineProtocol(value)})
+	}
+
+	slices.SortFunc(point.Tags, func(a, b LineProtocolTag) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+
+	return nil
+}
+
+func parseLineProtocolValue(value string) (float64, bool, error) {
+	switch value {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, true, nil
+	}
+
+	var number float64
+
+	switch {
+	case strings.HasSuffix(value, "i"):
+		integer, err := strconv.ParseInt(strings.TrimSuffix(value, "i"), 10, 64)
+		if err != nil {
+			return 0, false, errors.New("value is not a valid integer")
+		}
+		number = float64(integer)
+	case strings.HasSuffix(value, "u"):
+		integer, err := strconv.ParseUint(strings.TrimSuffix(value, "u"), 10, 64)
+		if err != nil {
+			return 0, false, errors.New("value is not a valid unsigned integer")
+		}
+		number = float64(integer)
+	default:
+		float, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, false, errors.New("value is not a valid number")
+		}
+		number = float
+	}
+
+	if err := checkMeasurementValue(number); err != nil {
+		return 0, false, err
+	}
+
+	return number, false, nil
+}
+
+// splits on separators which are neither escaped with backslash nor (when quotes is set) inside double quotes
+func splitLineProtocol(s string, sep byte, quotes bool) []string {
+	parts := []string{}
+	start := 0
+	quoted := false
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quotes && s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// splits key=value pair on the first unescaped equals sign
+func cutLineProtocol(s string) (string, string, bool) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '=':
+			return s[:i], s[i+1:], true
+		}
+	}
+
+	return s, "", false
+}
+
+func unescapeLineProtocol(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`,= \`, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
+
+func escapeLineProtocol(s string, special string) string {
+	if !strings.ContainsAny(s, special) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(special, s[i]) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
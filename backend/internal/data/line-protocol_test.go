@@ -0,0 +1,113 @@
+package data_test
+
+import (
+	"errors"
+	"inzynierka/internal/data"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readAllPoints(reader *data.LineProtocolReader) ([]*data.LineProtocolPoint, error) {
+	points := []*data.LineProtocolPoint{}
+
+	for {
+		point, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return points, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		points = append(points, point)
+	}
+}
+
+func TestLineProtocolReader(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+
+	input := strings.Join([]string{
+		"# comment",
+		"weather,location=garden,floor=0 temperature=21.5,humidity=40i 1715342400000",
+		"",
+		`door,room=living\ room open=t,note="a, b c"`,
+		`my\,meter power=12u,label="x=1"`,
+	}, "\n")
+
+	reader := data.NewLineProtocolReader(strings.NewReader(input), time.Millisecond, now)
+	points, err := readAllPoints(reader)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	expected := []struct {
+		key    string
+		value  float64
+		isBool bool
+		time   time.Time
+	}{
+		{"weather,floor=0,location=garden temperature", 21.5, false, time.UnixMilli(1715342400000)},
+		{"weather,floor=0,location=garden humidity", 40, false, time.UnixMilli(1715342400000)},
+		{`door,room=living\ room open`, 1, true, now},
+		{`my\,meter power`, 12, false, now},
+	}
+
+	if len(points) != len(expected) {
+		t.Fatalf("got %d points, wanted %d", len(points), len(expected))
+	}
+
+	for i, test := range expected {
+		point := points[i]
+		if point.SeriesKey() != test.key || point.Value != test.value || point.IsBool != test.isBool || !point.Time.Equal(test.time) {
+			t.Errorf("Expected: %v; Got: %s %v %v %v", test, point.SeriesKey(), point.Value, point.IsBool, point.Time)
+		}
+	}
+
+	if reader.Skipped != 2 {
+		t.Errorf("Expected 2 skipped string fields; Got: %d", reader.Skipped)
+	}
+}
+
+func TestLineProtocolReaderInvalidLines(t *testing.T) {
+	inputs := []string{
+		"weather",
+		"weather temperature",
+		"weather,location temperature=1",
+		"weather temperature=abc",
+		"weather temperature=1 yesterday",
+		`weather note="unterminated`,
+		"weather temperature=1e300",
+		",location=garden temperature=1",
+	}
+
+	for _, input := range inputs {
+		_, err := readAllPoints(data.NewLineProtocolReader(strings.NewReader(input), time.Nanosecond, time.Now()))
+		if !errors.Is(err, data.ErrInvalidLineProtocol) {
+			t.Errorf("%q: expected invalid line protocol error, got %v", input, err)
+		}
+	}
+}
+
+func TestParseSeriesKey(t *testing.T) {
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"weather,location=garden temperature", true},
+		{`door,room=living\ room open`, true},
+		{"weather temperature", true},
+		{"weather,location=garden,floor=0 temperature", false},
+		{"weather", false},
+		{"weather temperature humidity", false},
+		{"192.168.1.10:8080", false},
+	}
+
+	for _, test := range tests {
+		_, err := data.ParseSeriesKey(test.key)
+		if (err == nil) != test.valid {
+			t.Errorf("%q: Expected valid: %v; Got error: %v", test.key, test.valid, err)
+		}
+	}
+}
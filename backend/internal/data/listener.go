@@ -1,111 +1,351 @@
 package data
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"inzynierka/internal/broker"
-	"io"
-	"net/http"
+	"math/rand"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// how long single poll of the sensor may take
+	pollTimeout = 5 * time.Second
+	// upper bound of delay between failed polls and between restarts of polling
+	maxPollBackoff = 5 * time.Minute
 )
 
-func NewListener[T any](sensor *Sensor, onNewValue func(T)) *Listener[T] {
+// SensorListeners is registry of running sensor listeners, shared by request handlers, rules, virtual sensors and metrics
+type SensorListeners struct {
+	mu        sync.RWMutex
+	listeners map[uuid.UUID]*Listener[SensorValue]
+}
+
+func NewSensorListeners() *SensorListeners {
+	return &SensorListeners{listeners: make(map[uuid.UUID]*Listener[SensorValue])}
+}
+
+func (l *SensorListeners) Get(sensorId uuid.UUID) (*Listener[SensorValue], bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	listener, ok := l.listeners[sensorId]
+	return listener, ok
+}
+
+// Set registers listener of the sensor and returns listener it replaced, if any
+func (l *SensorListeners) Set(sensorId uuid.UUID, listener *Listener[SensorValue]) (*Listener[SensorValue], bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	replaced, ok := l.listeners[sensorId]
+	l.listeners[sensorId] = listener
+	return replaced, ok
+}
+
+// Remove unregisters listener of the sensor and returns it, the listener is not stopped
+func (l *SensorListeners) Remove(sensorId uuid.UUID) (*Listener[SensorValue], bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	listener, ok := l.listeners[sensorId]
+	delete(l.listeners, sensorId)
+	return listener, ok
+}
+
+// All returns snapshot of registered listeners, which can be used while listeners are added or removed
+func (l *SensorListeners) All() []*Listener[SensorValue] {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	listeners := make([]*Listener[SensorValue], 0, len(l.listeners))
+	for _, listener := range l.listeners {
+		listeners = append(listeners, listener)
+	}
+	return listeners
+}
+
+func NewListener[T SensorReturn](sensor *Sensor, transport Transport, health *HealthTracker, onNewValue func(value T, raw SensorValue, at time.Time) error) *Listener[T] {
+	ctx, cancel := context.WithCancel(context.Background())
+
 	return &Listener[T]{
 		sensor:     sensor,
+		transport:  transport,
+		health:     health,
+		transforms: NewTransformPipeline(sensor.Transforms),
 		values:     make([]T, 0),
-		StopCh:     make(chan struct{}, 2),
+		ctx:        ctx,
+		cancel:     cancel,
 		Broker:     broker.NewBroker[[]T](),
 		onNewValue: onNewValue,
 	}
 }
 
-// TODO: Make it work with SensorResult
-type Response[T any] struct {
-	Values []T    `json:"values"`
-	Status string `json:"status"`
-}
-
-type Listener[T any] struct {
-	sensor     *Sensor
-	values     []T
-	StopCh     chan struct{}
-	Broker     *broker.Broker[[]T]
-	onNewValue func(T)
+type Listener[T SensorReturn] struct {
+	sensor *Sensor
+	// nil if transport of the sensor is not available
+	transport  Transport
+	transforms *TransformPipeline
+	// records polls and pushed values of the sensor
+	health *HealthTracker
+	mu     sync.Mutex
+	values []T
+	// cancelled by Stop
+	ctx    context.Context
+	cancel context.CancelFunc
+	Broker *broker.Broker[[]T]
+	// gets transformed value along with the raw one
+	onNewValue func(value T, raw SensorValue, at time.Time) error
+	// poll results since the listener was created, kept for metrics
+	polls        atomic.Int64
+	pollFailures atomic.Int64
+	restarts     atomic.Int64
 }
 
 var (
 	ErrSensorHttpErrorResponse = errors.New("sensor value request returned HTTP Error code")
 )
 
+// Start subscribes active sensor to values pushed over its transport (before returning, so no value is lost),
+// passive sensor is polled in the background. Listener runs until Stop is called
 func (l *Listener[T]) Start() error {
-	var input struct {
-		Value T `json:"value"`
+	go l.Broker.Start()
+
+	stopBroker := func() {
+		<-l.ctx.Done()
+		l.Broker.Stop()
 	}
 
-	sensorEndpoint := fmt.Sprintf("http://%v/value", l.sensor.URI)
+	if l.transport == nil {
+		go stopBroker()
+		return fmt.Errorf("%w: %s", ErrTransportUnavailable, l.sensor.Transport)
+	}
 
-	go l.Broker.Start()
-	defer l.Broker.Stop()
+	if !l.sensor.Active {
+		go func() {
+			defer l.Broker.Stop()
+			l.supervise(l.ctx)
+		}()
+		return nil
+	}
+
+	unsubscribe, err := l.transport.Subscribe(l.sensor, l.push)
+	if err != nil {
+		go stopBroker()
+		return err
+	}
+
+	// values are not pushed any more once the broker is stopped
+	go func() {
+		<-l.ctx.Done()
+		unsubscribe()
+		l.Broker.Stop()
+	}()
+
+	return nil
+}
+
+// Stop stops polling (interrupting poll in progress) or unsubscribes the sensor, it is safe to call it more than once
+func (l *Listener[T]) Stop() {
+	l.cancel()
+}
+
+// BackoffDelay returns delay before next attempt after given number of failures in a row,
+// doubling base delay with every failure up to max. Delay is randomized between its half and its full value,
+// so sensors failing together do not retry at the same time
+func BackoffDelay(base, max time.Duration, failures int) time.Duration {
+	delay := base
+	for i := 0; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	delay = min(delay, max)
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// waits for given time, returns false if ctx was cancelled first
+func sleepContext(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// supervise polls the sensor until ctx is cancelled, polling which panics is restarted after backoff.
+// Only transport unable to read values stops it for good
+func (l *Listener[T]) supervise(ctx context.Context) {
+	restarts := 0
 
-	delayMultiplier := 1
 	for {
-		select {
-		case <-l.StopCh:
-			return nil
-		default:
+		err := l.runPoll(ctx)
+		if ctx.Err() != nil {
+			return
 		}
-		delay := delayMultiplier * l.sensor.RefreshRate
-		time.Sleep(time.Duration(delay) * time.Second)
 
-		res, err := http.Get(sensorEndpoint)
-		if err != nil {
-			logger.Warn("Error while getting sensor value", "error", err.Error())
+		if errors.Is(err, ErrTransportUnsupported) {
+			logger.Error("Sensor polling stopped", "sensor", l.sensor.ID, "error", err)
+			return
+		}
 
-			l.Broker.Publish(nil)
+		restarts++
+		l.restarts.Add(1)
+		logger.Warn("Restarting sensor polling", "sensor", l.sensor.ID, "error", err, "restarts", restarts)
 
-			delayMultiplier += 1
-			continue
+		if !sleepContext(ctx, BackoffDelay(time.Second, maxPollBackoff, restarts)) {
+			return
 		}
+	}
+}
 
-		if res.StatusCode >= 400 {
-			logger.Warn("Sensor value request returned HTTP Error code", "status", res.Status)
-			return ErrSensorHttpErrorResponse
-			// TODO: send notification
+// runs poll, turning panic into error
+func (l *Listener[T]) runPoll(ctx context.Context) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("polling panicked: %v", r)
 		}
+	}()
 
-		body, err := io.ReadAll(res.Body)
-		if err != nil {
-			return err
+	return l.poll(ctx)
+}
+
+// polls the sensor every refresh rate, failed polls are retried with growing delay
+func (l *Listener[T]) poll(ctx context.Context) error {
+	interval := time.Duration(l.sensor.RefreshRate) * time.Second
+	failures := 0
+
+	for {
+		delay := interval
+		if failures > 0 {
+			delay = BackoffDelay(interval, max(interval, maxPollBackoff), failures)
+		}
+
+		if !sleepContext(ctx, delay) {
+			return nil
 		}
 
-		err = json.Unmarshal(body, &input)
+		readCtx, cancel := context.WithTimeout(ctx, pollTimeout)
+		start := time.Now()
+		value, err := l.transport.Read(readCtx, l.sensor)
+		cancel()
+
 		if err != nil {
-			return err
+			if ctx.Err() != nil {
+				return nil
+			}
+			if errors.Is(err, ErrTransportUnsupported) {
+				return err
+			}
+
+			// sensor answering with an error or garbage may recover just like unreachable one
+			failures++
+			l.pollFailures.Add(1)
+			l.health.Failure(l.sensor.ID, err)
+			logger.Warn("Error while getting sensor value", "sensor", l.sensor.ID, "failures", failures, "error", err)
+
+			l.Broker.Publish(nil)
+			continue
 		}
 
-		// TODO: wyrzucic do konfigu
-		l.values = append(l.values, input.Value)
-		if len(l.values) > 5 {
-			l.values = l.values[1:]
+		failures = 0
+		l.polls.Add(1)
+		l.health.Success(l.sensor.ID, time.Since(start))
+
+		if err = l.receive(value, time.Now()); err != nil {
+			logger.Error("Receiving sensor value", "sensor", l.sensor.ID, "error", err)
 		}
+	}
+}
 
-		l.onNewValue(input.Value)
+// receives value pushed by active sensor
+func (l *Listener[T]) push(raw SensorValue, at time.Time) error {
+	l.health.Seen(l.sensor.ID)
+	return l.receive(raw, at)
+}
 
-		l.Broker.Publish(l.values)
+// transforms value received over transport and coerces it to the kind of the sensor,
+// publishes it to the subscribers and passes it to onNewValue. Values not fitting the sensor are rejected
+func (l *Listener[T]) receive(raw SensorValue, at time.Time) error {
+	value := raw
+	if !raw.IsText() {
+		value.Number = l.transforms.Apply(raw.Number)
+	}
 
-		delayMultiplier = 1
+	value, err := l.sensor.Coerce(value)
+	if err != nil {
+		return err
 	}
+
+	converted := valueAs[T](value)
+
+	l.Broker.Publish(l.appendValue(converted))
+
+	return l.onNewValue(converted, raw, at)
+}
+
+// converts value received over transport to value type of the listener
+func valueAs[T SensorReturn](value SensorValue) T {
+	var result T
+
+	switch p := any(&result).(type) {
+	case *SensorValue:
+		*p = value
+	case *float64:
+		*p = value.Number
+	case *int:
+		*p = int(value.Number)
+	case *bool:
+		*p = value.Number != 0
+	}
+
+	return result
+}
+
+// adds value to the recent values window and returns copy of the window
+func (l *Listener[T]) appendValue(value T) []T {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// TODO: wyrzucic do konfigu
+	l.values = append(l.values, value)
+	if len(l.values) > 5 {
+		l.values = l.values[1:]
+	}
+
+	return append([]T(nil), l.values...)
 }
 
 func (l *Listener[T]) GetBroker() *broker.Broker[[]T] {
 	return l.Broker
 }
 
-func (l *Listener[T]) GetStopCh() chan struct{} {
-	return l.StopCh
+func (l *Listener[T]) GetSensor() *Sensor {
+	return l.sensor
+}
+
+// returns number of successful and failed polls
+func (l *Listener[T]) GetPollCounts() (int64, int64) {
+	return l.polls.Load(), l.pollFailures.Load()
+}
+
+// returns how many times polling was restarted after it panicked
+func (l *Listener[T]) GetRestarts() int64 {
+	return l.restarts.Load()
 }
 
 func (l *Listener[T]) GetCurrentValue() []T {
-	return l.values
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]T(nil), l.values...)
 }
@@ -0,0 +1,214 @@
+package data_test
+
+import (
+	"inzynierka/internal/data"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		min      time.Duration
+		max      time.Duration
+	}{
+		{0, 5 * time.Second, 10 * time.Second},
+		{1, 10 * time.Second, 20 * time.Second},
+		{2, 20 * time.Second, 40 * time.Second},
+		// capped
+		{10, 30 * time.Second, time.Minute},
+		{1000, 30 * time.Second, time.Minute},
+	}
+
+	for _, test := range tests {
+		for range 20 {
+			delay := data.BackoffDelay(10*time.Second, time.Minute, test.failures)
+			if delay < test.min || delay > test.max {
+				t.Errorf("%d failures: expected delay between %v and %v; Got: %v", test.failures, test.min, test.max, delay)
+			}
+		}
+	}
+}
+
+func TestListenerRecoversFromErrorResponses(t *testing.T) {
+	var requests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// sensor answers with an error until it finishes booting
+		if requests.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"value": 21.5}`))
+	}))
+	defer server.Close()
+
+	sensor := &data.Sensor{ID: uuid.New(), URI: strings.TrimPrefix(server.URL, "http://"), RefreshRate: 1, Transport: data.TransportHTTP}
+	health := data.NewHealthTracker(data.HealthConfig{}, nil)
+
+	received := make(chan float64, 1)
+	listener := data.NewListener(sensor, data.NewHTTPTransport(http.DefaultClient), health, func(value float64, raw data.SensorValue, at time.Time) error {
+		select {
+		case received <- value:
+		default:
+		}
+		return nil
+	})
+
+	if err := listener.Start(); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer listener.Stop()
+
+	select {
+	case value := <-received:
+		if value != 21.5 {
+			t.Errorf("Expected: 21.5; Got: %v", value)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("listener did not recover from error response")
+	}
+
+	if polls, failures := listener.GetPollCounts(); polls < 1 || failures != 1 {
+		t.Errorf("Expected 1 failed poll followed by successful ones; Got: %d successful, %d failed", polls, failures)
+	}
+
+	if status := health.Get(sensor.ID).Status; status != data.HealthOnline {
+		t.Errorf("Expected: %s; Got: %s", data.HealthOnline, status)
+	}
+}
+
+func TestListenerCoercesValues(t *testing.T) {
+	responses := []string{`{"value": "auto"}`, `{"value": "cool"}`}
+	var requests atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := min(int(requests.Add(1))-1, len(responses)-1)
+		w.Write([]byte(responses[i]))
+	}))
+	defer server.Close()
+
+	sensor := &data.Sensor{ID: uuid.New(), URI: strings.TrimPrefix(server.URL, "http://"), RefreshRate: 1, Transport: data.TransportHTTP, Type: data.EnumSensor, Options: []string{"heat", "cool", "off"}}
+
+	received := make(chan data.SensorValue, 1)
+	listener := data.NewListener(sensor, data.NewHTTPTransport(http.DefaultClient), nil, func(value, raw data.SensorValue, at time.Time) error {
+		select {
+		case received <- value:
+		default:
+		}
+		return nil
+	})
+
+	if err := listener.Start(); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer listener.Stop()
+
+	// "auto" is not one of options, so it never reaches onNewValue
+	select {
+	case value := <-received:
+		if value.Kind != data.KindEnum || value.Text != "cool" || value.Number != 1 {
+			t.Errorf("Expected option cool (1); Got: %+v", value)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("listener did not receive value")
+	}
+}
+
+func TestSensorListenersConcurrentAccess(t *testing.T) {
+	listeners := data.NewSensorListeners()
+	sensor := &data.Sensor{ID: uuid.New()}
+
+	var wg sync.WaitGroup
+	// writes from concurrent ingest requests and reads of metrics scrapes
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				id := uuid.New()
+				listeners.Set(id, data.NewListener[data.SensorValue](&data.Sensor{ID: id}, nil, nil, nil))
+				listeners.Remove(id)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				for _, listener := range listeners.All() {
+					_ = listener.GetSensor()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	first := data.NewListener[data.SensorValue](sensor, nil, nil, nil)
+	second := data.NewListener[data.SensorValue](sensor, nil, nil, nil)
+
+	if _, ok := listeners.Set(sensor.ID, first); ok {
+		t.Error("expected no replaced listener")
+	}
+	if replaced, ok := listeners.Set(sensor.ID, second); !ok || replaced != first {
+		t.Errorf("Expected replaced: %p; Got: %p", first, replaced)
+	}
+
+	if listener, ok := listeners.Remove(sensor.ID); !ok || listener != second {
+		t.Errorf("Expected removed: %p; Got: %p", second, listener)
+	}
+	if len(listeners.All()) != 0 {
+		t.Errorf("Expected no listeners; Got: %d", len(listeners.All()))
+	}
+}
+
+func TestListenerStopWhilePushing(t *testing.T) {
+	sensor := &data.Sensor{ID: uuid.New(), Active: true, Transport: data.TransportLineProtocol, Type: data.DecimalSensor}
+	transport := data.NewLineProtocolTransport()
+
+	listener := data.NewListener(sensor, transport, nil, func(value, raw data.SensorValue, at time.Time) error {
+		return nil
+	})
+	if err := listener.Start(); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// values delivered around Stop must neither block nor reach stopped broker
+		for i := 0; i < 100; i++ {
+			if i == 10 {
+				listener.Stop()
+			}
+			_ = transport.Deliver(sensor.ID, data.DecimalValue(float64(i)), time.Now())
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("delivery blocked after listener was stopped")
+	}
+
+	// delivery which was already in flight when the broker stopped
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := 0; i < 3; i++ {
+			listener.Broker.Publish([]data.SensorValue{data.DecimalValue(1)})
+		}
+		listener.Broker.Unsubscribe(listener.Broker.Subscribe())
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishing to stopped broker blocked")
+	}
+}
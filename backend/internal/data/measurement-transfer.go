@@ -0,0 +1,401 @@
+package data
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrInvalidMeasurementRow = errors.New("invalid measurement row")
+	ErrDuplicateMeasurement  = errors.New("measurement already exists")
+	ErrUnknownSensor         = errors.New("measurement references non existing sensor")
+)
+
+type MeasurementFormat string
+
+const (
+	MeasurementCSV    MeasurementFormat = "csv"
+	MeasurementNDJSON MeasurementFormat = "ndjson"
+)
+
+var MeasurementFormats = []MeasurementFormat{MeasurementCSV, MeasurementNDJSON}
+
+// text_value is optional on import, so files without it are still accepted.
+// Empty text_value means there is no text, empty texts are only kept by ndjson
+var measurementCSVHeader = []string{"sensor_id", "measured_at", "measured_value", "text_value"}
+
+// what happens with imported measurements already stored for the same sensor and time
+type ImportConflictPolicy string
+
+const (
+	// keep stored measurement
+	ImportSkip ImportConflictPolicy = "skip"
+	// replace stored measurement with imported one
+	ImportOverwrite ImportConflictPolicy = "overwrite"
+	// reject the whole import
+	ImportFail ImportConflictPolicy = "fail"
+)
+
+var ImportConflictPolicies = []ImportConflictPolicy{ImportSkip, ImportOverwrite, ImportFail}
+
+// MeasurementReader returns measurements one by one, io.EOF signals the end of input
+type MeasurementReader interface {
+	Read() (*SensorMeasurement, error)
+}
+
+type MeasurementWriter interface {
+	Write(measurement *SensorMeasurement) error
+	Flush() error
+}
+
+func NewMeasurementReader(format MeasurementFormat, r io.Reader) MeasurementReader {
+	if format == MeasurementNDJSON {
+		return &ndjsonMeasurementReader{scanner: bufio.NewScanner(r)}
+	}
+
+	reader := csv.NewReader(r)
+	// the first record sets number of fields of the file, it is checked by parseMeasurementRecord
+	reader.FieldsPerRecord = 0
+	reader.ReuseRecord = true
+
+	return &csvMeasurementReader{reader: reader}
+}
+
+func NewMeasurementWriter(format MeasurementFormat, w io.Writer) MeasurementWriter {
+	if format == MeasurementNDJSON {
+		buf := bufio.NewWriter(w)
+		return &ndjsonMeasurementWriter{buf: buf, encoder: json.NewEncoder(buf)}
+	}
+
+	return &csvMeasurementWriter{writer: csv.NewWriter(w)}
+}
+
+func checkMeasurementValue(value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) || math.Abs(value) > math.MaxFloat32 {
+		return errors.New("value must be a finite 32 bit floating point number")
+	}
+	return nil
+}
+
+func checkMeasurementText(text *string) error {
+	if text != nil && (len(*text) > maxTextValueLength || !utf8.ValidString(*text)) {
+		return fmt.Errorf("text_value must be valid utf-8 of at most %d bytes", maxTextValueLength)
+	}
+	return nil
+}
+
+type csvMeasurementReader struct {
+	reader *csv.Reader
+	line   int
+}
+
+func (r *csvMeasurementReader) Read() (*SensorMeasurement, error) {
+	for {
+		record, err := r.reader.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %v", ErrInvalidMeasurementRow, err)
+		}
+		r.line++
+
+		// header is optional
+		if r.line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), measurementCSVHeader[0]) {
+			continue
+		}
+
+		measurement, err := parseMeasurementRecord(record)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidMeasurementRow, r.line, err)
+		}
+
+		return measurement, nil
+	}
+}
+
+func parseMeasurementRecord(record []string) (*SensorMeasurement, error) {
+	if len(record) < len(measurementCSVHeader)-1 || len(record) > len(measurementCSVHeader) {
+		return nil, fmt.Errorf("must have %d or %d fields", len(measurementCSVHeader)-1, len(measurementCSVHeader))
+	}
+
+	sensorId, err := uuid.Parse(strings.TrimSpace(record[0]))
+	if err != nil {
+		return nil, errors.New("sensor_id must be valid uuid")
+	}
+
+	measuredAt, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(record[1]))
+	if err != nil {
+		return nil, errors.New("measured_at must be valid RFC3339 timestamp")
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(record[2]), 64)
+	if err != nil {
+		return nil, errors.New("measured_value must be a number")
+	}
+
+	if err = checkMeasurementValue(value); err != nil {
+		return nil, err
+	}
+
+	measurement := &SensorMeasurement{SensorID: sensorId, MeasuredAt: measuredAt, MeasuredValue: value}
+
+	if len(record) == len(measurementCSVHeader) && record[3] != "" {
+		text := record[3]
+		measurement.TextValue = &text
+	}
+
+	if err = checkMeasurementText(measurement.TextValue); err != nil {
+		return nil, err
+	}
+
+	return measurement, nil
+}
+
+type ndjsonMeasurementReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (r *ndjsonMeasurementReader) Read() (*SensorMeasurement, error) {
+	for r.scanner.Scan() {
+		r.line++
+
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var measurement SensorMeasurement
+		if err := json.Unmarshal([]byte(line), &measurement); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidMeasurementRow, r.line, err)
+		}
+
+		if measurement.SensorID == uuid.Nil || measurement.MeasuredAt.IsZero() {
+			return nil, fmt.Errorf("%w: line %d: sensor_id and measured_at must be provided", ErrInvalidMeasurementRow, r.line)
+		}
+
+		if err := checkMeasurementValue(measurement.MeasuredValue); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidMeasurementRow, r.line, err)
+		}
+
+		if err := checkMeasurementText(measurement.TextValue); err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidMeasurementRow, r.line, err)
+		}
+
+		return &measurement, nil
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMeasurementRow, err)
+	}
+
+	return nil, io.EOF
+}
+
+type csvMeasurementWriter struct {
+	writer        *csv.Writer
+	headerWritten bool
+}
+
+func (w *csvMeasurementWriter) Write(measurement *SensorMeasurement) error {
+	if !w.headerWritten {
+		if err := w.writer.Write(measurementCSVHeader); err != nil {
+			return err
+		}
+		w.headerWritten = true
+	}
+
+	text := ""
+	if measurement.TextValue != nil {
+		text = *measurement.TextValue
+	}
+
+	return w.writer.Write([]string{
+		measurement.SensorID.String(),
+		measurement.MeasuredAt.Format(time.RFC3339Nano),
+		strconv.FormatFloat(measurement.MeasuredValue, 'f', -1, 32),
+		text,
+	})
+}
+
+func (w *csvMeasurementWriter) Flush() error {
+	// empty export still gets its header
+	if !w.headerWritten {
+		if err := w.writer.Write(measurementCSVHeader); err != nil {
+			return err
+		}
+		w.headerWritten = true
+	}
+
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+type ndjsonMeasurementWriter struct {
+	buf     *bufio.Writer
+	encoder *json.Encoder
+}
+
+func (w *ndjsonMeasurementWriter) Write(measurement *SensorMeasurement) error {
+	return w.encoder.Encode(measurement)
+}
+
+func (w *ndjsonMeasurementWriter) Flush() error {
+	return w.buf.Flush()
+}
+
+// Export streams measurements of given sensors (all of them if empty) taken in [from, to)
+// to fn, ordered by sensor and time. Stops at the first error returned by fn.
+// Measurements older than raw retention are exported as averages of their 1 minute or 1 hour aggregates
+func (m *SensorMeasurementModel) Export(ctx context.Context, sensorIds []uuid.UUID, from, to time.Time, fn func(*SensorMeasurement) error) error {
+	query := `
+    SELECT sensor_id, measured_at, avg_value, text_value
+    FROM sensor_measurements_history
+    WHERE (cardinality($1::uuid[]) = 0 OR sensor_id = ANY($1))
+    AND measured_at >= $2 AND measured_at < $3
+    ORDER BY sensor_id, measured_at
+    `
+
+	if sensorIds == nil {
+		sensorIds = []uuid.UUID{}
+	}
+
+	rows, err := m.DB.Query(ctx, query, sensorIds, from, to)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var measurement SensorMeasurement
+
+		err := rows.Scan(&measurement.SensorID, &measurement.MeasuredAt, &measurement.MeasuredValue, &measurement.TextValue)
+		if err != nil {
+			return err
+		}
+
+		if err = fn(&measurement); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+type ImportResult struct {
+	// rows read from the input
+	Read int64 `json:"read"`
+	// rows stored in the database
+	Imported int64 `json:"imported"`
+}
+
+// copies measurements from reader into pgx.CopyFrom
+type measurementCopySource struct {
+	reader  MeasurementReader
+	current *SensorMeasurement
+	err     error
+	read    int64
+}
+
+func (s *measurementCopySource) Next() bool {
+	s.current, s.err = s.reader.Read()
+	if s.err != nil {
+		if errors.Is(s.err, io.EOF) {
+			s.err = nil
+		}
+		return false
+	}
+
+	s.read++
+	return true
+}
+
+func (s *measurementCopySource) Values() ([]any, error) {
+	return []any{s.current.SensorID, s.current.MeasuredAt, s.current.MeasuredValue, s.current.TextValue}, nil
+}
+
+func (s *measurementCopySource) Err() error {
+	return s.err
+}
+
+// Import bulk loads measurements in a single transaction. Rows are copied into a temporary table first,
+// then moved into sensor_measurements handling rows already stored according to policy.
+// Duplicates within the input itself are reduced to the last occurrence
+func (m *SensorMeasurementModel) Import(ctx context.Context, reader MeasurementReader, policy ImportConflictPolicy) (*ImportResult, error) {
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+    CREATE TEMPORARY TABLE measurements_import (
+        n bigserial,
+        sensor_id uuid NOT NULL,
+        measured_at timestamptz(1) NOT NULL,
+        measured_value real NOT NULL,
+        text_value text
+    ) ON COMMIT DROP
+    `)
+	if err != nil {
+		return nil, err
+	}
+
+	source := &measurementCopySource{reader: reader}
+
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"measurements_import"}, []string{"sensor_id", "measured_at", "measured_value", "text_value"}, source)
+	if err != nil {
+		if source.err != nil {
+			return nil, source.err
+		}
+		return nil, err
+	}
+
+	conflict := ""
+	switch policy {
+	case ImportSkip:
+		conflict = "ON CONFLICT (sensor_id, measured_at) DO NOTHING"
+	case ImportOverwrite:
+		conflict = "ON CONFLICT (sensor_id, measured_at) DO UPDATE SET measured_value = excluded.measured_value, text_value = excluded.text_value"
+	}
+
+	query := fmt.Sprintf(`
+    INSERT INTO sensor_measurements (sensor_id, measured_at, measured_value, text_value)
+    SELECT DISTINCT ON (sensor_id, measured_at) sensor_id, measured_at, measured_value, text_value
+    FROM measurements_import
+    ORDER BY sensor_id, measured_at, n DESC
+    %s
+    `, conflict)
+
+	result, err := tx.Exec(ctx, query)
+	if err != nil {
+		switch {
+		case strings.HasPrefix(err.Error(), "ERROR: duplicate key value violates unique constraint"):
+			return nil, ErrDuplicateMeasurement
+		case strings.HasPrefix(err.Error(), "ERROR: insert or update on table \"sensor_measurements\" violates foreign key constraint"):
+			return nil, ErrUnknownSensor
+		default:
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return &ImportResult{Read: source.read, Imported: result.RowsAffected()}, nil
+}
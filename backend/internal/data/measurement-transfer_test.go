@@ -0,0 +1,115 @@
+package data_test
+
+import (
+	"bytes"
+	"errors"
+	"inzynierka/internal/data"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func readAllMeasurements(reader data.MeasurementReader) ([]*data.SensorMeasurement, error) {
+	measurements := []*data.SensorMeasurement{}
+
+	for {
+		measurement, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return measurements, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		measurements = append(measurements, measurement)
+	}
+}
+
+func TestMeasurementRoundtrip(t *testing.T) {
+	text := "door open, \"front\""
+	option := "cool"
+
+	measurements := []*data.SensorMeasurement{
+		{SensorID: uuid.New(), MeasuredAt: time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC), MeasuredValue: 21.5},
+		{SensorID: uuid.New(), MeasuredAt: time.Date(2024, 5, 10, 12, 0, 1, 500000000, time.UTC), MeasuredValue: -3},
+		// text sensor
+		{SensorID: uuid.New(), MeasuredAt: time.Date(2024, 5, 10, 12, 0, 2, 0, time.UTC), TextValue: &text},
+		// enum sensor keeps index of the option as well
+		{SensorID: uuid.New(), MeasuredAt: time.Date(2024, 5, 10, 12, 0, 3, 0, time.UTC), MeasuredValue: 1, TextValue: &option},
+	}
+
+	for _, format := range data.MeasurementFormats {
+		var buf bytes.Buffer
+
+		writer := data.NewMeasurementWriter(format, &buf)
+		for _, measurement := range measurements {
+			if err := writer.Write(measurement); err != nil {
+				t.Fatalf("%s: Error: %v", format, err)
+			}
+		}
+		if err := writer.Flush(); err != nil {
+			t.Fatalf("%s: Error: %v", format, err)
+		}
+
+		read, err := readAllMeasurements(data.NewMeasurementReader(format, &buf))
+		if err != nil {
+			t.Fatalf("%s: Error: %v", format, err)
+		}
+
+		if len(read) != len(measurements) {
+			t.Fatalf("%s: got %d measurements, wanted %d", format, len(read), len(measurements))
+		}
+
+		for i := range measurements {
+			if read[i].SensorID != measurements[i].SensorID || !read[i].MeasuredAt.Equal(measurements[i].MeasuredAt) || read[i].MeasuredValue != measurements[i].MeasuredValue {
+				t.Errorf("%s: Expected: %v; Got: %v", format, measurements[i], read[i])
+			}
+
+			if read[i].Value() != measurements[i].Value() {
+				t.Errorf("%s: Expected value: %v; Got: %v", format, measurements[i].Value(), read[i].Value())
+			}
+		}
+	}
+}
+
+func TestMeasurementReaderWithoutText(t *testing.T) {
+	id := uuid.New()
+	input := "sensor_id,measured_at,measured_value\n" + id.String() + ",2024-05-10T12:00:00Z,21.5\n"
+
+	read, err := readAllMeasurements(data.NewMeasurementReader(data.MeasurementCSV, strings.NewReader(input)))
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if len(read) != 1 || read[0].SensorID != id || read[0].MeasuredValue != 21.5 || read[0].TextValue != nil {
+		t.Errorf("Expected decimal measurement of %s; Got: %+v", id, read)
+	}
+}
+
+func TestMeasurementReaderInvalidRows(t *testing.T) {
+	id := uuid.New().String()
+
+	inputs := []struct {
+		format data.MeasurementFormat
+		input  string
+	}{
+		{data.MeasurementCSV, "not-uuid,2024-05-10T12:00:00Z,1"},
+		{data.MeasurementCSV, id + ",yesterday,1"},
+		{data.MeasurementCSV, id + ",2024-05-10T12:00:00Z,NaN"},
+		{data.MeasurementCSV, id + ",2024-05-10T12:00:00Z"},
+		{data.MeasurementCSV, id + ",2024-05-10T12:00:00Z,0,text,extra"},
+		{data.MeasurementCSV, id + ",2024-05-10T12:00:00Z,0," + strings.Repeat("a", 256)},
+		{data.MeasurementNDJSON, `{"sensor_id": "` + id + `", "measured_value": 1}`},
+		{data.MeasurementNDJSON, `{"sensor_id": "` + id + `", "measured_at": "2024-05-10T12:00:00Z", "measured_value": "1"}`},
+	}
+
+	for _, test := range inputs {
+		_, err := readAllMeasurements(data.NewMeasurementReader(test.format, strings.NewReader(test.input)))
+		if !errors.Is(err, data.ErrInvalidMeasurementRow) {
+			t.Errorf("%s %q: expected invalid row error, got %v", test.format, test.input, err)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrMeasurementQueueFull = errors.New("measurement queue is full")
+	ErrWriterClosed         = errors.New("measurement writer is closed")
+)
+
+// BatchInsertError is returned by flush when only some of the measurements could not be written
+type BatchInsertError struct {
+	Failed int
+	// error of the first failed measurement
+	Err error
+}
+
+func (e *BatchInsertError) Error() string {
+	return fmt.Sprintf("%d measurements not written: %v", e.Failed, e.Err)
+}
+
+func (e *BatchInsertError) Unwrap() error {
+	return e.Err
+}
+
+type MeasurementWriterConfig struct {
+	// measurements waiting to be written, writers block when it is full
+	QueueSize int
+	// measurements written by a single insert
+	BatchSize int
+	// incomplete batch is written after that time
+	FlushInterval time.Duration
+	// how long Write blocks on full queue before dropping the measurement
+	EnqueueTimeout time.Duration
+}
+
+type MeasurementWriterStats struct {
+	QueueDepth    int   `json:"queue_depth"`
+	QueueCapacity int   `json:"queue_capacity"`
+	Written       int64 `json:"written"`
+	Dropped       int64 `json:"dropped"`
+	Failed        int64 `json:"failed"`
+	Batches       int64 `json:"batches"`
+}
+
+// MeasurementBatchWriter buffers measurements and writes them in batches from a single goroutine,
+// so slow database does not block producers until the queue fills up
+type MeasurementBatchWriter struct {
+	config MeasurementWriterConfig
+	flush  func([]SensorMeasurement) error
+	queue  chan SensorMeasurement
+	done   chan struct{}
+	// handled by Run, so queued measurements are never touched by other goroutines
+	discards chan discardRequest
+
+	// guards closing the queue against concurrent writes
+	mu     sync.RWMutex
+	closed bool
+
+	written atomic.Int64
+	dropped atomic.Int64
+	failed  atomic.Int64
+	batches atomic.Int64
+}
+
+func NewMeasurementBatchWriter(flush func([]SensorMeasurement) error, config MeasurementWriterConfig) *MeasurementBatchWriter {
+	return &MeasurementBatchWriter{
+		config:   config,
+		flush:    flush,
+		queue:    make(chan SensorMeasurement, config.QueueSize),
+		done:     make(chan struct{}),
+		discards: make(chan discardRequest),
+	}
+}
+
+type discardRequest struct {
+	sensorId uuid.UUID
+	done     chan struct{}
+}
+
+// Write queues measurement, blocking for at most EnqueueTimeout if the queue is full
+func (w *MeasurementBatchWriter) Write(measurement SensorMeasurement) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return ErrWriterClosed
+	}
+
+	select {
+	case w.queue <- measurement:
+		return nil
+	default:
+	}
+
+	timer := time.NewTimer(w.config.EnqueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case w.queue <- measurement:
+		return nil
+	case <-timer.C:
+		w.dropped.Add(1)
+		return ErrMeasurementQueueFull
+	}
+}
+
+// Run writes queued measurements until the writer is closed and its queue drained
+func (w *MeasurementBatchWriter) Run() {
+	defer close(w.done)
+
+	batch := make([]SensorMeasurement, 0, w.config.BatchSize)
+	ticker := time.NewTicker(w.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case measurement, ok := <-w.queue:
+			if !ok {
+				w.write(batch)
+				return
+			}
+
+			batch = append(batch, measurement)
+			if len(batch) >= w.config.BatchSize {
+				w.write(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			w.write(batch)
+			batch = batch[:0]
+		case req := <-w.discards:
+			// everything queued so far joins the batch, so none of sensor's measurements are left behind
+			for range len(w.queue) {
+				measurement, ok := <-w.queue
+				if !ok {
+					break
+				}
+				batch = append(batch, measurement)
+			}
+
+			batch = slices.DeleteFunc(batch, func(measurement SensorMeasurement) bool {
+				return measurement.SensorID == req.sensorId
+			})
+			close(req.done)
+
+			if len(batch) >= w.config.BatchSize {
+				w.write(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// Discard drops measurements of the sensor which are waiting to be written, e.g. before the sensor is deleted.
+// Measurements written after it returns are not affected
+func (w *MeasurementBatchWriter) Discard(ctx context.Context, sensorId uuid.UUID) error {
+	req := discardRequest{sensorId: sensorId, done: make(chan struct{})}
+
+	select {
+	case w.discards <- req:
+	case <-w.done:
+		// nothing is queued after Run has returned
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-req.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *MeasurementBatchWriter) write(batch []SensorMeasurement) {
+	if len(batch) == 0 {
+		return
+	}
+
+	w.batches.Add(1)
+
+	if err := w.flush(batch); err != nil {
+		failed := len(batch)
+
+		var batchErr *BatchInsertError
+		if errors.As(err, &batchErr) {
+			failed = batchErr.Failed
+		}
+
+		logger.Error("Writing measurements to DB", "count", len(batch), "failed", failed, "error", err)
+		w.failed.Add(int64(failed))
+		w.written.Add(int64(len(batch) - failed))
+		return
+	}
+
+	w.written.Add(int64(len(batch)))
+}
+
+// Close stops accepting measurements and waits until queued ones are written or ctx is done
+func (w *MeasurementBatchWriter) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *MeasurementBatchWriter) Stats() MeasurementWriterStats {
+	return MeasurementWriterStats{
+		QueueDepth:    len(w.queue),
+		QueueCapacity: cap(w.queue),
+		Written:       w.written.Load(),
+		Dropped:       w.dropped.Load(),
+		Failed:        w.failed.Load(),
+		Batches:       w.batches.Load(),
+	}
+}
@@ -0,0 +1,197 @@
+package data_test
+
+import (
+	"context"
+	"errors"
+	"inzynierka/internal/data"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type batchRecorder struct {
+	mu      sync.Mutex
+	batches [][]data.SensorMeasurement
+	block   chan struct{}
+}
+
+func (r *batchRecorder) flush(batch []data.SensorMeasurement) error {
+	if r.block != nil {
+		<-r.block
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.batches = append(r.batches, append([]data.SensorMeasurement{}, batch...))
+	return nil
+}
+
+func (r *batchRecorder) sizes() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sizes := make([]int, len(r.batches))
+	for i, batch := range r.batches {
+		sizes[i] = len(batch)
+	}
+	return sizes
+}
+
+func TestMeasurementBatchWriter(t *testing.T) {
+	recorder := &batchRecorder{}
+	writer := data.NewMeasurementBatchWriter(recorder.flush, data.MeasurementWriterConfig{
+		QueueSize:      100,
+		BatchSize:      3,
+		FlushInterval:  time.Hour,
+		EnqueueTimeout: time.Second,
+	})
+	go writer.Run()
+
+	for i := 0; i < 7; i++ {
+		if err := writer.Write(data.SensorMeasurement{SensorID: uuid.New(), MeasuredValue: float64(i)}); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := writer.Close(ctx); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	// two full batches, the rest is flushed on close
+	sizes := recorder.sizes()
+	if len(sizes) != 3 || sizes[0] != 3 || sizes[1] != 3 || sizes[2] != 1 {
+		t.Errorf("Expected batches: [3 3 1]; Got: %v", sizes)
+	}
+
+	if stats := writer.Stats(); stats.Written != 7 || stats.Batches != 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	if err := writer.Write(data.SensorMeasurement{}); !errors.Is(err, data.ErrWriterClosed) {
+		t.Errorf("expected closed writer error, got %v", err)
+	}
+}
+
+func TestMeasurementBatchWriterInterval(t *testing.T) {
+	recorder := &batchRecorder{}
+	writer := data.NewMeasurementBatchWriter(recorder.flush, data.MeasurementWriterConfig{
+		QueueSize:      100,
+		BatchSize:      100,
+		FlushInterval:  10 * time.Millisecond,
+		EnqueueTimeout: time.Second,
+	})
+	go writer.Run()
+	defer writer.Close(context.Background())
+
+	_ = writer.Write(data.SensorMeasurement{SensorID: uuid.New()})
+
+	deadline := time.Now().Add(time.Second)
+	for len(recorder.sizes()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if sizes := recorder.sizes(); len(sizes) != 1 || sizes[0] != 1 {
+		t.Errorf("Expected batches: [1]; Got: %v", sizes)
+	}
+}
+
+func TestMeasurementBatchWriterBackpressure(t *testing.T) {
+	recorder := &batchRecorder{block: make(chan struct{})}
+	writer := data.NewMeasurementBatchWriter(recorder.flush, data.MeasurementWriterConfig{
+		QueueSize:      2,
+		BatchSize:      1,
+		FlushInterval:  time.Hour,
+		EnqueueTimeout: 10 * time.Millisecond,
+	})
+	go writer.Run()
+
+	var err error
+	// first one is taken by blocked flush, next two fill the queue
+	for i := 0; i < 4 && err == nil; i++ {
+		err = writer.Write(data.SensorMeasurement{SensorID: uuid.New()})
+	}
+
+	if !errors.Is(err, data.ErrMeasurementQueueFull) {
+		t.Errorf("expected full queue error, got %v", err)
+	}
+
+	if stats := writer.Stats(); stats.Dropped != 1 || stats.QueueDepth != stats.QueueCapacity {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	close(recorder.block)
+	writer.Close(context.Background())
+}
+
+func TestMeasurementBatchWriterDiscard(t *testing.T) {
+	recorder := &batchRecorder{}
+	writer := data.NewMeasurementBatchWriter(recorder.flush, data.MeasurementWriterConfig{
+		QueueSize:      100,
+		BatchSize:      100,
+		FlushInterval:  time.Hour,
+		EnqueueTimeout: time.Second,
+	})
+	go writer.Run()
+
+	deleted, kept := uuid.New(), uuid.New()
+	for i := 0; i < 6; i++ {
+		sensorId := deleted
+		if i%2 == 0 {
+			sensorId = kept
+		}
+		_ = writer.Write(data.SensorMeasurement{SensorID: sensorId, MeasuredValue: float64(i)})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := writer.Discard(ctx, deleted); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if err := writer.Close(ctx); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	for _, batch := range recorder.batches {
+		for _, measurement := range batch {
+			if measurement.SensorID == deleted {
+				t.Errorf("measurement %v of discarded sensor was written", measurement.MeasuredValue)
+			}
+		}
+	}
+
+	if stats := writer.Stats(); stats.Written != 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestMeasurementBatchWriterPartialFailure(t *testing.T) {
+	flush := func(batch []data.SensorMeasurement) error {
+		return &data.BatchInsertError{Failed: 1, Err: errors.New("foreign key violation")}
+	}
+
+	writer := data.NewMeasurementBatchWriter(flush, data.MeasurementWriterConfig{
+		QueueSize:      100,
+		BatchSize:      3,
+		FlushInterval:  time.Hour,
+		EnqueueTimeout: time.Second,
+	})
+	go writer.Run()
+
+	for i := 0; i < 3; i++ {
+		_ = writer.Write(data.SensorMeasurement{SensorID: uuid.New()})
+	}
+	writer.Close(context.Background())
+
+	// only the failing row is lost, not the whole batch
+	if stats := writer.Stats(); stats.Written != 2 || stats.Failed != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
@@ -25,6 +25,10 @@ type Models struct {
 	Tokens             TokenModel
 	SensorMeasurements SensorMeasurementModel
 	Sequences          SequenceModel
+	SequenceRuns       SequenceRunModel
+	Schedules          ScheduleModel
+	Scenes             SceneModel
+	Areas              AreaModel
 	Notifications      NotificationModel
 }
 
@@ -36,6 +40,10 @@ func NewModels(db *pgxpool.Pool) Models {
 		Tokens:             TokenModel{DB: db},
 		SensorMeasurements: SensorMeasurementModel{DB: db},
 		Sequences:          SequenceModel{DB: db},
+		SequenceRuns:       SequenceRunModel{DB: db},
+		Schedules:          ScheduleModel{DB: db},
+		Scenes:             SceneModel{DB: db},
+		Areas:              AreaModel{DB: db},
 		Notifications:      NotificationModel{DB: db},
 	}
 }
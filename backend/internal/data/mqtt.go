@@ -0,0 +1,317 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"inzynierka/internal/data/validator"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+var (
+	ErrMQTTNotConnected = errors.New("not connected to mqtt broker")
+	ErrMQTTTimeout      = errors.New("mqtt broker did not respond in time")
+	ErrMQTTFieldMissing = errors.New("mqtt payload does not contain sensor field")
+)
+
+const (
+	mqttTimeout = 5 * time.Second
+	// payloads of binary sensors used by Tasmota and Zigbee2MQTT
+	defaultMQTTPayloadOn  = "ON"
+	defaultMQTTPayloadOff = "OFF"
+)
+
+// MQTTOptions describe how values are written to mqtt sensor,
+// state topic (and field of json state) is stored in sensor uri as `topic` or `topic#field`
+type MQTTOptions struct {
+	// topic commands are published to, required for switches
+	CommandTopic string `json:"command_topic,omitempty"`
+	// when set, commands are sent as json object with value under this key (eg. `{"state": "ON"}`)
+	CommandField string `json:"command_field,omitempty"`
+	// payloads of binary sensors, "ON" and "OFF" by default
+	PayloadOn  string `json:"payload_on,omitempty"`
+	PayloadOff string `json:"payload_off,omitempty"`
+}
+
+func (o *MQTTOptions) payloads() (string, string) {
+	on, off := defaultMQTTPayloadOn, defaultMQTTPayloadOff
+	if o != nil && o.PayloadOn != "" {
+		on = o.PayloadOn
+	}
+	if o != nil && o.PayloadOff != "" {
+		off = o.PayloadOff
+	}
+	return on, off
+}
+
+// SplitMQTTUri returns state topic and json field (empty if whole payload is the value) of mqtt sensor uri
+func SplitMQTTUri(uri string) (string, string) {
+	topic, field, _ := strings.Cut(uri, "#")
+	return topic, field
+}
+
+func validMQTTTopic(topic string) bool {
+	return topic != "" && len(topic) <= 65535 && !strings.ContainsAny(topic, "+#\x00")
+}
+
+func validateMQTTSensor(v *validator.Validator, sensor *Sensor) {
+	topic, field := SplitMQTTUri(sensor.URI)
+	v.Check(validMQTTTopic(topic), "uri", "must be valid mqtt topic without wildcards, optionally followed by #field")
+	v.Check(!strings.Contains(sensor.URI, "#") || field != "", "uri", "must not have empty field after #")
+
+	// values are delivered by the broker, there is nothing to poll
+	v.Check(sensor.Active, "active", "must be true for mqtt sensors")
+
+	if sensor.Type.IsWritable() {
+		v.Check(sensor.MQTT != nil && sensor.MQTT.CommandTopic != "", "mqtt.command_topic", "must be provided for switches")
+	}
+
+	if sensor.MQTT != nil && sensor.MQTT.CommandTopic != "" {
+		v.Check(validMQTTTopic(sensor.MQTT.CommandTopic), "mqtt.command_topic", "must be valid mqtt topic without wildcards")
+	}
+}
+
+// ParseMQTTPayload reads value of the sensor from message published to its state topic.
+// Numbers, booleans and on/off payloads (as plain text or json) are accepted,
+// enum and text sensors take the payload as text
+func ParseMQTTPayload(sensor *Sensor, payload []byte) (SensorValue, error) {
+	_, field := SplitMQTTUri(sensor.URI)
+
+	raw := strings.TrimSpace(string(payload))
+
+	if field != "" {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(payload, &object); err != nil {
+			return SensorValue{}, fmt.Errorf("payload is not a json object: %w", err)
+		}
+
+		value, ok := object[field]
+		if !ok {
+			return SensorValue{}, ErrMQTTFieldMissing
+		}
+		raw = string(value)
+	}
+
+	// json strings, eg. `"ON"`
+	var str string
+	if err := json.Unmarshal([]byte(raw), &str); err == nil {
+		raw = str
+	}
+
+	if sensor.Type.ValueKind().IsText() {
+		return StringValue(raw), nil
+	}
+
+	on, off := sensor.MQTT.payloads()
+
+	switch {
+	case strings.EqualFold(raw, on), strings.EqualFold(raw, "true"):
+		return BooleanValue(true), nil
+	case strings.EqualFold(raw, off), strings.EqualFold(raw, "false"):
+		return BooleanValue(false), nil
+	}
+
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return SensorValue{}, fmt.Errorf("payload %q is not a number", raw)
+	}
+
+	if err = checkMeasurementValue(value); err != nil {
+		return SensorValue{}, err
+	}
+
+	return DecimalValue(value), nil
+}
+
+// MQTTCommandPayload encodes value written to the sensor as message for its command topic
+func MQTTCommandPayload(sensor *Sensor, value SensorValue) ([]byte, error) {
+	command := value.wire()
+
+	if sensor.Type == BinarySwitch {
+		on, off := sensor.MQTT.payloads()
+		command = off
+		if value.Number != 0 {
+			command = on
+		}
+	}
+
+	if sensor.MQTT != nil && sensor.MQTT.CommandField != "" {
+		return json.Marshal(map[string]any{sensor.MQTT.CommandField: command})
+	}
+
+	switch command := command.(type) {
+	case string:
+		return []byte(command), nil
+	default:
+		return []byte(strconv.FormatFloat(value.Number, 'f', -1, 64)), nil
+	}
+}
+
+type MQTTConfig struct {
+	// eg. tcp://localhost:1883
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+	QoS      byte
+}
+
+// MQTTClient keeps connection to the broker, restoring subscriptions after reconnecting.
+// Several sensors can share a state topic (eg. fields of single Zigbee2MQTT device)
+type MQTTClient struct {
+	client   mqtt.Client
+	qos      byte
+	mu       sync.Mutex
+	handlers map[string]map[int]func(payload []byte)
+	// key of the next handler
+	next int
+}
+
+func NewMQTTClient(cfg MQTTConfig) *MQTTClient {
+	c := &MQTTClient{
+		qos:      cfg.QoS,
+		handlers: make(map[string]map[int]func(payload []byte)),
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(cfg.Broker).
+		SetClientID(cfg.ClientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetCleanSession(true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOrderMatters(false).
+		SetOnConnectHandler(c.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			logger.Warn("MQTT connection lost", "error", err)
+		})
+
+	c.client = mqtt.NewClient(opts)
+
+	return c
+}
+
+// Connect waits up to timeout for the connection, unavailable broker is retried in the background until Close
+func (c *MQTTClient) Connect(timeout time.Duration) error {
+	token := c.client.Connect()
+	if !token.WaitTimeout(timeout) {
+		return ErrMQTTTimeout
+	}
+
+	return token.Error()
+}
+
+func (c *MQTTClient) Close() {
+	c.client.Disconnect(250)
+}
+
+func (c *MQTTClient) IsConnected() bool {
+	return c.client.IsConnectionOpen()
+}
+
+// subscriptions are lost with clean session, so they are made again on every (re)connect
+func (c *MQTTClient) onConnect(client mqtt.Client) {
+	c.mu.Lock()
+	topics := make(map[string]byte, len(c.handlers))
+	for topic := range c.handlers {
+		topics[topic] = c.qos
+	}
+	c.mu.Unlock()
+
+	logger.Info("MQTT connected", "subscriptions", len(topics))
+
+	if len(topics) == 0 {
+		return
+	}
+
+	token := client.SubscribeMultiple(topics, c.dispatch)
+	if !token.WaitTimeout(mqttTimeout) {
+		logger.Warn("MQTT resubscribe", "error", ErrMQTTTimeout)
+	} else if err := token.Error(); err != nil {
+		logger.Warn("MQTT resubscribe", "error", err)
+	}
+}
+
+func (c *MQTTClient) dispatch(_ mqtt.Client, message mqtt.Message) {
+	c.mu.Lock()
+	handlers := make([]func([]byte), 0, len(c.handlers[message.Topic()]))
+	for _, handler := range c.handlers[message.Topic()] {
+		handlers = append(handlers, handler)
+	}
+	c.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(message.Payload())
+	}
+}
+
+// Subscribe registers handler for messages published to topic until returned function is called.
+// If the client is not connected yet, subscription is made once it connects.
+// Handler is removed if the broker does not confirm the subscription
+func (c *MQTTClient) Subscribe(topic string, handler func(payload []byte)) (func(), error) {
+	c.mu.Lock()
+	_, subscribed := c.handlers[topic]
+	if !subscribed {
+		c.handlers[topic] = make(map[int]func([]byte))
+	}
+	key := c.next
+	c.next++
+	c.handlers[topic][key] = handler
+	c.mu.Unlock()
+
+	unsubscribe := func() {
+		if err := c.unsubscribe(topic, key); err != nil {
+			logger.Warn("MQTT unsubscribe", "topic", topic, "error", err)
+		}
+	}
+
+	if subscribed || !c.IsConnected() {
+		return unsubscribe, nil
+	}
+
+	if err := c.wait(c.client.Subscribe(topic, c.qos, c.dispatch)); err != nil {
+		// it would be subscribed again on every reconnect, pushing values to listener which is not running
+		unsubscribe()
+		return nil, err
+	}
+
+	return unsubscribe, nil
+}
+
+func (c *MQTTClient) unsubscribe(topic string, key int) error {
+	c.mu.Lock()
+	delete(c.handlers[topic], key)
+	last := len(c.handlers[topic]) == 0
+	if last {
+		delete(c.handlers, topic)
+	}
+	c.mu.Unlock()
+
+	if !last || !c.IsConnected() {
+		return nil
+	}
+
+	return c.wait(c.client.Unsubscribe(topic))
+}
+
+func (c *MQTTClient) Publish(topic string, payload []byte) error {
+	if !c.IsConnected() {
+		return ErrMQTTNotConnected
+	}
+
+	return c.wait(c.client.Publish(topic, c.qos, false, payload))
+}
+
+func (c *MQTTClient) wait(token mqtt.Token) error {
+	if !token.WaitTimeout(mqttTimeout) {
+		return ErrMQTTTimeout
+	}
+
+	return token.Error()
+}
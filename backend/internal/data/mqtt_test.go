@@ -0,0 +1,130 @@
+package data_test
+
+import (
+	"inzynierka/internal/data"
+	"inzynierka/internal/data/validator"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestParseMQTTPayload(t *testing.T) {
+	tests := []struct {
+		sensor  data.Sensor
+		payload string
+		value   float64
+		valid   bool
+	}{
+		{data.Sensor{URI: "home/temperature"}, "21.5", 21.5, true},
+		{data.Sensor{URI: "stat/plug/POWER"}, "ON", 1, true},
+		{data.Sensor{URI: "stat/plug/POWER"}, "off", 0, true},
+		{data.Sensor{URI: "zigbee2mqtt/sensor#temperature"}, `{"temperature": 19.2, "humidity": 40}`, 19.2, true},
+		{data.Sensor{URI: "zigbee2mqtt/lamp#state"}, `{"state": "ON"}`, 1, true},
+		{data.Sensor{URI: "zigbee2mqtt/door#contact"}, `{"contact": false}`, 0, true},
+		{data.Sensor{URI: "gate", MQTT: &data.MQTTOptions{PayloadOn: "open", PayloadOff: "closed"}}, "open", 1, true},
+		{data.Sensor{URI: "zigbee2mqtt/sensor#temperature"}, `{"humidity": 40}`, 0, false},
+		{data.Sensor{URI: "zigbee2mqtt/sensor#temperature"}, "21.5", 0, false},
+		{data.Sensor{URI: "home/temperature"}, "warm", 0, false},
+		{data.Sensor{URI: "home/temperature"}, "NaN", 0, false},
+	}
+
+	for _, test := range tests {
+		value, err := data.ParseMQTTPayload(&test.sensor, []byte(test.payload))
+		if (err == nil) != test.valid {
+			t.Errorf("%s %q: Expected valid: %v; Got error: %v", test.sensor.URI, test.payload, test.valid, err)
+			continue
+		}
+		if test.valid && value.Number != test.value {
+			t.Errorf("%s %q: Expected: %v; Got: %v", test.sensor.URI, test.payload, test.value, value)
+		}
+	}
+}
+
+func TestMQTTCommandPayload(t *testing.T) {
+	tests := []struct {
+		sensor   data.Sensor
+		value    float64
+		expected string
+	}{
+		{data.Sensor{Type: data.BinarySwitch}, 1, "ON"},
+		{data.Sensor{Type: data.BinarySwitch, MQTT: &data.MQTTOptions{PayloadOff: "0"}}, 0, "0"},
+		{data.Sensor{Type: data.BinarySwitch, MQTT: &data.MQTTOptions{CommandField: "state"}}, 0, `{"state":"OFF"}`},
+		{data.Sensor{Type: data.DecimalSwitch}, 22.5, "22.5"},
+		{data.Sensor{Type: data.DecimalSwitch, MQTT: &data.MQTTOptions{CommandField: "brightness"}}, 128, `{"brightness":128}`},
+	}
+
+	for _, test := range tests {
+		payload, err := data.MQTTCommandPayload(&test.sensor, data.DecimalValue(test.value))
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if string(payload) != test.expected {
+			t.Errorf("Expected: %s; Got: %s", test.expected, payload)
+		}
+	}
+}
+
+func TestValidateMQTTSensor(t *testing.T) {
+	tests := []struct {
+		sensor data.Sensor
+		valid  bool
+	}{
+		{data.Sensor{URI: "zigbee2mqtt/sensor#temperature", Type: data.DecimalSensor, Active: true}, true},
+		{data.Sensor{URI: "stat/plug/POWER", Type: data.BinarySwitch, Active: true, MQTT: &data.MQTTOptions{CommandTopic: "cmnd/plug/POWER"}}, true},
+		{data.Sensor{URI: "stat/plug/POWER", Type: data.BinarySwitch, Active: true}, false},
+		{data.Sensor{URI: "stat/+/POWER", Type: data.BinarySensor, Active: true}, false},
+		{data.Sensor{URI: "zigbee2mqtt/sensor#", Type: data.DecimalSensor, Active: true}, false},
+		{data.Sensor{URI: "home/temperature", Type: data.DecimalSensor, Active: false, RefreshRate: 5}, false},
+	}
+
+	for _, test := range tests {
+		test.sensor.Name = "sensor"
+		test.sensor.Transport = data.TransportMQTT
+
+		v := validator.New()
+		data.ValidateSensor(v, &test.sensor)
+		if v.Valid() != test.valid {
+			t.Errorf("%s: Expected valid: %v; Got errors: %v", test.sensor.URI, test.valid, v.Errors)
+		}
+	}
+}
+
+// runs against a real broker, eg. mqtt service from docker-compose.yml (see `just test-mqtt`)
+func TestMQTTClientRoundtrip(t *testing.T) {
+	broker := os.Getenv("MQTT_TEST_BROKER")
+	if broker == "" {
+		t.Skip("MQTT_TEST_BROKER not set")
+	}
+
+	client := data.NewMQTTClient(data.MQTTConfig{Broker: broker, ClientID: "household-test-" + uuid.NewString(), QoS: 1})
+	if err := client.Connect(5 * time.Second); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer client.Close()
+
+	topic := "household-test/" + uuid.NewString()
+	received := make(chan string, 1)
+
+	unsubscribe, err := client.Subscribe(topic, func(payload []byte) {
+		received <- string(payload)
+	})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer unsubscribe()
+
+	if err = client.Publish(topic, []byte("21.5")); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	select {
+	case payload := <-received:
+		if payload != "21.5" {
+			t.Errorf("Expected: 21.5; Got: %s", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message was not received")
+	}
+}
@@ -173,3 +173,59 @@ func (m *NotificationModel) GetUnread(userId uuid.UUID) ([]*UserNotification, er
 
 	return notifications, nil
 }
+
+var NotificationLevels = []NotificationLevel{
+	NotificationLevelError,
+	NotificationLevelSuccess,
+	NotificationLevelWarning,
+	NotificationLevelInfo,
+}
+
+// GetForUser returns page of notifications of the user, optionally only read (or unread) ones of given level
+func (m *NotificationModel) GetForUser(userId uuid.UUID, read *bool, level NotificationLevel, filters Filters) ([]*UserNotification, Metadata, error) {
+	q := &listQuery{}
+	q.where("user_id = " + q.arg(userId))
+	if read != nil {
+		q.where("read = " + q.arg(*read))
+	}
+	if level != "" {
+		q.where("level = " + q.arg(level))
+	}
+	filters.search(q, "title")
+	pagination := filters.pagination(q)
+
+	query := `
+    SELECT count(*) OVER(), id, level, title, description, created_at, read FROM notifications
+    INNER JOIN user_notifications ON notifications.id = user_notifications.notification_id
+    ` + q.whereClause() + `
+    ` + filters.orderBy() + `
+    ` + pagination
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, q.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	notifications := make([]*UserNotification, 0)
+
+	for rows.Next() {
+		notif := UserNotification{Users: []uuid.UUID{userId}}
+		err = rows.Scan(&totalRecords, &notif.ID, &notif.Level, &notif.Title, &notif.Description, &notif.CreatedAt, &notif.Read)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		notifications = append(notifications, &notif)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return notifications, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
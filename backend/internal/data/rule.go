@@ -24,12 +24,15 @@ type TargetType string
 const (
 	SensorTarget   TargetType = "sensor"
 	SequenceTarget TargetType = "sequence"
+	SceneTarget    TargetType = "scene"
 )
 
 type ValidRuleAction struct {
 	TargetType TargetType             `json:"target_type"`
 	TargetId   uuid.UUID              `json:"target_id"`
 	Payload    map[string]interface{} `json:"payload"`
+	// rule which requested the action
+	RuleID uuid.UUID `json:"-"`
 }
 
 type Rule struct {
@@ -38,25 +41,39 @@ type Rule struct {
 	Description string          `json:"description"`
 	Internal    RuleInternal    `json:"internal"`
 	OnValid     ValidRuleAction `json:"on_valid"`
+	AreaID      *uuid.UUID      `json:"area_id"`
+	Tags        []string        `json:"tags"`
 	CreatedAt   time.Time       `json:"created_at"`
 	Version     int             `json:"version"`
 	prev        bool
 }
 
-type SensorListeners map[uuid.UUID]*Listener[float64]
+// PayloadValue returns "value" of the payload, which may be a number, a boolean or a string
+func (a *ValidRuleAction) PayloadValue() (SequenceValue, bool) {
+	switch value := a.Payload["value"].(type) {
+	case float64:
+		return NumberValue(value), true
+	case bool:
+		return BoolValue(value), true
+	case string:
+		return TextValue(value), true
+	}
+
+	return SequenceValue{}, false
+}
 
 func (t TargetType) IsValid() bool {
-	return t == SensorTarget || t == SequenceTarget
+	return t == SensorTarget || t == SequenceTarget || t == SceneTarget
 }
 
 // TOOD: Handle stopping on channel close
 // REF: https://pkg.go.dev/reflect#Select
-func (r *Rule) Run(listeners SensorListeners, validCh chan ValidRuleAction, stopCh chan struct{}, m *SensorMeasurementModel) error {
+func (r *Rule) Run(listeners *SensorListeners, validCh chan ValidRuleAction, stopCh chan struct{}, m *SensorMeasurementModel) error {
 	deps := r.Internal.Dependencies()
 	channels := make([]reflect.SelectCase, len(deps)+1)
 	values := make(RuleData)
 	for i, dep := range deps {
-		listener, ok := listeners[dep]
+		listener, ok := listeners.Get(dep)
 		if !ok {
 			return ErrMissingDependencyChan
 		}
@@ -84,7 +101,11 @@ func (r *Rule) Run(listeners SensorListeners, validCh chan ValidRuleAction, stop
 			logger.Debug("stopping rule")
 			break
 		}
-		slice := sliceV.Interface().([]float64)
+		// failed polls are published as nil, last known value is kept
+		slice := sliceV.Interface().([]SensorValue)
+		if len(slice) == 0 {
+			continue
+		}
 		values[deps[i]] = slice[len(slice)-1]
 		// updating rule, sending onValid struct to channel if the rule has just been fulfilled
 		r.update(values, validCh, m)
@@ -101,7 +122,9 @@ func (r *Rule) update(data RuleData, ch chan ValidRuleAction, m *SensorMeasureme
 	// If something changed from previous
 	if cur != r.prev {
 		if cur {
-			ch <- r.OnValid
+			action := r.OnValid
+			action.RuleID = r.ID
+			ch <- action
 		} else {
 		}
 
@@ -116,6 +139,8 @@ func (r *Rule) UnmarshalJSON(data []byte) error {
 		Description string                 `json:"description"`
 		Internal    map[string]interface{} `json:"internal"`
 		OnValid     ValidRuleAction        `json:"on_valid"`
+		AreaID      *uuid.UUID             `json:"area_id"`
+		Tags        []string               `json:"tags"`
 	}{}
 
 	err := json.Unmarshal(data, &tmp)
@@ -127,6 +152,8 @@ func (r *Rule) UnmarshalJSON(data []byte) error {
 	r.Name = tmp.Name
 	r.Description = tmp.Description
 	r.OnValid = tmp.OnValid
+	r.AreaID = tmp.AreaID
+	r.Tags = tmp.Tags
 
 	internal, err := UnmarshalInternalRuleJSON(tmp.Internal)
 	if err != nil {
@@ -142,7 +169,8 @@ func ValidateRule(v *validator.Validator, r *Rule) {
 	v.Check(utf8.RuneCountInString(r.Name) > 0, "name", "must not be empty")
 	v.Check(utf8.RuneCountInString(r.Name) <= 32, "name", "must not be longer than 32 characters")
 	v.Check(utf8.RuneCountInString(r.Description) <= 256, "description", "must not be longer than 256 characters")
-	v.Check(r.OnValid.TargetType.IsValid(), "on_valid.target-type", "must be either 'sensor' or 'sequence'")
+	v.Check(r.OnValid.TargetType.IsValid(), "on_valid.target-type", "must be either 'sensor', 'sequence' or 'scene'")
+	validateTags(v, r.Tags)
 }
 
 type RuleModel struct {
@@ -151,8 +179,8 @@ type RuleModel struct {
 
 func (m *RuleModel) Insert(rule *Rule) error {
 	query := `
-    INSERT INTO rules (id, name, description, internal, valid_target_type, valid_target_id, valid_target_payload)
-    VALUES ($1, $2, $3, $4, $5, $6, $7)
+    INSERT INTO rules (id, name, description, internal, valid_target_type, valid_target_id, valid_target_payload, area_id, tags)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
     RETURNING created_at, version
     `
 
@@ -162,8 +190,9 @@ func (m *RuleModel) Insert(rule *Rule) error {
 	}
 
 	rule.ID = uuid
+	rule.Tags = nonNilTags(rule.Tags)
 
-	args := []any{uuid, rule.Name, rule.Description, rule.Internal, rule.OnValid.TargetType, rule.OnValid.TargetId, rule.OnValid.Payload}
+	args := []any{uuid, rule.Name, rule.Description, rule.Internal, rule.OnValid.TargetType, rule.OnValid.TargetId, rule.OnValid.Payload, rule.AreaID, rule.Tags}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -178,7 +207,7 @@ func (m *RuleModel) Insert(rule *Rule) error {
 
 func (m *RuleModel) Get(id uuid.UUID) (*Rule, error) {
 	query := `
-    SELECT id, name, description, internal, valid_target_type, valid_target_id, valid_target_payload, created_at, version
+    SELECT id, name, description, internal, valid_target_type, valid_target_id, valid_target_payload, area_id, tags, created_at, version
     FROM rules
     WHERE id = $1
     `
@@ -197,6 +226,8 @@ func (m *RuleModel) Get(id uuid.UUID) (*Rule, error) {
 		&ruleS.OnValid.TargetType,
 		&ruleS.OnValid.TargetId,
 		&ruleS.OnValid.Payload,
+		&ruleS.AreaID,
+		&ruleS.Tags,
 		&ruleS.CreatedAt,
 		&ruleS.Version,
 	)
@@ -222,7 +253,7 @@ func (m *RuleModel) Get(id uuid.UUID) (*Rule, error) {
 
 func (m *RuleModel) GetAll() ([]*Rule, error) {
 	query := `
-    SELECT id, name, description, internal, valid_target_type, valid_target_id, valid_target_payload, created_at, version
+    SELECT id, name, description, internal, valid_target_type, valid_target_id, valid_target_payload, area_id, tags, created_at, version
     FROM rules
     ORDER BY id
     `
@@ -250,6 +281,8 @@ func (m *RuleModel) GetAll() ([]*Rule, error) {
 			&ruleS.OnValid.TargetType,
 			&ruleS.OnValid.TargetId,
 			&ruleS.OnValid.Payload,
+			&ruleS.AreaID,
+			&ruleS.Tags,
 			&ruleS.CreatedAt,
 			&ruleS.Version,
 		)
@@ -276,57 +309,69 @@ func (m *RuleModel) GetAll() ([]*Rule, error) {
 }
 
 type RuleSimple struct {
-	ID          uuid.UUID `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
+	ID          uuid.UUID  `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	AreaID      *uuid.UUID `json:"area_id"`
+	Tags        []string   `json:"tags"`
 }
 
-func (m RuleModel) GetAllInfo() ([]*RuleSimple, error) {
+func (m RuleModel) GetAllInfo(filter GroupFilter, filters Filters) ([]*RuleSimple, Metadata, error) {
+	q := &listQuery{}
+	filter.apply(q)
+	filters.search(q, "name")
+	pagination := filters.pagination(q)
+
 	query := `
-    SELECT id, name, description
+    SELECT count(*) OVER(), id, name, description, area_id, tags
     FROM rules
-    ORDER BY id
-    `
+    ` + q.whereClause() + `
+    ` + filters.orderBy() + `
+    ` + pagination
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.Query(ctx, query)
+	rows, err := m.DB.Query(ctx, query, q.args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 	defer rows.Close()
 
+	totalRecords := 0
 	rules := []*RuleSimple{}
 
 	for rows.Next() {
 		var ruleS RuleSimple
 
 		err = rows.Scan(
+			&totalRecords,
 			&ruleS.ID,
 			&ruleS.Name,
 			&ruleS.Description,
+			&ruleS.AreaID,
+			&ruleS.Tags,
 		)
 
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
 		rules = append(rules, &ruleS)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
-	return rules, nil
+	return rules, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
 }
 
 func (m RuleModel) Update(rule *Rule) error {
 	query := `
        UPDATE rules
-       SET name = $1, description = $2, internal = $3, valid_target_type = $4, valid_target_id = $5, valid_target_payload = $6, version = version + 1
-       WHERE id = $7
-       RETURNING version 
+       SET name = $1, description = $2, internal = $3, valid_target_type = $4, valid_target_id = $5, valid_target_payload = $6, area_id = $7, tags = $8, version = version + 1
+       WHERE id = $9 AND version = $10
+       RETURNING version
     `
 
 	args := []any{
@@ -336,7 +381,10 @@ func (m RuleModel) Update(rule *Rule) error {
 		rule.OnValid.TargetType,
 		rule.OnValid.TargetId,
 		rule.OnValid.Payload,
+		rule.AreaID,
+		nonNilTags(rule.Tags),
 		rule.ID,
+		rule.Version,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -345,7 +393,12 @@ func (m RuleModel) Update(rule *Rule) error {
 	err := m.DB.QueryRow(ctx, query, args...).Scan(&rule.Version)
 
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
 	}
 	return nil
 }
@@ -28,7 +28,7 @@ var (
 	ErrParseInvalidData = errors.New(`Field contains invalid data`)
 )
 
-type RuleData map[uuid.UUID]float64
+type RuleData map[uuid.UUID]SensorValue
 
 type RuleInternal interface {
 	Process(data RuleData, model *SensorMeasurementModel) (bool, error)
@@ -91,6 +91,35 @@ func unmarshalSimple(data map[string]interface{}) (uuid.UUID, float64, error) {
 	return sensorID, value, nil
 }
 
+func unmarshalEq(data map[string]interface{}) (*RuleEq, error) {
+	idData, ok := data["sensor_id"]
+	if !ok {
+		return nil, ErrParseMissingSensorID
+	}
+
+	idStr, ok := idData.(string)
+	if !ok {
+		return nil, ErrParseInvalidType
+	}
+
+	sensorID, err := uuid.Parse(idStr)
+	if err != nil {
+		return nil, err
+	}
+
+	valueData, ok := data["value"]
+	if !ok {
+		return nil, ErrParseMissingValue
+	}
+
+	value, ok := sensorValueOf(valueData)
+	if !ok {
+		return nil, ErrParseInvalidType
+	}
+
+	return &RuleEq{SensorID: sensorID, Value: value}, nil
+}
+
 func unmarshalPerc(data map[string]interface{}) (*RulePerc, error) {
 	idData, ok := data["sensor_id"]
 	if !ok {
@@ -314,6 +343,8 @@ func UnmarshalInternalRuleJSON(data map[string]interface{}) (RuleInternal, error
 		}
 
 		return &RuleLT{SensorID: sensorID, Value: value}, nil
+	case "eq":
+		return unmarshalEq(data)
 	case "perc":
 		return unmarshalPerc(data)
 	case "time":
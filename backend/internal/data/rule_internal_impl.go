@@ -57,7 +57,7 @@ func (r *RuleAnd) Dependencies() []uuid.UUID {
 }
 
 func (r *RuleAnd) Validate(v *validator.Validator) {
-	v.Check(len(r.Children) < 1, "and", "must have at least one child")
+	v.Check(len(r.Children) >= 1, "and", "must have at least one child")
 	for _, child := range r.Children {
 		child.Validate(v)
 	}
@@ -87,7 +87,7 @@ func (r *RuleGT) Process(data RuleData, _ *SensorMeasurementModel) (bool, error)
 		return false, ErrMissingVal
 	}
 
-	return val > r.Value, nil
+	return !val.IsText() && val.Number > r.Value, nil
 }
 
 func (r *RuleGT) Dependencies() []uuid.UUID {
@@ -121,7 +121,7 @@ func (r *RuleLT) Process(data RuleData, _ *SensorMeasurementModel) (bool, error)
 		return false, ErrMissingVal
 	}
 
-	return val < r.Value, nil
+	return !val.IsText() && val.Number < r.Value, nil
 }
 
 func (r *RuleLT) Dependencies() []uuid.UUID {
@@ -131,6 +131,42 @@ func (r *RuleLT) Dependencies() []uuid.UUID {
 func (r *RuleLT) Validate(v *validator.Validator) {
 }
 
+// RuleEq holds when value of the sensor equals given number, boolean or text (eg. enum option "heat")
+type RuleEq struct {
+	SensorID uuid.UUID   `json:"sensor_id"`
+	Value    SensorValue `json:"value"`
+}
+
+type FakeEq RuleEq
+
+func (r RuleEq) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		FakeEq
+		Type string `json:"type"`
+	}{
+		FakeEq: FakeEq(r),
+		Type:   "eq",
+	})
+}
+
+func (r *RuleEq) Process(data RuleData, _ *SensorMeasurementModel) (bool, error) {
+	val, ok := data[r.SensorID]
+
+	if !ok {
+		return false, ErrMissingVal
+	}
+
+	return val.Equal(r.Value), nil
+}
+
+func (r *RuleEq) Dependencies() []uuid.UUID {
+	return []uuid.UUID{r.SensorID}
+}
+
+func (r *RuleEq) Validate(v *validator.Validator) {
+	v.Check(!r.Value.IsText() || r.Value.Text != "", "ruleEq", "Value should not be empty")
+}
+
 type RuleNot struct {
 	Wrapped RuleInternal `json:"wrapped"`
 }
@@ -157,6 +193,7 @@ func (r *RuleNot) Dependencies() []uuid.UUID {
 }
 
 func (r *RuleNot) Validate(v *validator.Validator) {
+	r.Wrapped.Validate(v)
 }
 
 type RuleOr struct {
@@ -205,7 +242,7 @@ func (r *RuleOr) Dependencies() []uuid.UUID {
 }
 
 func (r *RuleOr) Validate(v *validator.Validator) {
-	v.Check(len(r.Children) < 1, "or", "must have at least one child")
+	v.Check(len(r.Children) >= 1, "or", "must have at least one child")
 	for _, child := range r.Children {
 		child.Validate(v)
 	}
@@ -246,7 +283,7 @@ func (r *RulePerc) Process(data RuleData, m *SensorMeasurementModel) (bool, erro
 	if err != nil {
 		return false, err
 	}
-	return val >= perc, nil
+	return !val.IsText() && val.Number >= perc, nil
 }
 
 func (r *RulePerc) Dependencies() []uuid.UUID {
@@ -255,7 +292,7 @@ func (r *RulePerc) Dependencies() []uuid.UUID {
 
 func (r *RulePerc) Validate(v *validator.Validator) {
 	v.Check(r.Percentile > 0, "rulePerc", "Percentile should be larger than 0")
-	v.Check(r.Percentile <= 0, "rulePerc", "Percentile smaller or equal 100")
+	v.Check(r.Percentile <= 100, "rulePerc", "Percentile smaller or equal 100")
 	v.Check(r.Delta > 0, "rulePerc", "Duration should be larger than 0")
 }
 
@@ -370,5 +407,6 @@ func (r *RuleDay) Validate(v *validator.Validator) {
 	v.Check(true, "format", "")
 	v.Check(checkRange(r.Days, 1, 31), "format", "days should contain values between 1 and 31")
 	v.Check(checkRange(r.Months, 1, 12), "format", "months should contain values between 1 and 12")
-	v.Check(checkRange(r.Weekdays, 1, 7), "format", "weekdays should contain values between 1 and 7")
+	// sunday (7 in format) is stored as time.Sunday (0)
+	v.Check(checkRange(r.Weekdays, time.Sunday, time.Saturday), "format", "weekdays should contain values between 1 and 7")
 }
@@ -47,8 +47,8 @@ func TestRuleGTProcess(t *testing.T) {
 	}
 
 	for _, test := range GTtests {
-		data := map[uuid.UUID]float64{
-			sensorId: test.in,
+		data := data.RuleData{
+			sensorId: data.DecimalValue(test.in),
 		}
 
 		got, err := rule.Process(data, nil)
@@ -72,7 +72,7 @@ func TestRuleGTProcessError(t *testing.T) {
 		Value:    10,
 	}
 
-	json := make(map[uuid.UUID]float64)
+	json := make(data.RuleData)
 
 	if _, err := rulegt.Process(json, nil); !errors.Is(err, data.ErrMissingVal) {
 		t.Errorf("wanted error, got %s", err.Error())
@@ -117,8 +117,8 @@ func TestRuleLTProcess(t *testing.T) {
 	}
 
 	for _, test := range LTtests {
-		data := map[uuid.UUID]float64{
-			sensorId: test.in,
+		data := data.RuleData{
+			sensorId: data.DecimalValue(test.in),
 		}
 
 		got, err := rule.Process(data, nil)
@@ -142,7 +142,7 @@ func TestRuleLTProcessError(t *testing.T) {
 		Value:    10,
 	}
 
-	json := make(map[uuid.UUID]float64)
+	json := make(data.RuleData)
 
 	if _, err := rulegt.Process(json, nil); !errors.Is(err, data.ErrMissingVal) {
 		t.Errorf("wanted error, got %s", err.Error())
@@ -204,9 +204,9 @@ func TestRuleAndProcess(t *testing.T) {
 	}
 
 	for _, test := range AndTests {
-		data := map[uuid.UUID]float64{
-			sensorId1: test.in[0],
-			sensorId2: test.in[1],
+		data := data.RuleData{
+			sensorId1: data.DecimalValue(test.in[0]),
+			sensorId2: data.DecimalValue(test.in[1]),
 		}
 
 		got, err := ruleAnd.Process(data, nil)
@@ -276,9 +276,9 @@ func TestRuleOrProcess(t *testing.T) {
 	}
 
 	for _, test := range OrTests {
-		data := map[uuid.UUID]float64{
-			sensorId1: test.in[0],
-			sensorId2: test.in[1],
+		data := data.RuleData{
+			sensorId1: data.DecimalValue(test.in[0]),
+			sensorId2: data.DecimalValue(test.in[1]),
 		}
 
 		got, err := ruleAnd.Process(data, nil)
@@ -425,3 +425,44 @@ func TestRuleDayParseList(t *testing.T) {
 		t.Errorf("expected weekdays contain %s", WEEKDAYS[4-1])
 	}
 }
+
+func TestRuleEqProcess(t *testing.T) {
+	sensorId := uuid.New()
+
+	rule, err := data.UnmarshalInternalRuleJSON(map[string]interface{}{"type": "eq", "sensor_id": sensorId.String(), "value": "heat"})
+	if err != nil {
+		t.Fatalf("expected err to be nil, got %s", err.Error())
+	}
+
+	tests := []struct {
+		in  data.SensorValue
+		out bool
+	}{
+		{data.SensorValue{Kind: data.KindEnum, Number: 0, Text: "heat"}, true},
+		{data.SensorValue{Kind: data.KindEnum, Number: 1, Text: "cool"}, false},
+		{data.DecimalValue(0), false},
+	}
+
+	for _, test := range tests {
+		got, err := rule.Process(data.RuleData{sensorId: test.in}, nil)
+		if err != nil {
+			t.Errorf("test case %v returned error", test.in)
+			continue
+		}
+
+		if got != test.out {
+			t.Errorf("test case %v: wanted %t, got %t", test.in, test.out, got)
+		}
+	}
+
+	boolRule := data.RuleEq{SensorID: sensorId, Value: data.BooleanValue(true)}
+	if got, _ := boolRule.Process(data.RuleData{sensorId: data.DecimalValue(1)}, nil); !got {
+		t.Errorf("expected true to equal 1")
+	}
+
+	// text values are never greater or lower than a number
+	ruleGT := data.RuleGT{SensorID: sensorId, Value: -1}
+	if got, _ := ruleGT.Process(data.RuleData{sensorId: data.StringValue("heat")}, nil); got {
+		t.Errorf("expected text value not to be greater than a number")
+	}
+}
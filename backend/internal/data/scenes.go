@@ -0,0 +1,220 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"inzynierka/internal/data/validator"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// target value of a single switch in a scene
+type SceneValue struct {
+	Sensor uuid.UUID     `json:"sensor"`
+	Value  SequenceValue `json:"value"`
+}
+
+type Scene struct {
+	ID          uuid.UUID    `json:"id"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Values      []SceneValue `json:"values"`
+	CreatedAt   time.Time    `json:"created_at"`
+	Version     int          `json:"version"`
+}
+
+type SceneInfo struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+}
+
+// SceneValueFor converts value read from the sensor into scene value, binary switches store booleans
+// and enum switches their option
+func SceneValueFor(sensor *Sensor, value SensorValue) SceneValue {
+	switch sensor.Type {
+	case BinarySwitch:
+		return SceneValue{Sensor: sensor.ID, Value: BoolValue(value.Number != 0)}
+	case EnumSwitch:
+		return SceneValue{Sensor: sensor.ID, Value: TextValue(value.Text)}
+	}
+
+	return SceneValue{Sensor: sensor.ID, Value: NumberValue(value.Number)}
+}
+
+// validates scene, every value must target existing writable sensor from sensors, at most once
+func ValidateScene(v *validator.Validator, scene *Scene, sensors map[uuid.UUID]*Sensor) {
+	v.Check(utf8.RuneCountInString(scene.Name) > 0, "name", "must not be empty")
+	v.Check(utf8.RuneCountInString(scene.Name) <= 32, "name", "must not be longer than 32 characters")
+	v.Check(utf8.RuneCountInString(scene.Description) <= 256, "description", "must not be longer than 256 characters")
+	v.Check(len(scene.Values) > 0, "values", "must have at least one value")
+
+	seen := make(map[uuid.UUID]struct{}, len(scene.Values))
+
+	for i, value := range scene.Values {
+		field := fmt.Sprintf("values[%d]", i)
+
+		sensor, ok := sensors[value.Sensor]
+		if !ok {
+			v.AddError(field+".sensor", "must reference existing sensor")
+			continue
+		}
+
+		if !sensor.Type.IsWritable() {
+			v.AddError(field+".sensor", "must reference binary, decimal or enum switch")
+			continue
+		}
+
+		if _, ok := seen[value.Sensor]; ok {
+			v.AddError(field+".sensor", "must not be repeated")
+			continue
+		}
+		seen[value.Sensor] = struct{}{}
+
+		ValidateSensorValue(v, field+".value", sensor, value.Value)
+	}
+}
+
+type SceneModel struct {
+	DB *pgxpool.Pool
+}
+
+func (m SceneModel) Insert(scene *Scene) error {
+	query := `INSERT INTO scenes (id, name, description, targets)
+	VALUES ($1, $2, $3, $4)
+	RETURNING created_at, version`
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return err
+	}
+
+	scene.ID = id
+
+	args := []any{scene.ID, scene.Name, scene.Description, scene.Values}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRow(ctx, query, args...).Scan(&scene.CreatedAt, &scene.Version)
+}
+
+func (m SceneModel) GetAllInfo(filters Filters) ([]*SceneInfo, Metadata, error) {
+	q := &listQuery{}
+	filters.search(q, "name")
+	pagination := filters.pagination(q)
+
+	query := `SELECT count(*) OVER(), id, name, description
+	FROM scenes
+	` + q.whereClause() + `
+	` + filters.orderBy() + `
+	` + pagination
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, q.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	allInfo := []*SceneInfo{}
+
+	for rows.Next() {
+		var info SceneInfo
+
+		err := rows.Scan(&totalRecords, &info.ID, &info.Name, &info.Description)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		allInfo = append(allInfo, &info)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return allInfo, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func (m SceneModel) Get(id uuid.UUID) (*Scene, error) {
+	query := `SELECT id, name, description, targets, created_at, version
+	FROM scenes
+	WHERE id = $1`
+
+	var scene Scene
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, id).Scan(
+		&scene.ID,
+		&scene.Name,
+		&scene.Description,
+		&scene.Values,
+		&scene.CreatedAt,
+		&scene.Version,
+	)
+
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &scene, nil
+}
+
+func (m SceneModel) Update(scene *Scene) error {
+	query := `UPDATE scenes
+	SET name = $2, description = $3, targets = $4, version = version + 1
+	WHERE id = $1 AND version = $5
+	RETURNING version`
+
+	args := []any{scene.ID, scene.Name, scene.Description, scene.Values, scene.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, args...).Scan(&scene.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m SceneModel) Delete(id uuid.UUID) error {
+	query := `DELETE FROM scenes
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package data_test
+
+import (
+	"inzynierka/internal/data"
+	"inzynierka/internal/data/validator"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+var sceneValidationTests = []struct {
+	name   string
+	values []data.SceneValue
+	key    string
+}{
+	{"no values", nil, "values"},
+	{"unknown sensor", []data.SceneValue{{Sensor: uuid.New()}}, "values[0].sensor"},
+	{"read only sensor", []data.SceneValue{{Sensor: decimalSensor.ID}}, "values[0].sensor"},
+	{"number for binary switch", []data.SceneValue{{Sensor: binarySwitch.ID, Value: data.NumberValue(2)}}, "values[0].value"},
+	{"repeated sensor", []data.SceneValue{{Sensor: binarySwitch.ID}, {Sensor: binarySwitch.ID}}, "values[1].sensor"},
+}
+
+func TestValidateScene(t *testing.T) {
+	for _, test := range sceneValidationTests {
+		v := validator.New()
+		data.ValidateScene(v, &data.Scene{Name: "Wieczór", Values: test.values}, validationSensors)
+
+		if _, ok := v.Errors[test.key]; !ok {
+			t.Errorf("%s: expected error for %q, got %v", test.name, test.key, v.Errors)
+		}
+	}
+
+	scene := data.Scene{
+		Name: "Wieczór",
+		Values: []data.SceneValue{
+			data.SceneValueFor(binarySwitch, data.DecimalValue(1)),
+			data.SceneValueFor(decimalSwitch, data.DecimalValue(21.5)),
+		},
+	}
+
+	v := validator.New()
+	data.ValidateScene(v, &scene, validationSensors)
+
+	if !v.Valid() {
+		t.Errorf("expected valid scene, got %v", v.Errors)
+	}
+
+	if scene.Values[0].Value != data.BoolValue(true) {
+		t.Errorf("Expected: %v; Got: %v", data.BoolValue(true), scene.Values[0].Value)
+	}
+}
+
+func TestSequenceScenes(t *testing.T) {
+	scene := uuid.New()
+
+	actions := []data.SequenceAction{
+		{Type: data.ActionCall, Sequence: uuid.New()},
+		{
+			Type:     data.ActionParallel,
+			Branches: [][]data.SequenceAction{{{Type: data.ActionScene, Scene: scene}}, {{Type: data.ActionScene, Scene: scene}}},
+		},
+	}
+
+	scenes := data.SequenceScenes(actions)
+	if !slices.Equal(scenes, []uuid.UUID{scene}) {
+		t.Errorf("Expected: %v; Got: %v", []uuid.UUID{scene}, scenes)
+	}
+}
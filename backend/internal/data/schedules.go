@@ -0,0 +1,368 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"inzynierka/internal/data/validator"
+	"time"
+	// schedules are evaluated in their time zones also where system has no zoneinfo, eg. in containers
+	_ "time/tzdata"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type ScheduleType string
+
+const (
+	// single run at given time
+	ScheduleOnce ScheduleType = "once"
+	// recurring runs described by cron expression
+	ScheduleCron ScheduleType = "cron"
+)
+
+func (t ScheduleType) IsValid() bool {
+	return t == ScheduleOnce || t == ScheduleCron
+}
+
+type Schedule struct {
+	ID         uuid.UUID    `json:"id"`
+	SequenceID uuid.UUID    `json:"sequence_id"`
+	Type       ScheduleType `json:"type"`
+	RunAt      *time.Time   `json:"run_at"`
+	Cron       string       `json:"cron"`
+	Enabled    bool         `json:"enabled"`
+	// IANA time zone cron expressions are evaluated in, eg. Europe/Warsaw. UTC if empty
+	Timezone string `json:"timezone"`
+	// occurrence which will not be run
+	SkipAt    *time.Time `json:"skip_at"`
+	LastRunAt *time.Time `json:"last_run_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	Version   int        `json:"version"`
+}
+
+func ValidateSchedule(v *validator.Validator, schedule *Schedule) {
+	v.Check(schedule.Type.IsValid(), "type", "must be either 'once' or 'cron'")
+
+	_, err := time.LoadLocation(schedule.Timezone)
+	v.Check(err == nil, "timezone", "must be a valid IANA time zone")
+
+	switch schedule.Type {
+	case ScheduleOnce:
+		v.Check(schedule.RunAt != nil, "run_at", "must be provided")
+	case ScheduleCron:
+		if schedule.Cron == "" {
+			v.AddError("cron", "must be provided")
+			break
+		}
+
+		cron, err := ParseCron(schedule.Cron)
+		if err != nil {
+			v.AddError("cron", err.Error())
+			break
+		}
+
+		_, err = cron.Next(time.Now())
+		v.Check(err == nil, "cron", "must have at least one occurrence")
+	}
+}
+
+// invalid time zones are rejected by validation, UTC is only a fallback
+func (s *Schedule) location() *time.Location {
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+
+	return loc
+}
+
+// ValidateScheduleRunAt checks new timing of one-shot schedule, which would never run with time in the past.
+// Schedules which have already run keep their time, so it is not checked on every update
+func ValidateScheduleRunAt(v *validator.Validator, schedule *Schedule) {
+	if schedule.Type == ScheduleOnce && schedule.RunAt != nil {
+		v.Check(schedule.RunAt.After(time.Now()), "run_at", "must be in the future")
+	}
+}
+
+// returns the first occurrence strictly after given time, ignoring skipped occurrence
+func (s *Schedule) nextOccurrence(after time.Time) (time.Time, bool) {
+	switch s.Type {
+	case ScheduleOnce:
+		if s.RunAt == nil || !s.RunAt.After(after) {
+			return time.Time{}, false
+		}
+		return *s.RunAt, true
+	case ScheduleCron:
+		cron, err := ParseCron(s.Cron)
+		if err != nil {
+			return time.Time{}, false
+		}
+
+		next, err := cron.Next(after.In(s.location()))
+		if err != nil {
+			return time.Time{}, false
+		}
+		return next, true
+	}
+
+	return time.Time{}, false
+}
+
+// NextRuns returns up to n upcoming runs strictly after given time, skipped occurrence is left out
+func (s *Schedule) NextRuns(after time.Time, n int) []time.Time {
+	runs := make([]time.Time, 0, n)
+
+	for len(runs) < n {
+		next, ok := s.nextOccurrence(after)
+		if !ok {
+			break
+		}
+		after = next
+
+		if s.SkipAt != nil && next.Equal(*s.SkipAt) {
+			continue
+		}
+
+		runs = append(runs, next)
+	}
+
+	return runs
+}
+
+// Next returns the first run strictly after given time
+func (s *Schedule) Next(after time.Time) (time.Time, bool) {
+	runs := s.NextRuns(after, 1)
+	if len(runs) == 0 {
+		return time.Time{}, false
+	}
+
+	return runs[0], true
+}
+
+// SkipNext marks the first run after given time as skipped and returns it
+func (s *Schedule) SkipNext(after time.Time) (time.Time, bool) {
+	next, ok := s.Next(after)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	s.SkipAt = &next
+	return next, true
+}
+
+type ScheduleModel struct {
+	DB *pgxpool.Pool
+}
+
+func (m ScheduleModel) Insert(schedule *Schedule) error {
+	query := `INSERT INTO sequence_schedules (id, sequence_id, schedule_type, run_at, cron, enabled, timezone)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	RETURNING created_at, version`
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return err
+	}
+
+	schedule.ID = id
+
+	args := []any{schedule.ID, schedule.SequenceID, schedule.Type, schedule.RunAt, schedule.Cron, schedule.Enabled, schedule.Timezone}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRow(ctx, query, args...).Scan(&schedule.CreatedAt, &schedule.Version)
+}
+
+func scanSchedule(row pgx.Row, schedule *Schedule) error {
+	return row.Scan(
+		&schedule.ID,
+		&schedule.SequenceID,
+		&schedule.Type,
+		&schedule.RunAt,
+		&schedule.Cron,
+		&schedule.Enabled,
+		&schedule.Timezone,
+		&schedule.SkipAt,
+		&schedule.LastRunAt,
+		&schedule.CreatedAt,
+		&schedule.Version,
+	)
+}
+
+func (m ScheduleModel) Get(id uuid.UUID) (*Schedule, error) {
+	query := `SELECT id, sequence_id, schedule_type, run_at, cron, enabled, timezone, skip_at, last_run_at, created_at, version
+	FROM sequence_schedules
+	WHERE id = $1`
+
+	var schedule Schedule
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := scanSchedule(m.DB.QueryRow(ctx, query, id), &schedule)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &schedule, nil
+}
+
+func (m ScheduleModel) query(query string, args ...any) ([]*Schedule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []*Schedule{}
+
+	for rows.Next() {
+		var schedule Schedule
+
+		err := scanSchedule(rows, &schedule)
+		if err != nil {
+			return nil, err
+		}
+
+		schedules = append(schedules, &schedule)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return schedules, nil
+}
+
+// GetForSequence returns page of schedules of the sequence
+func (m ScheduleModel) GetForSequence(sequenceId uuid.UUID, filters Filters) ([]*Schedule, Metadata, error) {
+	q := &listQuery{}
+	q.where("sequence_id = " + q.arg(sequenceId))
+	pagination := filters.pagination(q)
+
+	query := `SELECT count(*) OVER(), id, sequence_id, schedule_type, run_at, cron, enabled, timezone, skip_at, last_run_at, created_at, version
+	FROM sequence_schedules
+	` + q.whereClause() + `
+	` + filters.orderBy() + `
+	` + pagination
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, q.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	schedules := []*Schedule{}
+
+	for rows.Next() {
+		var schedule Schedule
+
+		err := rows.Scan(
+			&totalRecords,
+			&schedule.ID,
+			&schedule.SequenceID,
+			&schedule.Type,
+			&schedule.RunAt,
+			&schedule.Cron,
+			&schedule.Enabled,
+			&schedule.Timezone,
+			&schedule.SkipAt,
+			&schedule.LastRunAt,
+			&schedule.CreatedAt,
+			&schedule.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		schedules = append(schedules, &schedule)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return schedules, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func (m ScheduleModel) GetAllEnabled() ([]*Schedule, error) {
+	query := `SELECT id, sequence_id, schedule_type, run_at, cron, enabled, timezone, skip_at, last_run_at, created_at, version
+	FROM sequence_schedules
+	WHERE enabled`
+
+	return m.query(query)
+}
+
+func (m ScheduleModel) Update(schedule *Schedule) error {
+	query := `UPDATE sequence_schedules
+	SET schedule_type = $2, run_at = $3, cron = $4, enabled = $5, timezone = $6, skip_at = $7, version = version + 1
+	WHERE id = $1 AND version = $8
+	RETURNING version`
+
+	args := []any{schedule.ID, schedule.Type, schedule.RunAt, schedule.Cron, schedule.Enabled, schedule.Timezone, schedule.SkipAt, schedule.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRow(ctx, query, args...).Scan(&schedule.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MarkRun stores occurrence as the last run, one-shot schedules get disabled
+// and skipped occurrence is cleared once it has passed
+func (m ScheduleModel) MarkRun(schedule *Schedule, at time.Time) error {
+	query := `UPDATE sequence_schedules
+	SET last_run_at = $2,
+		enabled = enabled AND schedule_type <> 'once',
+		skip_at = CASE WHEN skip_at <= $2 THEN NULL ELSE skip_at END
+	WHERE id = $1
+	RETURNING last_run_at, enabled, skip_at`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRow(ctx, query, schedule.ID, at).Scan(&schedule.LastRunAt, &schedule.Enabled, &schedule.SkipAt)
+}
+
+func (m ScheduleModel) Delete(id uuid.UUID) error {
+	query := `DELETE FROM sequence_schedules
+	WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := m.DB.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	if result.RowsAffected() == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
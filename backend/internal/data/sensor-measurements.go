@@ -11,22 +11,67 @@ import (
 )
 
 type SensorMeasurement struct {
-	SensorID      uuid.UUID
-	MeasuredAt    time.Time
-	MeasuredValue float64
+	SensorID      uuid.UUID `json:"sensor_id"`
+	MeasuredAt    time.Time `json:"measured_at"`
+	MeasuredValue float64   `json:"measured_value"`
+	// value before transforms of the sensor, only set if the sensor stores it
+	RawValue *float64 `json:"raw_value,omitempty"`
+	// option of enum sensor or value of text sensor, MeasuredValue keeps index of the option (0 for text)
+	TextValue *string `json:"text_value,omitempty"`
 }
 
+// NewSensorMeasurement stores value received from the sensor, raw value is kept only if it is a number
+func NewSensorMeasurement(sensor *Sensor, value, raw SensorValue, at time.Time) SensorMeasurement {
+	measurement := SensorMeasurement{
+		SensorID:      sensor.ID,
+		MeasuredAt:    at,
+		MeasuredValue: value.Number,
+	}
+
+	if value.IsText() {
+		measurement.TextValue = &value.Text
+	}
+
+	if sensor.StoreRaw && !raw.IsText() {
+		measurement.RawValue = &raw.Number
+	}
+
+	return measurement
+}
+
+// Value returns stored value, text values are returned as strings and everything else as decimals
+func (m *SensorMeasurement) Value() SensorValue {
+	if m.TextValue != nil {
+		return StringValue(*m.TextValue)
+	}
+	return DecimalValue(m.MeasuredValue)
+}
+
+// ValueFor returns stored value as value of the sensor, eg. boolean for binary sensors.
+// Values which no longer fit the sensor (eg. after its options changed) are returned as stored
+func (m *SensorMeasurement) ValueFor(sensor *Sensor) SensorValue {
+	value, err := sensor.Coerce(m.Value())
+	if err != nil {
+		return m.Value()
+	}
+	return value
+}
+
+// Raw measurements are kept for the retention period of their sensor, older ones are rolled up
+// into 1 minute aggregates, which in turn are rolled up into 1 hour aggregates.
+// Read queries go through sensor_measurements_history view, so they see the whole history
+// in the best resolution available for each period, aggregates are represented by their average
 type SensorMeasurementModel struct {
 	DB *pgxpool.Pool
 }
 
 func (m *SensorMeasurementModel) Insert(measurement *SensorMeasurement) error {
 	query := `
-    INSERT INTO sensor_measurements (sensor_id, measured_at, measured_value)
-    VALUES ($1, $2, $3)
+    INSERT INTO sensor_measurements (sensor_id, measured_at, measured_value, raw_value, text_value)
+    VALUES ($1, $2, $3, $4, $5)
     `
 
-	args := []any{measurement.SensorID, measurement.MeasuredAt, measurement.MeasuredValue}
+	args := []any{measurement.SensorID, measurement.MeasuredAt, measurement.MeasuredValue, measurement.RawValue, measurement.TextValue}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -39,10 +84,59 @@ func (m *SensorMeasurementModel) Insert(measurement *SensorMeasurement) error {
 	return nil
 }
 
+// InsertBatch writes measurements with a single copy. If any of them can not be written (e.g. it is already stored
+// or its sensor has been deleted), which makes the copy fail as a whole, they are inserted one by one
+// skipping the duplicates, so only the failing ones are lost. Those are reported with BatchInsertError
+func (m *SensorMeasurementModel) InsertBatch(measurements []SensorMeasurement) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := m.DB.CopyFrom(
+		ctx,
+		pgx.Identifier{"sensor_measurements"},
+		[]string{"sensor_id", "measured_at", "measured_value", "raw_value", "text_value"},
+		pgx.CopyFromSlice(len(measurements), func(i int) ([]any, error) {
+			return []any{measurements[i].SensorID, measurements[i].MeasuredAt, measurements[i].MeasuredValue, measurements[i].RawValue, measurements[i].TextValue}, nil
+		}),
+	)
+
+	if err == nil {
+		return nil
+	}
+
+	query := `
+    INSERT INTO sensor_measurements (sensor_id, measured_at, measured_value, raw_value, text_value)
+    VALUES ($1, $2, $3, $4, $5)
+    ON CONFLICT (sensor_id, measured_at) DO NOTHING
+    `
+
+	// pgx.Batch runs in a single implicit transaction, so every row has to be sent on its own
+	rowCtx, rowCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer rowCancel()
+
+	var batchErr *BatchInsertError
+	for _, measurement := range measurements {
+		_, err := m.DB.Exec(rowCtx, query, measurement.SensorID, measurement.MeasuredAt, measurement.MeasuredValue, measurement.RawValue, measurement.TextValue)
+		if err == nil {
+			continue
+		}
+
+		if batchErr == nil {
+			batchErr = &BatchInsertError{Err: err}
+		}
+		batchErr.Failed++
+	}
+
+	if batchErr != nil {
+		return batchErr
+	}
+	return nil
+}
+
 func (m *SensorMeasurementModel) GetLastMeasurement(id uuid.UUID) (*SensorMeasurement, error) {
 	query := `
-	SELECT measured_at, measured_value
-	FROM sensor_measurements
+	SELECT measured_at, avg_value, text_value
+	FROM sensor_measurements_history
 	WHERE sensor_id = $1
 	ORDER BY measured_at DESC
 	LIMIT 1;
@@ -52,7 +146,7 @@ func (m *SensorMeasurementModel) GetLastMeasurement(id uuid.UUID) (*SensorMeasur
 	defer cancel()
 
 	lastMeasurement := SensorMeasurement{SensorID: id}
-	err := m.DB.QueryRow(ctx, query, id).Scan(&lastMeasurement.MeasuredAt, &lastMeasurement.MeasuredValue)
+	err := m.DB.QueryRow(ctx, query, id).Scan(&lastMeasurement.MeasuredAt, &lastMeasurement.MeasuredValue, &lastMeasurement.TextValue)
 
 	if err != nil {
 		switch {
@@ -68,8 +162,8 @@ func (m *SensorMeasurementModel) GetLastMeasurement(id uuid.UUID) (*SensorMeasur
 
 func (m *SensorMeasurementModel) GetLastNMeasurements(id uuid.UUID, n int) ([]*SensorMeasurement, error) {
 	query := `
-	SELECT measured_at, measured_value
-	FROM sensor_measurements
+	SELECT measured_at, avg_value, text_value
+	FROM sensor_measurements_history
 	WHERE sensor_id = $1
 	ORDER BY measured_at DESC
 	LIMIT $2;
@@ -91,7 +185,7 @@ func (m *SensorMeasurementModel) GetLastNMeasurements(id uuid.UUID, n int) ([]*S
 	for rows.Next() {
 		measurement := SensorMeasurement{SensorID: id}
 
-		err := rows.Scan(&measurement.MeasuredAt, &measurement.MeasuredValue)
+		err := rows.Scan(&measurement.MeasuredAt, &measurement.MeasuredValue, &measurement.TextValue)
 		if err != nil {
 			return nil, err
 		}
@@ -105,7 +199,7 @@ func (m *SensorMeasurementModel) GetLastNMeasurements(id uuid.UUID, n int) ([]*S
 // INFO: how to name this :(
 func (m *SensorMeasurementModel) GetMeasurementsSince(id uuid.UUID, delta time.Duration) ([]*SensorMeasurement, error) {
 	query := `
-    SELECT measured_at, measured_value from sensor_measurements
+    SELECT measured_at, avg_value, text_value from sensor_measurements_history
     WHERE sensor_id = $1
     AND now() - measured_at < $2
     `
@@ -123,7 +217,7 @@ func (m *SensorMeasurementModel) GetMeasurementsSince(id uuid.UUID, delta time.D
 	for rows.Next() {
 		measurement := SensorMeasurement{SensorID: id}
 
-		err := rows.Scan(&measurement.MeasuredAt, &measurement.MeasuredValue)
+		err := rows.Scan(&measurement.MeasuredAt, &measurement.MeasuredValue, &measurement.TextValue)
 		if err != nil {
 			return nil, err
 		}
@@ -136,7 +230,7 @@ func (m *SensorMeasurementModel) GetMeasurementsSince(id uuid.UUID, delta time.D
 
 func (m *SensorMeasurementModel) GetPercentile(id uuid.UUID, delta time.Duration, percentile int) (float64, error) {
 	query := `
-    SELECT percentile_disc($1) WITHIN GROUP ( ORDER BY measured_value ) FROM sensor_measurements
+    SELECT percentile_disc($1) WITHIN GROUP ( ORDER BY avg_value ) FROM sensor_measurements_history
     WHERE sensor_id = $2 AND now() - measured_at < $3
     `
 
@@ -154,3 +248,169 @@ func (m *SensorMeasurementModel) GetPercentile(id uuid.UUID, delta time.Duration
 
 	return result, nil
 }
+
+// rolling up can move a lot of rows, so it gets more time than regular queries
+const rollupTimeout = 5 * time.Minute
+
+// RollupRaw moves raw measurements older than retention of their sensor into 1 minute aggregates.
+// Only complete minutes are rolled up, returns number of moved measurements.
+// Text values (of enum and text sensors) can not be aggregated, they are only removed
+func (m *SensorMeasurementModel) RollupRaw() (int64, error) {
+	query := `
+    WITH moved AS (
+        DELETE FROM sensor_measurements sm
+        USING sensors s
+        WHERE sm.sensor_id = s.id
+        AND s.retention_days > 0
+        AND sm.measured_at < date_trunc('minute', now() - make_interval(days => s.retention_days))
+        RETURNING sm.sensor_id, sm.measured_at, sm.measured_value, sm.text_value
+    ), inserted AS (
+        INSERT INTO sensor_measurements_1m AS agg (sensor_id, bucket, min_value, max_value, avg_value, count)
+        SELECT sensor_id, date_trunc('minute', measured_at), min(measured_value), max(measured_value), avg(measured_value), count(*)
+        FROM moved
+        WHERE text_value IS NULL
+        GROUP BY 1, 2
+        ON CONFLICT (sensor_id, bucket) DO UPDATE
+        SET min_value = least(agg.min_value, excluded.min_value),
+            max_value = greatest(agg.max_value, excluded.max_value),
+            avg_value = (agg.avg_value * agg.count + excluded.avg_value * excluded.count) / (agg.count + excluded.count),
+            count = agg.count + excluded.count
+    )
+    SELECT count(*) FROM moved
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), rollupTimeout)
+	defer cancel()
+
+	var moved int64
+	err := m.DB.QueryRow(ctx, query).Scan(&moved)
+
+	return moved, err
+}
+
+// RollupMinutes moves 1 minute aggregates older than retention into 1 hour aggregates.
+// Only complete hours are rolled up, returns number of moved aggregates
+func (m *SensorMeasurementModel) RollupMinutes(retention time.Duration) (int64, error) {
+	query := `
+    WITH moved AS (
+        DELETE FROM sensor_measurements_1m
+        WHERE bucket < date_trunc('hour', now() - $1::interval)
+        RETURNING sensor_id, bucket, min_value, max_value, avg_value, count
+    ), inserted AS (
+        INSERT INTO sensor_measurements_1h AS agg (sensor_id, bucket, min_value, max_value, avg_value, count)
+        SELECT sensor_id, date_trunc('hour', bucket), min(min_value), max(max_value), sum(avg_value * count) / sum(count), sum(count)
+        FROM moved
+        GROUP BY 1, 2
+        ON CONFLICT (sensor_id, bucket) DO UPDATE
+        SET min_value = least(agg.min_value, excluded.min_value),
+            max_value = greatest(agg.max_value, excluded.max_value),
+            avg_value = (agg.avg_value * agg.count + excluded.avg_value * excluded.count) / (agg.count + excluded.count),
+            count = agg.count + excluded.count
+    )
+    SELECT count(*) FROM moved
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), rollupTimeout)
+	defer cancel()
+
+	var moved int64
+	err := m.DB.QueryRow(ctx, query, retention).Scan(&moved)
+
+	return moved, err
+}
+
+type MeasurementAggregation string
+
+const (
+	AggregationAvg   MeasurementAggregation = "avg"
+	AggregationMin   MeasurementAggregation = "min"
+	AggregationMax   MeasurementAggregation = "max"
+	AggregationSum   MeasurementAggregation = "sum"
+	AggregationCount MeasurementAggregation = "count"
+	AggregationLast  MeasurementAggregation = "last"
+)
+
+var MeasurementAggregations = []MeasurementAggregation{
+	AggregationAvg,
+	AggregationMin,
+	AggregationMax,
+	AggregationSum,
+	AggregationCount,
+	AggregationLast,
+}
+
+// aggregates of measurements taken in [Time, Time + bucket size)
+type MeasurementBucket struct {
+	Time  time.Time
+	Avg   float64
+	Min   float64
+	Max   float64
+	Sum   float64
+	Count int64
+	Last  float64
+}
+
+func (b *MeasurementBucket) Value(agg MeasurementAggregation) any {
+	switch agg {
+	case AggregationAvg:
+		return b.Avg
+	case AggregationMin:
+		return b.Min
+	case AggregationMax:
+		return b.Max
+	case AggregationSum:
+		return b.Sum
+	case AggregationCount:
+		return b.Count
+	case AggregationLast:
+		return b.Last
+	}
+
+	return nil
+}
+
+// GetBuckets returns aggregates of measurements in [from, to) grouped into buckets of given size,
+// aligned to from and ordered by time. Buckets without measurements are left out
+func (m *SensorMeasurementModel) GetBuckets(id uuid.UUID, from, to time.Time, bucket time.Duration) ([]*MeasurementBucket, error) {
+	query := `
+    SELECT date_bin($2, measured_at, $3) AS bucket,
+        sum(avg_value * count) / sum(count),
+        min(min_value),
+        max(max_value),
+        sum(avg_value * count),
+        sum(count),
+        (array_agg(avg_value ORDER BY measured_at DESC))[1]
+    FROM sensor_measurements_history
+    WHERE sensor_id = $1 AND measured_at >= $3 AND measured_at < $4
+    GROUP BY 1
+    ORDER BY 1
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, id, bucket, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := []*MeasurementBucket{}
+
+	for rows.Next() {
+		var b MeasurementBucket
+
+		err := rows.Scan(&b.Time, &b.Avg, &b.Min, &b.Max, &b.Sum, &b.Count, &b.Last)
+		if err != nil {
+			return nil, err
+		}
+
+		buckets = append(buckets, &b)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return buckets, nil
+}
@@ -15,6 +15,7 @@ import (
 )
 
 type SensorType string
+type SensorTransport string
 type SensorInitBuffer map[uuid.UUID]Sensor
 
 var (
@@ -27,6 +28,11 @@ const (
 	DecimalSwitch SensorType = "decimal_switch"
 	DecimalSensor SensorType = "decimal_sensor"
 	Button        SensorType = "button"
+	IntegerSensor SensorType = "integer_sensor"
+	// enum sensors report one of their options, enum switches can also be set to one of them
+	EnumSensor SensorType = "enum_sensor"
+	EnumSwitch SensorType = "enum_switch"
+	TextSensor SensorType = "text_sensor"
 )
 
 var SensorTypes = []SensorType{
@@ -35,10 +41,37 @@ var SensorTypes = []SensorType{
 	DecimalSwitch,
 	DecimalSensor,
 	Button,
+	IntegerSensor,
+	EnumSensor,
+	EnumSwitch,
+	TextSensor,
+}
+
+const (
+	// sensor is polled (or pushes values) over its own http api, uri is its address
+	TransportHTTP SensorTransport = "http"
+	// values are pushed in InfluxDB line protocol, uri is the series key (see LineProtocolPoint.SeriesKey)
+	TransportLineProtocol SensorTransport = "line_protocol"
+	// values are exchanged through mqtt broker, uri is the state topic (see SplitMQTTUri)
+	TransportMQTT SensorTransport = "mqtt"
+	// values are computed from other sensors, uri is the expression (see ParseExpression)
+	TransportVirtual SensorTransport = "virtual"
+)
+
+var SensorTransports = []SensorTransport{
+	TransportHTTP,
+	TransportLineProtocol,
+	TransportMQTT,
+	TransportVirtual,
+}
+
+// only switches accept values written by the server
+func (t SensorType) IsWritable() bool {
+	return t == BinarySwitch || t == DecimalSwitch || t == EnumSwitch
 }
 
 type SensorReturn interface {
-	int | float64 | bool
+	int | float64 | bool | SensorValue
 }
 
 type Sensor struct {
@@ -52,6 +85,31 @@ type Sensor struct {
 	Version     int        `json:"version"`
 	Active      bool       `json:"active"`
 	IdToken     uuid.UUID  `json:"idToken"`
+	// raw measurements older than this are rolled up into aggregates, 0 keeps them forever
+	RetentionDays int             `json:"retention_days"`
+	Transport     SensorTransport `json:"transport"`
+	MQTT          *MQTTOptions    `json:"mqtt,omitempty"`
+	// nil when anomaly detection is disabled
+	Anomaly *AnomalyOptions `json:"anomaly,omitempty"`
+	// applied in order to every value received from the sensor
+	Transforms []Transform `json:"transforms,omitempty"`
+	// stores value from before transforms alongside the measurement
+	StoreRaw bool `json:"store_raw"`
+	// symbol of one of Units, empty for unitless values
+	Unit string `json:"unit"`
+	// number of decimal places values are displayed with
+	Decimals *int `json:"decimals,omitempty"`
+	// expected range of values, values written to switches must be within it
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+	// values of enum sensors, stored measurement keeps index of the option alongside it
+	Options []string `json:"options,omitempty"`
+	Icon    string   `json:"icon"`
+	// nil when the sensor is not assigned to any area
+	AreaID *uuid.UUID `json:"area_id"`
+	Tags   []string   `json:"tags"`
+	// filled in by the api, not stored
+	Health *SensorHealth `json:"health,omitempty"`
 }
 
 func ValidateSensor(v *validator.Validator, sensor *Sensor) {
@@ -59,7 +117,23 @@ func ValidateSensor(v *validator.Validator, sensor *Sensor) {
 	v.Check(len(sensor.Name) <= 255, "name", "must not be more than 255 bytes long")
 
 	v.Check(sensor.URI != "", "uri", "must be provided")
-	v.Check(validator.Matches(sensor.URI, validator.UriRX), "uri", "must be valid uri")
+
+	v.Check(validator.PermittedValue(sensor.Transport, SensorTransports...), "transport", "must be known")
+
+	switch sensor.Transport {
+	case TransportHTTP:
+		v.Check(validator.Matches(sensor.URI, validator.UriRX), "uri", "must be valid uri")
+	case TransportLineProtocol:
+		_, err := ParseSeriesKey(sensor.URI)
+		v.Check(err == nil, "uri", "must be valid line protocol series key")
+		// values are only pushed by the devices, there is nothing to poll or write to
+		v.Check(sensor.Active, "active", "must be true for line protocol sensors")
+		v.Check(!sensor.Type.IsWritable(), "type", "must not be a switch for line protocol sensors")
+	case TransportMQTT:
+		validateMQTTSensor(v, sensor)
+	case TransportVirtual:
+		validateVirtualSensor(v, sensor)
+	}
 
 	v.Check(sensor.Type != "", "type", "must be provided")
 	v.Check(validator.PermittedValue(sensor.Type, SensorTypes...), "type", "must be known")
@@ -71,16 +145,77 @@ func ValidateSensor(v *validator.Validator, sensor *Sensor) {
 		v.Check(sensor.RefreshRate != 0, "refresh_rate", "must be provided")
 		v.Check(sensor.RefreshRate > 0, "refresh_rate", "must be a positive integer")
 	}
+
+	v.Check(sensor.RetentionDays >= 0, "retention_days", "must not be negative")
+	v.Check(sensor.RetentionDays <= 3650, "retention_days", "must not be more than 3650")
+
+	if sensor.Anomaly != nil {
+		validateAnomalyOptions(v, sensor)
+	}
+
+	validateTransforms(v, sensor.Transforms)
+
+	if sensor.Unit != "" {
+		_, ok := LookupUnit(sensor.Unit)
+		v.Check(ok, "unit", "must be known unit")
+	}
+
+	if sensor.Decimals != nil {
+		v.Check(*sensor.Decimals >= 0, "decimals", "must not be negative")
+		v.Check(*sensor.Decimals <= 10, "decimals", "must not be more than 10")
+	}
+
+	if sensor.Min != nil && sensor.Max != nil {
+		v.Check(*sensor.Min <= *sensor.Max, "min", "must not be greater than max")
+	}
+
+	validateValueKind(v, sensor)
+
+	v.Check(len(sensor.Icon) <= 64, "icon", "must not be more than 64 bytes long")
+
+	validateTags(v, sensor.Tags)
 }
 
 type SensorModel struct {
 	DB *pgxpool.Pool
 }
 
+// columns read by scanSensor, in order
+const sensorColumns = "id, name, uri, sensor_type, hidden, refresh_rate, created_at, version, active, id_token, retention_days, transport, mqtt_options, anomaly_options, transforms, store_raw, unit, decimals, min_value, max_value, options, icon, area_id, tags"
+
+func scanSensor(row pgx.Row, sensor *Sensor) error {
+	return row.Scan(
+		&sensor.ID,
+		&sensor.Name,
+		&sensor.URI,
+		&sensor.Type,
+		&sensor.Hidden,
+		&sensor.RefreshRate,
+		&sensor.CreatedAt,
+		&sensor.Version,
+		&sensor.Active,
+		&sensor.IdToken,
+		&sensor.RetentionDays,
+		&sensor.Transport,
+		&sensor.MQTT,
+		&sensor.Anomaly,
+		&sensor.Transforms,
+		&sensor.StoreRaw,
+		&sensor.Unit,
+		&sensor.Decimals,
+		&sensor.Min,
+		&sensor.Max,
+		&sensor.Options,
+		&sensor.Icon,
+		&sensor.AreaID,
+		&sensor.Tags,
+	)
+}
+
 func (m SensorModel) Insert(sensor *Sensor) error {
 	query := `
-    INSERT INTO sensors (id, name, uri, sensor_type, hidden, refresh_rate, active, id_token)
-    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+    INSERT INTO sensors (id, name, uri, sensor_type, hidden, refresh_rate, active, id_token, retention_days, transport, mqtt_options, anomaly_options, transforms, store_raw, unit, decimals, min_value, max_value, options, icon, area_id, tags)
+    VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)
     RETURNING created_at, version
     `
 
@@ -90,8 +225,10 @@ func (m SensorModel) Insert(sensor *Sensor) error {
 	}
 
 	sensor.ID = uuid
+	sensor.Tags = nonNilTags(sensor.Tags)
+	sensor.Options = nonNilTags(sensor.Options)
 
-	args := []any{sensor.ID, sensor.Name, sensor.URI, sensor.Type, sensor.Hidden, sensor.RefreshRate, sensor.Active, sensor.IdToken}
+	args := []any{sensor.ID, sensor.Name, sensor.URI, sensor.Type, sensor.Hidden, sensor.RefreshRate, sensor.Active, sensor.IdToken, sensor.RetentionDays, sensor.Transport, sensor.MQTT, sensor.Anomaly, sensor.Transforms, sensor.StoreRaw, sensor.Unit, sensor.Decimals, sensor.Min, sensor.Max, sensor.Options, sensor.Icon, sensor.AreaID, sensor.Tags}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -113,7 +250,7 @@ func (m SensorModel) Insert(sensor *Sensor) error {
 
 func (m SensorModel) Get(id uuid.UUID) (*Sensor, error) {
 	query := `
-    SELECT id, name, uri, sensor_type, hidden, refresh_rate, created_at, version, active, id_token
+    SELECT ` + sensorColumns + `
     FROM sensors
     WHERE id = $1
     `
@@ -122,18 +259,7 @@ func (m SensorModel) Get(id uuid.UUID) (*Sensor, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRow(ctx, query, id).Scan(
-		&sensor.ID,
-		&sensor.Name,
-		&sensor.URI,
-		&sensor.Type,
-		&sensor.Hidden,
-		&sensor.RefreshRate,
-		&sensor.CreatedAt,
-		&sensor.Version,
-		&sensor.Active,
-		&sensor.IdToken,
-	)
+	err := scanSensor(m.DB.QueryRow(ctx, query, id), &sensor)
 
 	if err != nil {
 		switch {
@@ -148,43 +274,121 @@ func (m SensorModel) Get(id uuid.UUID) (*Sensor, error) {
 }
 
 type SensorSimple struct {
-	ID     uuid.UUID  `json:"id"`
-	Name   string     `json:"name"`
-	Type   SensorType `json:"type"`
-	Hidden bool       `json:"hidden"`
-	Active bool       `json:"active"`
+	ID     uuid.UUID     `json:"id"`
+	Name   string        `json:"name"`
+	Type   SensorType    `json:"type"`
+	Hidden bool          `json:"hidden"`
+	Active bool          `json:"active"`
+	AreaID *uuid.UUID    `json:"area_id"`
+	Tags   []string      `json:"tags"`
+	Health *SensorHealth `json:"health,omitempty"`
 }
 
-func (m SensorModel) GetAllInfo() ([]*SensorSimple, error) {
-	// TODO: add filtering and pagination
+// SensorFilter selects sensors by group and properties, empty fields match every sensor
+type SensorFilter struct {
+	GroupFilter
+	Types  []SensorType
+	Hidden *bool
+	Active *bool
+}
+
+func (f SensorFilter) apply(q *listQuery) {
+	f.GroupFilter.apply(q)
+
+	if len(f.Types) > 0 {
+		types := make([]string, len(f.Types))
+		for i, t := range f.Types {
+			types[i] = string(t)
+		}
+		q.where("sensor_type = ANY(" + q.arg(types) + ")")
+	}
+
+	if f.Hidden != nil {
+		q.where("hidden = " + q.arg(*f.Hidden))
+	}
+
+	if f.Active != nil {
+		q.where("active = " + q.arg(*f.Active))
+	}
+}
+
+func (m SensorModel) GetAllInfo(filter SensorFilter, filters Filters) ([]*SensorSimple, Metadata, error) {
+	q := &listQuery{}
+	filter.apply(q)
+	filters.search(q, "name")
+	pagination := filters.pagination(q)
+
+	// type is selected under its json name, so it can be sorted by
 	query := `
-    SELECT id, name, sensor_type, hidden, active
+    SELECT count(*) OVER(), id, name, sensor_type AS type, hidden, active, area_id, tags
     FROM sensors
-    ORDER BY id
-    `
+    ` + q.whereClause() + `
+    ` + filters.orderBy() + `
+    ` + pagination
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.Query(ctx, query)
+	rows, err := m.DB.Query(ctx, query, q.args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 	defer rows.Close()
 
+	totalRecords := 0
 	sensors := []*SensorSimple{}
 
 	for rows.Next() {
 		var sensor SensorSimple
 
 		err := rows.Scan(
+			&totalRecords,
 			&sensor.ID,
 			&sensor.Name,
 			&sensor.Type,
 			&sensor.Hidden,
 			&sensor.Active,
+			&sensor.AreaID,
+			&sensor.Tags,
 		)
 
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		sensors = append(sensors, &sensor)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return sensors, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+func (m SensorModel) GetAll() ([]*Sensor, error) {
+	query := `
+    SELECT ` + sensorColumns + `
+    FROM sensors
+    ORDER BY id
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sensors := []*Sensor{}
+
+	for rows.Next() {
+		var sensor Sensor
+
+		err := scanSensor(rows, &sensor)
+
 		if err != nil {
 			return nil, err
 		}
@@ -199,17 +403,22 @@ func (m SensorModel) GetAllInfo() ([]*SensorSimple, error) {
 	return sensors, nil
 }
 
-func (m SensorModel) GetAll() ([]*Sensor, error) {
+// returns sensors matching the filter
+func (m SensorModel) GetByGroup(filter GroupFilter) ([]*Sensor, error) {
+	q := &listQuery{}
+	filter.apply(q)
+
 	query := `
-    SELECT id, name, uri, sensor_type, hidden, refresh_rate, created_at, version, active, id_token
+    SELECT ` + sensorColumns + `
     FROM sensors
+    ` + q.whereClause() + `
     ORDER BY id
     `
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.Query(ctx, query)
+	rows, err := m.DB.Query(ctx, query, q.args...)
 	if err != nil {
 		return nil, err
 	}
@@ -220,18 +429,45 @@ func (m SensorModel) GetAll() ([]*Sensor, error) {
 	for rows.Next() {
 		var sensor Sensor
 
-		err := rows.Scan(
-			&sensor.ID,
-			&sensor.Name,
-			&sensor.URI,
-			&sensor.Type,
-			&sensor.Hidden,
-			&sensor.RefreshRate,
-			&sensor.CreatedAt,
-			&sensor.Version,
-			&sensor.Active,
-			&sensor.IdToken,
-		)
+		err := scanSensor(rows, &sensor)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sensors = append(sensors, &sensor)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sensors, nil
+}
+
+// returns sensors of given transport with any of given uris
+func (m SensorModel) GetByURIs(transport SensorTransport, uris []string) ([]*Sensor, error) {
+	query := `
+    SELECT ` + sensorColumns + `
+    FROM sensors
+    WHERE transport = $1 AND uri = ANY($2)
+    `
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, transport, uris)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sensors := []*Sensor{}
+
+	for rows.Next() {
+		var sensor Sensor
+
+		err := scanSensor(rows, &sensor)
 
 		if err != nil {
 			return nil, err
@@ -274,11 +510,14 @@ func (m SensorModel) GetUri(id uuid.UUID) (string, error) {
 func (m SensorModel) Update(sensor *Sensor) error {
 	query := `
     UPDATE sensors
-    SET name = $1, uri = $2, sensor_type = $3, hidden = $4, refresh_rate = $5, active = $6, id_token = $7, version = version + 1
-    WHERE id = $8
+    SET name = $1, uri = $2, sensor_type = $3, hidden = $4, refresh_rate = $5, active = $6, id_token = $7, retention_days = $8, transport = $9, mqtt_options = $10, anomaly_options = $11, transforms = $12, store_raw = $13, unit = $14, decimals = $15, min_value = $16, max_value = $17, options = $18, icon = $19, area_id = $20, tags = $21, version = version + 1
+    WHERE id = $22 AND version = $23
     RETURNING version
     `
 
+	sensor.Tags = nonNilTags(sensor.Tags)
+	sensor.Options = nonNilTags(sensor.Options)
+
 	args := []any{
 		sensor.Name,
 		sensor.URI,
@@ -287,7 +526,22 @@ func (m SensorModel) Update(sensor *Sensor) error {
 		sensor.RefreshRate,
 		sensor.Active,
 		sensor.IdToken,
+		sensor.RetentionDays,
+		sensor.Transport,
+		sensor.MQTT,
+		sensor.Anomaly,
+		sensor.Transforms,
+		sensor.StoreRaw,
+		sensor.Unit,
+		sensor.Decimals,
+		sensor.Min,
+		sensor.Max,
+		sensor.Options,
+		sensor.Icon,
+		sensor.AreaID,
+		sensor.Tags,
 		sensor.ID,
+		sensor.Version,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -297,6 +551,8 @@ func (m SensorModel) Update(sensor *Sensor) error {
 
 	if err != nil {
 		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
 		case strings.HasPrefix(err.Error(), "ERROR: duplicate key value violates unique constraint \"uri_unique\""):
 			return ErrDuplicateUri
 		default:
@@ -340,7 +596,7 @@ func (m SensorModel) DeleteSensorAndMeasurements(id uuid.UUID) error {
 
 func (m SensorModel) GetByIdToken(idToken uuid.UUID) (*Sensor, error) {
 	query := `
-    SELECT id, name, uri, sensor_type, hidden, refresh_rate, created_at, version, active, id_token
+    SELECT ` + sensorColumns + `
 	FROM sensors
 	WHERE id_token = $1;
 	`
@@ -348,18 +604,7 @@ func (m SensorModel) GetByIdToken(idToken uuid.UUID) (*Sensor, error) {
 	defer cancel()
 
 	var sensor Sensor
-	err := m.DB.QueryRow(ctx, query, idToken).Scan(
-		&sensor.ID,
-		&sensor.Name,
-		&sensor.URI,
-		&sensor.Type,
-		&sensor.Hidden,
-		&sensor.RefreshRate,
-		&sensor.CreatedAt,
-		&sensor.Version,
-		&sensor.Active,
-		&sensor.IdToken,
-	)
+	err := scanSensor(m.DB.QueryRow(ctx, query, idToken), &sensor)
 
 	if err == sql.ErrNoRows {
 		return &sensor, fmt.Errorf("no sensor found with id token %s", idToken)
@@ -0,0 +1,149 @@
+package data
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type SequenceRunStatus string
+
+const (
+	SequenceRunRunning   SequenceRunStatus = "running"
+	SequenceRunCompleted SequenceRunStatus = "completed"
+	SequenceRunFailed    SequenceRunStatus = "failed"
+)
+
+type SequenceStepStatus string
+
+const (
+	SequenceStepSucceeded SequenceStepStatus = "succeeded"
+	SequenceStepSkipped   SequenceStepStatus = "skipped"
+	SequenceStepFailed    SequenceStepStatus = "failed"
+)
+
+type SequenceStepResult struct {
+	// index of the step in its block
+	Index int `json:"index"`
+	// location of the step in nested blocks, e.g. "2.then.0"
+	Path string             `json:"path"`
+	Type SequenceActionType `json:"type"`
+	// target sensor, or called sequence for call steps
+	Target   uuid.UUID          `json:"target"`
+	Attempts int                `json:"attempts"`
+	Status   SequenceStepStatus `json:"status"`
+	Error    string             `json:"error,omitempty"`
+	// branch taken by if steps
+	Branch string `json:"branch,omitempty"`
+	// iterations done by repeat steps
+	Iterations int `json:"iterations,omitempty"`
+}
+
+type SequenceRun struct {
+	ID         uuid.UUID            `json:"id"`
+	SequenceID uuid.UUID            `json:"sequence_id"`
+	Status     SequenceRunStatus    `json:"status"`
+	Steps      []SequenceStepResult `json:"steps"`
+	Error      *string              `json:"error"`
+	StartedAt  time.Time            `json:"started_at"`
+	FinishedAt *time.Time           `json:"finished_at"`
+}
+
+type SequenceRunModel struct {
+	DB *pgxpool.Pool
+}
+
+func (m SequenceRunModel) Insert(run *SequenceRun) error {
+	query := `INSERT INTO sequence_runs (id, sequence_id, status, steps)
+	VALUES ($1, $2, $3, $4)
+	RETURNING started_at`
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return err
+	}
+
+	run.ID = id
+	run.Status = SequenceRunRunning
+	if run.Steps == nil {
+		run.Steps = []SequenceStepResult{}
+	}
+
+	args := []any{run.ID, run.SequenceID, run.Status, run.Steps}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRow(ctx, query, args...).Scan(&run.StartedAt)
+}
+
+// Finish stores the final status, step results and error of the run
+func (m SequenceRunModel) Finish(run *SequenceRun) error {
+	query := `UPDATE sequence_runs
+	SET status = $2, steps = $3, error = $4, finished_at = now()
+	WHERE id = $1
+	RETURNING finished_at`
+
+	args := []any{run.ID, run.Status, run.Steps, run.Error}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRow(ctx, query, args...).Scan(&run.FinishedAt)
+}
+
+// GetForSequence returns page of runs of the sequence, optionally only ones with given status
+func (m SequenceRunModel) GetForSequence(sequenceId uuid.UUID, status SequenceRunStatus, filters Filters) ([]*SequenceRun, Metadata, error) {
+	q := &listQuery{}
+	q.where("sequence_id = " + q.arg(sequenceId))
+	if status != "" {
+		q.where("status = " + q.arg(status))
+	}
+	pagination := filters.pagination(q)
+
+	query := `SELECT count(*) OVER(), id, sequence_id, status, steps, error, started_at, finished_at
+	FROM sequence_runs
+	` + q.whereClause() + `
+	` + filters.orderBy() + `
+	` + pagination
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.Query(ctx, query, q.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	runs := []*SequenceRun{}
+
+	for rows.Next() {
+		var run SequenceRun
+
+		err := rows.Scan(
+			&totalRecords,
+			&run.ID,
+			&run.SequenceID,
+			&run.Status,
+			&run.Steps,
+			&run.Error,
+			&run.StartedAt,
+			&run.FinishedAt,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		runs = append(runs, &run)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return runs, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
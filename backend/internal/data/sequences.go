@@ -2,9 +2,15 @@ package data
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"inzynierka/internal/data/validator"
+	"math"
+	"slices"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -20,25 +26,391 @@ type Sequence struct {
 	Name        string           `json:"name"`
 	Description string           `json:"description"`
 	Actions     []SequenceAction `json:"actions"`
+	AreaID      *uuid.UUID       `json:"area_id"`
+	Tags        []string         `json:"tags"`
 	CreatedAt   time.Time        `json:"created_at"`
 	Version     int              `json:"version"`
 }
 
+type SequenceFailurePolicy string
+
+const (
+	// stop the sequence and mark the run as failed
+	FailureAbort SequenceFailurePolicy = "abort"
+	// drop the failed step and carry on, the run can still complete
+	FailureSkip SequenceFailurePolicy = "skip"
+	// carry on with the remaining steps, but mark the run as failed
+	FailureContinue SequenceFailurePolicy = "continue"
+)
+
+func (p SequenceFailurePolicy) IsValid() bool {
+	return p == "" || p == FailureAbort || p == FailureSkip || p == FailureContinue
+}
+
+// value written by set steps, a number, a boolean or a text (option of enum switch)
+type SequenceValue struct {
+	IsBool bool
+	Bool   bool
+	Number float64
+	IsText bool
+	Text   string
+}
+
+func NumberValue(value float64) SequenceValue {
+	return SequenceValue{Number: value}
+}
+
+func BoolValue(value bool) SequenceValue {
+	return SequenceValue{IsBool: true, Bool: value}
+}
+
+func TextValue(value string) SequenceValue {
+	return SequenceValue{IsText: true, Text: value}
+}
+
+// value as sent to the sensor, booleans are sent as 0 or 1 and texts as 0
+func (v SequenceValue) Float() float64 {
+	if !v.IsBool {
+		return v.Number
+	}
+
+	if v.Bool {
+		return 1
+	}
+	return 0
+}
+
+// SensorValue returns value to be coerced to the kind of the target sensor
+func (v SequenceValue) SensorValue() SensorValue {
+	switch {
+	case v.IsText:
+		return StringValue(v.Text)
+	case v.IsBool:
+		return BooleanValue(v.Bool)
+	default:
+		return DecimalValue(v.Number)
+	}
+}
+
+func (v SequenceValue) MarshalJSON() ([]byte, error) {
+	if v.IsText {
+		return json.Marshal(v.Text)
+	}
+	if v.IsBool {
+		return json.Marshal(v.Bool)
+	}
+	return json.Marshal(v.Number)
+}
+
+func (v *SequenceValue) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+
+	var b bool
+	if err := json.Unmarshal(data, &b); err == nil {
+		*v = BoolValue(b)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*v = TextValue(s)
+		return nil
+	}
+
+	var n float64
+	if err := json.Unmarshal(data, &n); err != nil {
+		return ErrInvalidSequenceValue
+	}
+
+	*v = NumberValue(n)
+	return nil
+}
+
+type SequenceActionType string
+
+const (
+	// writes value to the target sensor, default when type is missing
+	ActionSet SequenceActionType = "set"
+	// waits until condition holds or timeout passes
+	ActionWaitUntil SequenceActionType = "wait_until"
+	// runs then or else branch depending on condition
+	ActionIf SequenceActionType = "if"
+	// runs body given number of times or until condition holds
+	ActionRepeat SequenceActionType = "repeat"
+	// runs actions of another sequence
+	ActionCall SequenceActionType = "call"
+	// runs every branch at the same time
+	ActionParallel SequenceActionType = "parallel"
+	// waits for all parallel branches started without waiting
+	ActionJoin SequenceActionType = "join"
+	// writes all values of a scene
+	ActionScene SequenceActionType = "scene"
+)
+
+// upper bound of iterations for repeat steps without times limit
+const MaxRepeatIterations = 1000
+
+const (
+	MaxSequenceRetries = 10
+	// backoff doubled on every retry is capped at MaxMsBackoff anyway
+	MaxMsBackoff = 60_000
+)
+
+var (
+	ErrSequenceRecursion    = errors.New("sequence calls form a cycle")
+	ErrInvalidSequenceValue = errors.New("value must be a number, a boolean or a string")
+)
+
 type SequenceAction struct {
-	Target  uuid.UUID `json:"target"`
-	Value   float32   `json:"value"`
-	MsDelay int       `json:"msDelay"`
+	Type    SequenceActionType `json:"type,omitempty"`
+	Target  uuid.UUID          `json:"target"`
+	Value   SequenceValue      `json:"value"`
+	MsDelay int                `json:"msDelay"`
+	// number of additional attempts after the first one failed
+	Retries int `json:"retries"`
+	// delay before the first retry, doubled on every next one up to MaxMsBackoff
+	MsBackoff int                   `json:"msBackoff"`
+	OnFailure SequenceFailurePolicy `json:"onFailure"`
+
+	// wait_until and if
+	Condition RuleInternal     `json:"condition,omitempty"`
+	MsTimeout int              `json:"msTimeout,omitempty"`
+	Then      []SequenceAction `json:"then,omitempty"`
+	Else      []SequenceAction `json:"else,omitempty"`
+
+	// repeat
+	Times int              `json:"times,omitempty"`
+	Until RuleInternal     `json:"until,omitempty"`
+	Body  []SequenceAction `json:"body,omitempty"`
+
+	// call
+	Sequence uuid.UUID `json:"sequence,omitempty"`
+
+	// scene
+	Scene uuid.UUID `json:"scene,omitempty"`
+
+	// parallel, each branch is a list of actions run sequentially
+	Branches [][]SequenceAction `json:"branches,omitempty"`
+	// whether parallel step waits for all of its branches, defaults to true.
+	// Branches not awaited are joined by the next join step, otherwise at the end of the branch
+	// running the parallel step or at the end of the sequence. If and repeat blocks do not wait for them
+	Await *bool `json:"await,omitempty"`
+}
+
+func (a *SequenceAction) Kind() SequenceActionType {
+	if a.Type == "" {
+		return ActionSet
+	}
+	return a.Type
+}
+
+func (a *SequenceAction) Wait() bool {
+	return a.Await == nil || *a.Await
+}
+
+func (a *SequenceAction) UnmarshalJSON(data []byte) error {
+	type fakeAction SequenceAction
+
+	tmp := struct {
+		*fakeAction
+		Condition map[string]interface{} `json:"condition"`
+		Until     map[string]interface{} `json:"until"`
+	}{
+		fakeAction: (*fakeAction)(a),
+	}
+
+	err := json.Unmarshal(data, &tmp)
+	if err != nil {
+		return err
+	}
+
+	if tmp.Condition != nil {
+		a.Condition, err = UnmarshalInternalRuleJSON(tmp.Condition)
+		if err != nil {
+			return err
+		}
+	}
+
+	if tmp.Until != nil {
+		a.Until, err = UnmarshalInternalRuleJSON(tmp.Until)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// calls fn for every action, including ones nested in other actions
+func walkSequenceActions(actions []SequenceAction, fn func(action SequenceAction)) {
+	for _, action := range actions {
+		fn(action)
+
+		switch action.Kind() {
+		case ActionIf:
+			walkSequenceActions(action.Then, fn)
+			walkSequenceActions(action.Else, fn)
+		case ActionRepeat:
+			walkSequenceActions(action.Body, fn)
+		case ActionParallel:
+			for _, branch := range action.Branches {
+				walkSequenceActions(branch, fn)
+			}
+		}
+	}
+}
+
+// returns ids of all sequences called from given actions, including nested ones
+func SequenceCalls(actions []SequenceAction) []uuid.UUID {
+	res := make([]uuid.UUID, 0)
+
+	walkSequenceActions(actions, func(action SequenceAction) {
+		if action.Kind() == ActionCall && !slices.Contains(res, action.Sequence) {
+			res = append(res, action.Sequence)
+		}
+	})
+
+	return res
+}
+
+// returns ids of all scenes activated by given actions, including nested ones
+func SequenceScenes(actions []SequenceAction) []uuid.UUID {
+	res := make([]uuid.UUID, 0)
+
+	walkSequenceActions(actions, func(action SequenceAction) {
+		if action.Kind() == ActionScene && !slices.Contains(res, action.Scene) {
+			res = append(res, action.Scene)
+		}
+	})
+
+	return res
+}
+
+func ValidateSequence(v *validator.Validator, sequence *Sequence, sensors map[uuid.UUID]*Sensor) {
+	v.Check(utf8.RuneCountInString(sequence.Name) > 0, "name", "must not be empty")
+	v.Check(utf8.RuneCountInString(sequence.Name) <= 32, "name", "must not be longer than 32 characters")
+	v.Check(utf8.RuneCountInString(sequence.Description) <= 256, "description", "must not be longer than 256 characters")
+
+	validateTags(v, sequence.Tags)
+
+	ValidateSequenceActions(v, sequence.Actions, "actions", sensors)
+}
+
+// validates actions (including nested blocks), targets of set steps are looked up in sensors
+func ValidateSequenceActions(v *validator.Validator, actions []SequenceAction, key string, sensors map[uuid.UUID]*Sensor) {
+	for i, action := range actions {
+		field := fmt.Sprintf("%s[%d]", key, i)
+
+		v.Check(action.MsDelay >= 0, field+".msDelay", "must not be negative")
+		v.Check(action.OnFailure.IsValid(), field+".onFailure", "must be either 'abort', 'skip' or 'continue'")
+		v.Check(action.Retries >= 0, field+".retries", "must not be negative")
+		v.Check(action.Retries <= MaxSequenceRetries, field+".retries", fmt.Sprintf("must not be more than %d", MaxSequenceRetries))
+		v.Check(action.MsBackoff >= 0, field+".msBackoff", "must not be negative")
+		v.Check(action.MsBackoff <= MaxMsBackoff, field+".msBackoff", fmt.Sprintf("must not be more than %d", MaxMsBackoff))
+
+		switch action.Kind() {
+		case ActionSet:
+			if action.Target == uuid.Nil {
+				v.AddError(field+".target", "must be provided")
+				continue
+			}
+
+			sensor, ok := sensors[action.Target]
+			if !ok {
+				v.AddError(field+".target", "must reference existing sensor")
+				continue
+			}
+
+			if !sensor.Type.IsWritable() {
+				v.AddError(field+".target", "must reference binary, decimal or enum switch")
+				continue
+			}
+
+			ValidateSensorValue(v, field+".value", sensor, action.Value)
+		case ActionWaitUntil:
+			validateActionCondition(v, action.Condition, field+".condition")
+			v.Check(action.MsTimeout > 0, field+".msTimeout", "must be a positive integer")
+		case ActionIf:
+			validateActionCondition(v, action.Condition, field+".condition")
+			ValidateSequenceActions(v, action.Then, field+".then", sensors)
+			ValidateSequenceActions(v, action.Else, field+".else", sensors)
+		case ActionRepeat:
+			v.Check(action.Times >= 0, field+".times", "must not be negative")
+			v.Check(action.Times <= MaxRepeatIterations, field+".times", fmt.Sprintf("must not be more than %d", MaxRepeatIterations))
+			v.Check(action.Times > 0 || action.Until != nil, field+".times", "must be provided when until is missing")
+			v.Check(len(action.Body) > 0, field+".body", "must have at least one action")
+			if action.Until != nil {
+				validateActionCondition(v, action.Until, field+".until")
+			}
+			ValidateSequenceActions(v, action.Body, field+".body", sensors)
+		case ActionCall:
+			v.Check(action.Sequence != uuid.Nil, field+".sequence", "must be provided")
+		case ActionParallel:
+			v.Check(len(action.Branches) > 0, field+".branches", "must have at least one branch")
+			for j, branch := range action.Branches {
+				branchField := fmt.Sprintf("%s.branches[%d]", field, j)
+				v.Check(len(branch) > 0, branchField, "must have at least one action")
+				ValidateSequenceActions(v, branch, branchField, sensors)
+			}
+		case ActionScene:
+			v.Check(action.Scene != uuid.Nil, field+".scene", "must be provided")
+		case ActionJoin:
+		default:
+			v.AddError(field+".type", "must be known")
+		}
+	}
+}
+
+// ValidateSensorValue checks if value can be written to the sensor: booleans (or 0/1) for binary switches,
+// finite numbers fitting measurement column and range of the sensor for decimal switches,
+// one of options (or its index) for enum switches
+func ValidateSensorValue(v *validator.Validator, key string, sensor *Sensor, value SequenceValue) {
+	switch sensor.Type {
+	case BinarySwitch:
+		v.Check(!value.IsText && (value.IsBool || value.Number == 0 || value.Number == 1), key, "must be a boolean")
+	case DecimalSwitch:
+		if value.IsBool || value.IsText {
+			v.AddError(key, "must be a number")
+			return
+		}
+		v.Check(!math.IsNaN(value.Number) && !math.IsInf(value.Number, 0), key, "must be a finite number")
+		v.Check(math.Abs(value.Number) <= math.MaxFloat32, key, "must fit in 32 bit floating point number")
+
+		if problem := sensor.rangeProblem(value.Number); problem != "" {
+			v.AddError(key, problem)
+		}
+	case EnumSwitch:
+		_, err := sensor.Coerce(value.SensorValue())
+		v.Check(!value.IsBool && err == nil, key, "must be one of options")
+	}
+}
+
+func validateActionCondition(v *validator.Validator, condition RuleInternal, key string) {
+	if condition == nil {
+		v.AddError(key, "must be provided")
+		return
+	}
+
+	inner := validator.New()
+	condition.Validate(inner)
+	for k, msg := range inner.Errors {
+		v.AddError(key+"."+k, msg)
+	}
 }
 
 type SequenceInfo struct {
-	ID          uuid.UUID `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
+	ID          uuid.UUID  `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	AreaID      *uuid.UUID `json:"area_id"`
+	Tags        []string   `json:"tags"`
 }
 
 func (m SequenceModel) Insert(sequence *Sequence) error {
-	query := `INSERT INTO SEQUENCES (id, name, description, actions)
-	VALUES ($1, $2, $3, $4)
+	query := `INSERT INTO SEQUENCES (id, name, description, actions, area_id, tags)
+	VALUES ($1, $2, $3, $4, $5, $6)
 	RETURNING created_at, version`
 
 	id, err := uuid.NewRandom()
@@ -47,8 +419,9 @@ func (m SequenceModel) Insert(sequence *Sequence) error {
 	}
 
 	sequence.ID = id
+	sequence.Tags = nonNilTags(sequence.Tags)
 
-	args := []any{sequence.ID, sequence.Name, sequence.Description, sequence.Actions}
+	args := []any{sequence.ID, sequence.Name, sequence.Description, sequence.Actions, sequence.AreaID, sequence.Tags}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -67,41 +440,50 @@ func (m SequenceModel) Insert(sequence *Sequence) error {
 	return nil
 }
 
-func (m SequenceModel) GetAllInfo() ([]*SequenceInfo, error) {
-	query := `SELECT id, name, description
-	FROM sequences`
+func (m SequenceModel) GetAllInfo(filter GroupFilter, filters Filters) ([]*SequenceInfo, Metadata, error) {
+	q := &listQuery{}
+	filter.apply(q)
+	filters.search(q, "name")
+	pagination := filters.pagination(q)
+
+	query := `SELECT count(*) OVER(), id, name, description, area_id, tags
+	FROM sequences
+	` + q.whereClause() + `
+	` + filters.orderBy() + `
+	` + pagination
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.Query(ctx, query)
+	rows, err := m.DB.Query(ctx, query, q.args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 	defer rows.Close()
 
-	var allInfo []*SequenceInfo
+	totalRecords := 0
+	allInfo := []*SequenceInfo{}
 
 	for rows.Next() {
 		var info SequenceInfo
 
-		err := rows.Scan(&info.ID, &info.Name, &info.Description)
+		err := rows.Scan(&totalRecords, &info.ID, &info.Name, &info.Description, &info.AreaID, &info.Tags)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
 
 		allInfo = append(allInfo, &info)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 
-	return allInfo, nil
+	return allInfo, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
 }
 
 func (m SequenceModel) Get(id uuid.UUID) (*Sequence, error) {
-	query := `SELECT id, name, description, actions, created_at, version
+	query := `SELECT id, name, description, actions, area_id, tags, created_at, version
 	FROM sequences
 	WHERE id = $1`
 
@@ -116,6 +498,8 @@ func (m SequenceModel) Get(id uuid.UUID) (*Sequence, error) {
 		&sequence.Name,
 		&sequence.Description,
 		&actions,
+		&sequence.AreaID,
+		&sequence.Tags,
 		&sequence.CreatedAt,
 		&sequence.Version,
 	)
@@ -136,11 +520,11 @@ func (m SequenceModel) Get(id uuid.UUID) (*Sequence, error) {
 
 func (m SequenceModel) Update(sequence *Sequence) error {
 	query := `UPDATE sequences
-	SET name = $2, description = $3, actions = $4, version = version + 1
-	WHERE id = $1
+	SET name = $2, description = $3, actions = $4, area_id = $5, tags = $6, version = version + 1
+	WHERE id = $1 AND version = $7
 	RETURNING version`
 
-	args := []any{sequence.ID, sequence.Name, sequence.Description, sequence.Actions}
+	args := []any{sequence.ID, sequence.Name, sequence.Description, sequence.Actions, sequence.AreaID, nonNilTags(sequence.Tags), sequence.Version}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -149,7 +533,7 @@ func (m SequenceModel) Update(sequence *Sequence) error {
 	if err != nil {
 		switch {
 		case errors.Is(err, pgx.ErrNoRows):
-			return ErrRecordNotFound
+			return ErrEditConflict
 		default:
 			return err
 		}
@@ -176,3 +560,33 @@ func (m SequenceModel) Delete(id uuid.UUID) error {
 
 	return nil
 }
+
+// CheckCalls makes sure every sequence called (directly or not) by given sequence exists
+// and that none of them leads back to it
+func (m SequenceModel) CheckCalls(sequence *Sequence) error {
+	visited := make(map[uuid.UUID]struct{})
+	queue := SequenceCalls(sequence.Actions)
+
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+
+		if id == sequence.ID {
+			return ErrSequenceRecursion
+		}
+
+		if _, ok := visited[id]; ok {
+			continue
+		}
+		visited[id] = struct{}{}
+
+		called, err := m.Get(id)
+		if err != nil {
+			return err
+		}
+
+		queue = append(queue, SequenceCalls(called.Actions)...)
+	}
+
+	return nil
+}
@@ -0,0 +1,297 @@
+package data_test
+
+import (
+	"encoding/json"
+	"inzynierka/internal/data"
+	"inzynierka/internal/data/validator"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSequenceActionUnmarshalling(t *testing.T) {
+	jsonData := `[
+    {
+        "target": "7b55654c-fbd1-4054-9b93-228e8e7e8544",
+        "value": 1,
+        "msDelay": 0
+    },
+    {
+        "type": "repeat",
+        "times": 10,
+        "until": {
+            "type": "gt",
+            "sensor_id": "cfe7987c-5ca8-4ad1-8c1e-507ea937d71e",
+            "value": 40
+        },
+        "body": [
+            {
+                "target": "7b55654c-fbd1-4054-9b93-228e8e7e8544",
+                "value": 1,
+                "msDelay": 1000
+            },
+            {
+                "type": "call",
+                "sequence": "3a415307-7845-4f05-a790-4e8e203a49c3"
+            }
+        ]
+    }
+]`
+
+	var actions []data.SequenceAction
+	err := json.Unmarshal([]byte(jsonData), &actions)
+	if err != nil {
+		t.Fatalf("Expected success, found %v", err)
+	}
+
+	if len(actions) != 2 {
+		t.Fatalf("got %d actions, wanted 2", len(actions))
+	}
+
+	if actions[0].Kind() != data.ActionSet {
+		t.Errorf("got %q, wanted %q", actions[0].Kind(), data.ActionSet)
+	}
+
+	repeat := actions[1]
+	if repeat.Kind() != data.ActionRepeat {
+		t.Fatalf("got %q, wanted %q", repeat.Kind(), data.ActionRepeat)
+	}
+
+	if repeat.Until == nil {
+		t.Fatal("until condition was not unmarshalled")
+	}
+
+	deps := repeat.Until.Dependencies()
+	if !slices.Contains(deps, uuid.MustParse("cfe7987c-5ca8-4ad1-8c1e-507ea937d71e")) {
+		t.Errorf("until condition is missing dependency, got %v", deps)
+	}
+
+	if len(repeat.Body) != 2 {
+		t.Errorf("got %d body actions, wanted 2", len(repeat.Body))
+	}
+}
+
+func TestSequenceActionMarshalUnmarshal(t *testing.T) {
+	sensorId := uuid.New()
+	iActions := []data.SequenceAction{
+		{
+			Type:      data.ActionIf,
+			Condition: &data.RuleLT{SensorID: sensorId, Value: 5},
+			Then:      []data.SequenceAction{{Target: uuid.New(), Value: data.BoolValue(true)}},
+			Else:      []data.SequenceAction{{Target: uuid.New(), Value: data.NumberValue(0)}},
+		},
+	}
+
+	marshalled, err := json.Marshal(iActions)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	var uActions []data.SequenceAction
+	err = json.Unmarshal(marshalled, &uActions)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if uActions[0].Condition == nil {
+		t.Fatal("condition was not unmarshalled")
+	}
+
+	if !slices.Contains(uActions[0].Condition.Dependencies(), sensorId) {
+		t.Errorf("condition is missing %v dependency", sensorId)
+	}
+
+	if uActions[0].Then[0].Target != iActions[0].Then[0].Target {
+		t.Errorf("Expected: %v; Got: %v", iActions[0].Then[0].Target, uActions[0].Then[0].Target)
+	}
+
+	if uActions[0].Then[0].Value != iActions[0].Then[0].Value {
+		t.Errorf("Expected: %v; Got: %v", iActions[0].Then[0].Value, uActions[0].Then[0].Value)
+	}
+
+	if uActions[0].Else[0].Value != iActions[0].Else[0].Value {
+		t.Errorf("Expected: %v; Got: %v", iActions[0].Else[0].Value, uActions[0].Else[0].Value)
+	}
+}
+
+func TestSequenceCalls(t *testing.T) {
+	first := uuid.New()
+	second := uuid.New()
+
+	actions := []data.SequenceAction{
+		{Type: data.ActionCall, Sequence: first},
+		{
+			Type:      data.ActionIf,
+			Condition: &data.RuleGT{SensorID: uuid.New()},
+			Then:      []data.SequenceAction{{Type: data.ActionCall, Sequence: second}},
+			Else: []data.SequenceAction{{
+				Type:  data.ActionRepeat,
+				Times: 2,
+				Body:  []data.SequenceAction{{Type: data.ActionCall, Sequence: first}},
+			}},
+		},
+	}
+
+	calls := data.SequenceCalls(actions)
+
+	if len(calls) != 2 {
+		t.Errorf("got %d calls, wanted 2", len(calls))
+	}
+
+	for _, id := range []uuid.UUID{first, second} {
+		if !slices.Contains(calls, id) {
+			t.Errorf("calls does not contain %q", id)
+		}
+	}
+}
+
+var (
+	binarySwitch  = &data.Sensor{ID: uuid.New(), Type: data.BinarySwitch}
+	decimalSwitch = &data.Sensor{ID: uuid.New(), Type: data.DecimalSwitch}
+	decimalSensor = &data.Sensor{ID: uuid.New(), Type: data.DecimalSensor}
+
+	validationSensors = map[uuid.UUID]*data.Sensor{
+		binarySwitch.ID:  binarySwitch,
+		decimalSwitch.ID: decimalSwitch,
+		decimalSensor.ID: decimalSensor,
+	}
+)
+
+var sequenceActionValidationTests = []struct {
+	name   string
+	action data.SequenceAction
+	key    string
+}{
+	{"set without target", data.SequenceAction{}, "actions[0].target"},
+	{"set unknown target", data.SequenceAction{Target: uuid.New()}, "actions[0].target"},
+	{"set read only target", data.SequenceAction{Target: decimalSensor.ID}, "actions[0].target"},
+	{"set number to binary switch", data.SequenceAction{Target: binarySwitch.ID, Value: data.NumberValue(0.5)}, "actions[0].value"},
+	{"set bool to decimal switch", data.SequenceAction{Target: decimalSwitch.ID, Value: data.BoolValue(true)}, "actions[0].value"},
+	{"set infinite value", data.SequenceAction{Target: decimalSwitch.ID, Value: data.NumberValue(math.Inf(1))}, "actions[0].value"},
+	{"negative delay", data.SequenceAction{Target: binarySwitch.ID, MsDelay: -1}, "actions[0].msDelay"},
+	{"wait without condition", data.SequenceAction{Type: data.ActionWaitUntil, MsTimeout: 100}, "actions[0].condition"},
+	{"wait without timeout", data.SequenceAction{Type: data.ActionWaitUntil, Condition: &data.RuleGT{SensorID: uuid.New()}}, "actions[0].msTimeout"},
+	{"repeat without limit", data.SequenceAction{Type: data.ActionRepeat, Body: []data.SequenceAction{{Target: binarySwitch.ID}}}, "actions[0].times"},
+	{"repeat with invalid body", data.SequenceAction{Type: data.ActionRepeat, Times: 2, Body: []data.SequenceAction{{}}}, "actions[0].body[0].target"},
+	{"call without sequence", data.SequenceAction{Type: data.ActionCall}, "actions[0].sequence"},
+	{"unknown type", data.SequenceAction{Type: "jump"}, "actions[0].type"},
+	{"parallel without branches", data.SequenceAction{Type: data.ActionParallel}, "actions[0].branches"},
+	{"parallel with empty branch", data.SequenceAction{Type: data.ActionParallel, Branches: [][]data.SequenceAction{{}}}, "actions[0].branches[0]"},
+	{"scene without id", data.SequenceAction{Type: data.ActionScene}, "actions[0].scene"},
+	{"unknown failure policy", data.SequenceAction{Target: binarySwitch.ID, OnFailure: "retry"}, "actions[0].onFailure"},
+	{"negative retries", data.SequenceAction{Target: binarySwitch.ID, Retries: -1}, "actions[0].retries"},
+	{"too many retries", data.SequenceAction{Target: binarySwitch.ID, Retries: data.MaxSequenceRetries + 1}, "actions[0].retries"},
+	{"too long backoff", data.SequenceAction{Target: binarySwitch.ID, MsBackoff: data.MaxMsBackoff + 1}, "actions[0].msBackoff"},
+}
+
+func TestValidateSequenceActions(t *testing.T) {
+	for _, test := range sequenceActionValidationTests {
+		v := validator.New()
+		data.ValidateSequenceActions(v, []data.SequenceAction{test.action}, "actions", validationSensors)
+
+		if _, ok := v.Errors[test.key]; !ok {
+			t.Errorf("%s: expected error for %q, got %v", test.name, test.key, v.Errors)
+		}
+	}
+}
+
+func TestValidateSequence(t *testing.T) {
+	sequence := data.Sequence{
+		Name: "Poranek",
+		Actions: []data.SequenceAction{
+			{Target: binarySwitch.ID, Value: data.BoolValue(true), Retries: 3, MsBackoff: 100, OnFailure: data.FailureSkip},
+			{Target: binarySwitch.ID, Value: data.NumberValue(0)},
+			{Target: decimalSwitch.ID, Value: data.NumberValue(21.5)},
+			{Type: data.ActionWaitUntil, Condition: &data.RuleGT{SensorID: decimalSensor.ID, Value: 40}, MsTimeout: 1000},
+		},
+	}
+
+	v := validator.New()
+	data.ValidateSequence(v, &sequence, validationSensors)
+
+	if !v.Valid() {
+		t.Errorf("expected valid sequence, got %v", v.Errors)
+	}
+
+	sequence.Name = ""
+	sequence.Description = strings.Repeat("a", 257)
+
+	v = validator.New()
+	data.ValidateSequence(v, &sequence, validationSensors)
+
+	for _, key := range []string{"name", "description"} {
+		if _, ok := v.Errors[key]; !ok {
+			t.Errorf("expected error for %q, got %v", key, v.Errors)
+		}
+	}
+}
+
+func TestSequenceValueUnmarshalling(t *testing.T) {
+	var values []data.SequenceValue
+	err := json.Unmarshal([]byte(`[true, 0.5, 1]`), &values)
+	if err != nil {
+		t.Fatalf("Expected success, found %v", err)
+	}
+
+	expected := []data.SequenceValue{data.BoolValue(true), data.NumberValue(0.5), data.NumberValue(1)}
+	if !slices.Equal(values, expected) {
+		t.Errorf("Expected: %v; Got: %v", expected, values)
+	}
+
+	// options of enum switches
+	var value data.SequenceValue
+	if err = json.Unmarshal([]byte(`"heat"`), &value); err != nil || value != data.TextValue("heat") {
+		t.Errorf("Expected: %v; Got: %v %v", data.TextValue("heat"), value, err)
+	}
+
+	if err = json.Unmarshal([]byte(`{"value": 1}`), &value); err == nil {
+		t.Error("expected error for object value")
+	}
+}
+
+func TestSequenceParallelUnmarshalling(t *testing.T) {
+	jsonData := `[
+    {
+        "type": "parallel",
+        "await": false,
+        "branches": [
+            [{"target": "7b55654c-fbd1-4054-9b93-228e8e7e8544", "value": 0, "msDelay": 0}],
+            [{"type": "call", "sequence": "3a415307-7845-4f05-a790-4e8e203a49c3"}]
+        ]
+    },
+    {
+        "type": "join"
+    }
+]`
+
+	var actions []data.SequenceAction
+	err := json.Unmarshal([]byte(jsonData), &actions)
+	if err != nil {
+		t.Fatalf("Expected success, found %v", err)
+	}
+
+	parallel := actions[0]
+	if parallel.Kind() != data.ActionParallel {
+		t.Fatalf("got %q, wanted %q", parallel.Kind(), data.ActionParallel)
+	}
+
+	if parallel.Wait() {
+		t.Error("parallel step should not wait for its branches")
+	}
+
+	if len(parallel.Branches) != 2 {
+		t.Errorf("got %d branches, wanted 2", len(parallel.Branches))
+	}
+
+	calls := data.SequenceCalls(actions)
+	if !slices.Contains(calls, uuid.MustParse("3a415307-7845-4f05-a790-4e8e203a49c3")) {
+		t.Errorf("calls inside branches were not found, got %v", calls)
+	}
+
+	if (&data.SequenceAction{Type: data.ActionParallel}).Wait() != true {
+		t.Error("parallel step should wait for its branches by default")
+	}
+}
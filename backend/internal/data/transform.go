@@ -0,0 +1,154 @@
+package data
+
+import (
+	"fmt"
+	"inzynierka/internal/data/validator"
+	"math"
+	"sync"
+)
+
+type TransformType string
+
+const (
+	// adds value, eg. -1.3 for thermometer reading 1.3° too high
+	TransformOffset TransformType = "offset"
+	// multiplies by value, eg. 0.001 for watts to kilowatts
+	TransformScale TransformType = "scale"
+	// limits to min and max (either can be left out)
+	TransformClamp TransformType = "clamp"
+	// rounds to decimals
+	TransformRound TransformType = "round"
+	// averages last window values
+	TransformMovingAverage TransformType = "moving_average"
+	// maps through table, interpolating linearly between its points
+	TransformLookup TransformType = "lookup"
+)
+
+const maxTransforms = 16
+
+type LookupPoint struct {
+	In  float64 `json:"in"`
+	Out float64 `json:"out"`
+}
+
+// Transform is a single step of transform chain of sensor, only fields used by its type are set
+type Transform struct {
+	Type TransformType `json:"type"`
+	// offset or scale factor
+	Value    float64       `json:"value,omitempty"`
+	Min      *float64      `json:"min,omitempty"`
+	Max      *float64      `json:"max,omitempty"`
+	Decimals int           `json:"decimals,omitempty"`
+	Window   int           `json:"window,omitempty"`
+	Table    []LookupPoint `json:"table,omitempty"`
+}
+
+func validateTransforms(v *validator.Validator, transforms []Transform) {
+	v.Check(len(transforms) <= maxTransforms, "transforms", fmt.Sprintf("must not have more than %d steps", maxTransforms))
+
+	for i, transform := range transforms {
+		field := fmt.Sprintf("transforms[%d]", i)
+
+		switch transform.Type {
+		case TransformOffset:
+			v.Check(!math.IsNaN(transform.Value) && !math.IsInf(transform.Value, 0), field+".value", "must be a finite number")
+		case TransformScale:
+			v.Check(transform.Value != 0, field+".value", "must be provided and not zero")
+			v.Check(!math.IsNaN(transform.Value) && !math.IsInf(transform.Value, 0), field+".value", "must be a finite number")
+		case TransformClamp:
+			v.Check(transform.Min != nil || transform.Max != nil, field, "must have min or max")
+			if transform.Min != nil && transform.Max != nil {
+				v.Check(*transform.Min <= *transform.Max, field+".min", "must not be greater than max")
+			}
+		case TransformRound:
+			v.Check(transform.Decimals >= 0, field+".decimals", "must not be negative")
+			v.Check(transform.Decimals <= 10, field+".decimals", "must not be more than 10")
+		case TransformMovingAverage:
+			v.Check(transform.Window >= 2, field+".window", "must be at least 2")
+			v.Check(transform.Window <= 1000, field+".window", "must not be more than 1000")
+		case TransformLookup:
+			v.Check(len(transform.Table) >= 2, field+".table", "must have at least 2 points")
+			v.Check(len(transform.Table) <= 256, field+".table", "must not have more than 256 points")
+			increasing := true
+			for j := 1; j < len(transform.Table); j++ {
+				increasing = increasing && transform.Table[j].In > transform.Table[j-1].In
+			}
+			v.Check(increasing, field+".table", "must have points sorted by strictly increasing in")
+		default:
+			v.AddError(field+".type", "must be one of offset, scale, clamp, round, moving_average or lookup")
+		}
+	}
+}
+
+// TransformPipeline applies transform chain of a sensor to its raw values.
+// Moving averages keep recent values, so every listener has its own pipeline
+type TransformPipeline struct {
+	transforms []Transform
+	mu         sync.Mutex
+	// recent values of moving average steps, by step index
+	windows map[int][]float64
+}
+
+func NewTransformPipeline(transforms []Transform) *TransformPipeline {
+	return &TransformPipeline{transforms: transforms, windows: make(map[int][]float64)}
+}
+
+func (p *TransformPipeline) Apply(value float64) float64 {
+	if len(p.transforms) == 0 {
+		return value
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, transform := range p.transforms {
+		switch transform.Type {
+		case TransformOffset:
+			value += transform.Value
+		case TransformScale:
+			value *= transform.Value
+		case TransformClamp:
+			if transform.Min != nil {
+				value = math.Max(value, *transform.Min)
+			}
+			if transform.Max != nil {
+				value = math.Min(value, *transform.Max)
+			}
+		case TransformRound:
+			scale := math.Pow(10, float64(transform.Decimals))
+			value = math.Round(value*scale) / scale
+		case TransformMovingAverage:
+			window := append(p.windows[i], value)
+			if len(window) > transform.Window {
+				window = window[1:]
+			}
+			p.windows[i] = window
+
+			sum := 0.0
+			for _, v := range window {
+				sum += v
+			}
+			value = sum / float64(len(window))
+		case TransformLookup:
+			value = lookup(transform.Table, value)
+		}
+	}
+
+	return value
+}
+
+// interpolates linearly between points of the table, values outside of it get the closest point
+func lookup(table []LookupPoint, value float64) float64 {
+	if value <= table[0].In {
+		return table[0].Out
+	}
+
+	for i := 1; i < len(table); i++ {
+		if value <= table[i].In {
+			prev, next := table[i-1], table[i]
+			return prev.Out + (value-prev.In)*(next.Out-prev.Out)/(next.In-prev.In)
+		}
+	}
+
+	return table[len(table)-1].Out
+}
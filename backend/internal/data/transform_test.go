@@ -0,0 +1,75 @@
+package data_test
+
+import (
+	"inzynierka/internal/data"
+	"inzynierka/internal/data/validator"
+	"math"
+	"testing"
+)
+
+func TestTransformPipeline(t *testing.T) {
+	min, max := 0.0, 10.0
+
+	tests := []struct {
+		name       string
+		transforms []data.Transform
+		values     []float64
+		expected   []float64
+	}{
+		{"none", nil, []float64{1.5}, []float64{1.5}},
+		{"offset and round", []data.Transform{{Type: data.TransformOffset, Value: -1.3}, {Type: data.TransformRound, Decimals: 1}}, []float64{22.44}, []float64{21.1}},
+		{"watts to kilowatts", []data.Transform{{Type: data.TransformScale, Value: 0.001}}, []float64{2500}, []float64{2.5}},
+		{"clamp", []data.Transform{{Type: data.TransformClamp, Min: &min, Max: &max}}, []float64{-3, 5, 12}, []float64{0, 5, 10}},
+		{"moving average", []data.Transform{{Type: data.TransformMovingAverage, Window: 3}}, []float64{3, 6, 9, 12}, []float64{3, 4.5, 6, 9}},
+		{
+			"lookup",
+			[]data.Transform{{Type: data.TransformLookup, Table: []data.LookupPoint{{In: 0, Out: 100}, {In: 10, Out: 50}, {In: 20, Out: 0}}}},
+			[]float64{-5, 5, 15, 25},
+			[]float64{100, 75, 25, 0},
+		},
+	}
+
+	for _, test := range tests {
+		pipeline := data.NewTransformPipeline(test.transforms)
+
+		for i, value := range test.values {
+			if got := pipeline.Apply(value); math.Abs(got-test.expected[i]) > 1e-9 {
+				t.Errorf("%s (%v): Expected: %v; Got: %v", test.name, value, test.expected[i], got)
+			}
+		}
+	}
+}
+
+func TestValidateTransforms(t *testing.T) {
+	min, max := 10.0, 0.0
+
+	tests := []struct {
+		transforms []data.Transform
+		valid      bool
+	}{
+		{[]data.Transform{{Type: data.TransformOffset, Value: -1.3}, {Type: data.TransformMovingAverage, Window: 5}}, true},
+		{[]data.Transform{{Type: "invert"}}, false},
+		{[]data.Transform{{Type: data.TransformScale}}, false},
+		{[]data.Transform{{Type: data.TransformClamp}}, false},
+		{[]data.Transform{{Type: data.TransformClamp, Min: &min, Max: &max}}, false},
+		{[]data.Transform{{Type: data.TransformMovingAverage, Window: 1}}, false},
+		{[]data.Transform{{Type: data.TransformLookup, Table: []data.LookupPoint{{In: 1, Out: 1}, {In: 1, Out: 2}}}}, false},
+	}
+
+	for _, test := range tests {
+		sensor := data.Sensor{
+			Name:        "sensor",
+			URI:         "192.168.1.10:9000",
+			Type:        data.DecimalSensor,
+			RefreshRate: 5,
+			Transport:   data.TransportHTTP,
+			Transforms:  test.transforms,
+		}
+
+		v := validator.New()
+		data.ValidateSensor(v, &sensor)
+		if v.Valid() != test.valid {
+			t.Errorf("%+v: Expected valid: %v; Got errors: %v", test.transforms, test.valid, v.Errors)
+		}
+	}
+}
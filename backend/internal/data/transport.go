@@ -0,0 +1,310 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"inzynierka/internal/data/validator"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrTransportUnsupported  = errors.New("operation not supported by sensor transport")
+	ErrTransportUnavailable  = errors.New("sensor transport not available")
+	ErrSensorNotListening    = errors.New("no listener subscribed to sensor values")
+	ErrInvalidSensorResponse = errors.New("sensor returned invalid response")
+)
+
+// handles value received from the sensor, measured at given time
+type ValueHandler func(value SensorValue, at time.Time) error
+
+// details of the server sent to the sensor on initialization, so it knows where to push values
+type InitRequest struct {
+	IdToken              uuid.UUID `json:"id-token"`
+	ServerUri            string    `json:"server-uri"`
+	InitAckEndpoint      string    `json:"init-ack-endpoint"`
+	MeasurementsEndpoint string    `json:"measurements-endpoint"`
+}
+
+// Transport carries values between the server and sensors using one protocol,
+// operations which make no sense for the protocol return ErrTransportUnsupported
+type Transport interface {
+	// Read fetches current value of polled (not active) sensor
+	Read(ctx context.Context, sensor *Sensor) (SensorValue, error)
+	// Write sends value (already coerced to the kind of the sensor) to writable sensor
+	Write(ctx context.Context, sensor *Sensor, value SensorValue) error
+	// Subscribe passes values pushed by active sensor to handler until returned function is called
+	Subscribe(sensor *Sensor, handler ValueHandler) (func(), error)
+	// Init asks active sensor to start pushing values to the server
+	Init(ctx context.Context, sensor *Sensor, request InitRequest) error
+}
+
+// PushTransport receives values pushed by sensors to server endpoints, Deliver passes them to subscribed handler
+type PushTransport interface {
+	Transport
+	Deliver(id uuid.UUID, value SensorValue, at time.Time) error
+}
+
+// PayloadWriter is implemented by transports able to send arbitrary payload (eg. of rule action) to the sensor
+type PayloadWriter interface {
+	WritePayload(ctx context.Context, sensor *Sensor, payload map[string]any) error
+}
+
+// StatusChecker is implemented by transports able to ask the sensor whether it is online
+type StatusChecker interface {
+	Status(ctx context.Context, sensor *Sensor) error
+}
+
+type Transports map[SensorTransport]Transport
+
+func (t Transports) For(sensor *Sensor) (Transport, error) {
+	transport, ok := t[sensor.Transport]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTransportUnavailable, sensor.Transport)
+	}
+
+	return transport, nil
+}
+
+type pushSubscription struct {
+	handler ValueHandler
+}
+
+// keeps handlers of sensors pushing values to the server, embedded by push transports
+type pushHub struct {
+	mu       sync.Mutex
+	handlers map[uuid.UUID]*pushSubscription
+}
+
+func newPushHub() pushHub {
+	return pushHub{handlers: make(map[uuid.UUID]*pushSubscription)}
+}
+
+func (h *pushHub) Subscribe(sensor *Sensor, handler ValueHandler) (func(), error) {
+	subscription := &pushSubscription{handler: handler}
+
+	h.mu.Lock()
+	h.handlers[sensor.ID] = subscription
+	h.mu.Unlock()
+
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		// sensor may have been subscribed again (eg. after update) before the old listener stopped
+		if h.handlers[sensor.ID] == subscription {
+			delete(h.handlers, sensor.ID)
+		}
+	}, nil
+}
+
+func (h *pushHub) Deliver(id uuid.UUID, value SensorValue, at time.Time) error {
+	h.mu.Lock()
+	subscription, ok := h.handlers[id]
+	h.mu.Unlock()
+
+	if !ok {
+		return ErrSensorNotListening
+	}
+
+	return subscription.handler(value, at)
+}
+
+// HTTPTransport polls sensors with GET /value and writes values with PUT /value on their uri,
+// active sensors are initialized with POST /init and push values to server endpoint
+type HTTPTransport struct {
+	pushHub
+	client *http.Client
+}
+
+func NewHTTPTransport(client *http.Client) *HTTPTransport {
+	return &HTTPTransport{pushHub: newPushHub(), client: client}
+}
+
+func (t *HTTPTransport) Read(ctx context.Context, sensor *Sensor) (SensorValue, error) {
+	var body struct {
+		Value json.RawMessage `json:"value"`
+	}
+
+	err := t.do(ctx, http.MethodGet, fmt.Sprintf("http://%s/value", sensor.URI), nil, &body)
+	if err != nil {
+		return SensorValue{}, err
+	}
+
+	value, err := parseJSONValue(body.Value)
+	if err != nil {
+		return SensorValue{}, fmt.Errorf("%w: %v", ErrInvalidSensorResponse, err)
+	}
+
+	return value, nil
+}
+
+func (t *HTTPTransport) Write(ctx context.Context, sensor *Sensor, value SensorValue) error {
+	return t.WritePayload(ctx, sensor, map[string]any{"value": value.wire()})
+}
+
+func (t *HTTPTransport) WritePayload(ctx context.Context, sensor *Sensor, payload map[string]any) error {
+	return t.do(ctx, http.MethodPut, fmt.Sprintf("http://%s/value", sensor.URI), payload, nil)
+}
+
+// Status checks the device at sensor uri reports itself online
+func (t *HTTPTransport) Status(ctx context.Context, sensor *Sensor) error {
+	_, err := t.deviceType(ctx, sensor.URI)
+	return err
+}
+
+// asks device at uri for its /status, returns its sensor type if it is online
+func (t *HTTPTransport) deviceType(ctx context.Context, uri string) (SensorType, error) {
+	var status struct {
+		Status string     `json:"status"`
+		Type   SensorType `json:"type"`
+	}
+
+	err := t.do(ctx, http.MethodGet, fmt.Sprintf("http://%s/status", uri), nil, &status)
+	if err != nil {
+		return "", err
+	}
+
+	if status.Status != "online" || !validator.PermittedValue(status.Type, SensorTypes...) {
+		return "", fmt.Errorf("%w: %s reported status %q and type %q", ErrNotADevice, uri, status.Status, status.Type)
+	}
+
+	return status.Type, nil
+}
+
+func (t *HTTPTransport) Init(ctx context.Context, sensor *Sensor, request InitRequest) error {
+	return t.do(ctx, http.MethodPost, fmt.Sprintf("http://%s/init", sensor.URI), request, nil)
+}
+
+// sends request with optional json body and decodes json response into dst (if not nil),
+// any non 2xx response is treated as an error
+func (t *HTTPTransport) do(ctx context.Context, method, url string, body any, dst any) error {
+	var reqBody io.Reader
+	if body != nil {
+		buf := new(bytes.Buffer)
+		if err := json.NewEncoder(buf).Encode(body); err != nil {
+			return err
+		}
+		reqBody = buf
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		// drain body so the connection can be reused
+		_, _ = io.Copy(io.Discard, res.Body)
+		return fmt.Errorf("%w: %s", ErrSensorHttpErrorResponse, res.Status)
+	}
+
+	if dst == nil {
+		_, _ = io.Copy(io.Discard, res.Body)
+		return nil
+	}
+
+	if err = json.NewDecoder(res.Body).Decode(dst); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSensorResponse, err)
+	}
+
+	return nil
+}
+
+// accepts json numbers, booleans and strings, they are coerced to the kind of the sensor by its listener
+func parseJSONValue(raw json.RawMessage) (SensorValue, error) {
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return SensorValue{}, errors.New("value must be a number, boolean or string")
+	}
+
+	parsed, ok := sensorValueOf(value)
+	if !ok {
+		return SensorValue{}, errors.New("value must be a number, boolean or string")
+	}
+
+	return parsed, nil
+}
+
+// LineProtocolTransport receives values ingested in InfluxDB line protocol, sensors are read-only
+type LineProtocolTransport struct {
+	pushHub
+}
+
+func NewLineProtocolTransport() *LineProtocolTransport {
+	return &LineProtocolTransport{pushHub: newPushHub()}
+}
+
+func (t *LineProtocolTransport) Read(ctx context.Context, sensor *Sensor) (SensorValue, error) {
+	return SensorValue{}, ErrTransportUnsupported
+}
+
+func (t *LineProtocolTransport) Write(ctx context.Context, sensor *Sensor, value SensorValue) error {
+	return ErrTransportUnsupported
+}
+
+func (t *LineProtocolTransport) Init(ctx context.Context, sensor *Sensor, request InitRequest) error {
+	return ErrTransportUnsupported
+}
+
+// MQTTTransport subscribes to state topics of sensors and publishes values to their command topics
+type MQTTTransport struct {
+	client *MQTTClient
+}
+
+func NewMQTTTransport(client *MQTTClient) *MQTTTransport {
+	return &MQTTTransport{client: client}
+}
+
+func (t *MQTTTransport) Read(ctx context.Context, sensor *Sensor) (SensorValue, error) {
+	return SensorValue{}, ErrTransportUnsupported
+}
+
+func (t *MQTTTransport) Write(ctx context.Context, sensor *Sensor, value SensorValue) error {
+	if sensor.MQTT == nil || sensor.MQTT.CommandTopic == "" {
+		return fmt.Errorf("%w: sensor has no command topic", ErrTransportUnsupported)
+	}
+
+	payload, err := MQTTCommandPayload(sensor, value)
+	if err != nil {
+		return err
+	}
+
+	return t.client.Publish(sensor.MQTT.CommandTopic, payload)
+}
+
+func (t *MQTTTransport) Subscribe(sensor *Sensor, handler ValueHandler) (func(), error) {
+	topic, _ := SplitMQTTUri(sensor.URI)
+
+	return t.client.Subscribe(topic, func(payload []byte) {
+		value, err := ParseMQTTPayload(sensor, payload)
+		if err != nil {
+			logger.Warn("mqtt payload", "sensor", sensor.ID, "topic", topic, "error", err)
+			return
+		}
+
+		if err = handler(value, time.Now()); err != nil {
+			logger.Error("mqtt value", "sensor", sensor.ID, "error", err)
+		}
+	})
+}
+
+func (t *MQTTTransport) Init(ctx context.Context, sensor *Sensor, request InitRequest) error {
+	return ErrTransportUnsupported
+}
@@ -0,0 +1,91 @@
+package data_test
+
+import (
+	"context"
+	"errors"
+	"inzynierka/internal/data"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestPushTransportSubscriptions(t *testing.T) {
+	transport := data.NewLineProtocolTransport()
+	sensor := &data.Sensor{ID: uuid.New(), Transport: data.TransportLineProtocol}
+
+	err := transport.Deliver(sensor.ID, data.DecimalValue(1), time.Now())
+	if !errors.Is(err, data.ErrSensorNotListening) {
+		t.Fatalf("Expected: %v; Got: %v", data.ErrSensorNotListening, err)
+	}
+
+	received := []float64{}
+	handler := func(value data.SensorValue, at time.Time) error {
+		received = append(received, value.Number)
+		return nil
+	}
+
+	unsubscribeOld, _ := transport.Subscribe(sensor, handler)
+	unsubscribeNew, _ := transport.Subscribe(sensor, handler)
+
+	// old listener stopping after the new one subscribed must not remove its handler
+	unsubscribeOld()
+
+	if err = transport.Deliver(sensor.ID, data.DecimalValue(21.5), time.Now()); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	unsubscribeNew()
+
+	if err = transport.Deliver(sensor.ID, data.DecimalValue(22), time.Now()); !errors.Is(err, data.ErrSensorNotListening) {
+		t.Fatalf("Expected: %v; Got: %v", data.ErrSensorNotListening, err)
+	}
+
+	if len(received) != 1 || received[0] != 21.5 {
+		t.Errorf("Expected: [21.5]; Got: %v", received)
+	}
+}
+
+func TestHTTPTransport(t *testing.T) {
+	var written string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/value" && r.Method == http.MethodGet:
+			w.Write([]byte(`{"value": true}`))
+		case r.URL.Path == "/value" && r.Method == http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			written = strings.TrimSpace(string(body))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	transport := data.NewHTTPTransport(server.Client())
+	sensor := &data.Sensor{URI: strings.TrimPrefix(server.URL, "http://"), Transport: data.TransportHTTP}
+
+	value, err := transport.Read(context.Background(), sensor)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if value.Number != 1 {
+		t.Errorf("Expected: 1; Got: %v", value)
+	}
+
+	if err = transport.Write(context.Background(), sensor, data.DecimalValue(22.5)); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if written != `{"value":22.5}` {
+		t.Errorf("Expected: {\"value\":22.5}; Got: %s", written)
+	}
+
+	err = transport.Init(context.Background(), sensor, data.InitRequest{})
+	if !errors.Is(err, data.ErrSensorHttpErrorResponse) {
+		t.Errorf("Expected: %v; Got: %v", data.ErrSensorHttpErrorResponse, err)
+	}
+}
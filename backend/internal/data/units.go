@@ -0,0 +1,125 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnknownUnit       = errors.New("unknown unit")
+	ErrIncompatibleUnits = errors.New("units measure different quantities")
+	ErrValueOutOfRange   = errors.New("value out of sensor range")
+)
+
+// Unit of sensor values, every unit of a quantity converts linearly to its base unit (the one with Scale 1 and Offset 0)
+type Unit struct {
+	Symbol   string `json:"symbol"`
+	Name     string `json:"name"`
+	Quantity string `json:"quantity"`
+	// value in base unit is value * Scale + Offset
+	Scale  float64 `json:"-"`
+	Offset float64 `json:"-"`
+}
+
+var Units = []Unit{
+	{Symbol: "°C", Name: "degree Celsius", Quantity: "temperature", Scale: 1},
+	{Symbol: "°F", Name: "degree Fahrenheit", Quantity: "temperature", Scale: 5.0 / 9.0, Offset: -32 * 5.0 / 9.0},
+	{Symbol: "K", Name: "kelvin", Quantity: "temperature", Scale: 1, Offset: -273.15},
+	{Symbol: "W", Name: "watt", Quantity: "power", Scale: 1},
+	{Symbol: "kW", Name: "kilowatt", Quantity: "power", Scale: 1000},
+	{Symbol: "Wh", Name: "watt-hour", Quantity: "energy", Scale: 1},
+	{Symbol: "kWh", Name: "kilowatt-hour", Quantity: "energy", Scale: 1000},
+	{Symbol: "V", Name: "volt", Quantity: "voltage", Scale: 1},
+	{Symbol: "mV", Name: "millivolt", Quantity: "voltage", Scale: 0.001},
+	{Symbol: "A", Name: "ampere", Quantity: "current", Scale: 1},
+	{Symbol: "mA", Name: "milliampere", Quantity: "current", Scale: 0.001},
+	{Symbol: "hPa", Name: "hectopascal", Quantity: "pressure", Scale: 1},
+	{Symbol: "Pa", Name: "pascal", Quantity: "pressure", Scale: 0.01},
+	{Symbol: "%", Name: "percent", Quantity: "ratio", Scale: 1},
+	{Symbol: "ppm", Name: "parts per million", Quantity: "concentration", Scale: 1},
+	{Symbol: "lx", Name: "lux", Quantity: "illuminance", Scale: 1},
+	{Symbol: "m", Name: "metre", Quantity: "length", Scale: 1},
+	{Symbol: "cm", Name: "centimetre", Quantity: "length", Scale: 0.01},
+	{Symbol: "L", Name: "litre", Quantity: "volume", Scale: 1},
+	{Symbol: "m³", Name: "cubic metre", Quantity: "volume", Scale: 1000},
+}
+
+func LookupUnit(symbol string) (Unit, bool) {
+	for _, unit := range Units {
+		if unit.Symbol == symbol {
+			return unit, true
+		}
+	}
+
+	return Unit{}, false
+}
+
+// UnitConversion converts values linearly, value * Scale + Offset
+type UnitConversion struct {
+	Scale  float64
+	Offset float64
+}
+
+var identityConversion = UnitConversion{Scale: 1}
+
+func (c UnitConversion) Apply(value float64) float64 {
+	return value*c.Scale + c.Offset
+}
+
+// NewUnitConversion returns conversion of values between units of the same quantity
+func NewUnitConversion(from, to string) (UnitConversion, error) {
+	if from == to {
+		return identityConversion, nil
+	}
+
+	fromUnit, ok := LookupUnit(from)
+	if !ok {
+		return UnitConversion{}, fmt.Errorf("%w: %q", ErrUnknownUnit, from)
+	}
+
+	toUnit, ok := LookupUnit(to)
+	if !ok {
+		return UnitConversion{}, fmt.Errorf("%w: %q", ErrUnknownUnit, to)
+	}
+
+	if fromUnit.Quantity != toUnit.Quantity {
+		return UnitConversion{}, fmt.Errorf("%w: %s is %s, %s is %s", ErrIncompatibleUnits, from, fromUnit.Quantity, to, toUnit.Quantity)
+	}
+
+	// to base unit and back
+	return UnitConversion{
+		Scale:  fromUnit.Scale / toUnit.Scale,
+		Offset: (fromUnit.Offset - toUnit.Offset) / toUnit.Scale,
+	}, nil
+}
+
+// Convert converts values of the bucket, sum is converted as sum of converted values
+func (b *MeasurementBucket) Convert(c UnitConversion) {
+	b.Avg = c.Apply(b.Avg)
+	b.Min = c.Apply(b.Min)
+	b.Max = c.Apply(b.Max)
+	b.Last = c.Apply(b.Last)
+	b.Sum = b.Sum*c.Scale + float64(b.Count)*c.Offset
+}
+
+// CheckValue returns ErrValueOutOfRange if value is outside of min and max of the sensor
+func (s *Sensor) CheckValue(value float64) error {
+	if problem := s.rangeProblem(value); problem != "" {
+		return fmt.Errorf("%w: %s", ErrValueOutOfRange, problem)
+	}
+
+	return nil
+}
+
+// describes why value is outside of the sensor range, empty if it is not
+func (s *Sensor) rangeProblem(value float64) string {
+	if s.Min != nil && value < *s.Min {
+		return fmt.Sprintf("must not be less than %g", *s.Min)
+	}
+
+	if s.Max != nil && value > *s.Max {
+		return fmt.Sprintf("must not be greater than %g", *s.Max)
+	}
+
+	return ""
+}
@@ -0,0 +1,83 @@
+package data_test
+
+import (
+	"errors"
+	"inzynierka/internal/data"
+	"inzynierka/internal/data/validator"
+	"math"
+	"testing"
+)
+
+func TestUnitConversion(t *testing.T) {
+	tests := []struct {
+		from, to string
+		value    float64
+		expected float64
+	}{
+		{"°C", "°F", 100, 212},
+		{"°F", "°C", 32, 0},
+		{"K", "°F", 273.15, 32},
+		{"W", "kW", 2500, 2.5},
+		{"kWh", "Wh", 1.2, 1200},
+		{"", "", 7, 7},
+	}
+
+	for _, test := range tests {
+		conversion, err := data.NewUnitConversion(test.from, test.to)
+		if err != nil {
+			t.Errorf("%s -> %s: Error: %v", test.from, test.to, err)
+			continue
+		}
+
+		if got := conversion.Apply(test.value); math.Abs(got-test.expected) > 1e-9 {
+			t.Errorf("%v %s -> %s: Expected: %v; Got: %v", test.value, test.from, test.to, test.expected, got)
+		}
+	}
+
+	if _, err := data.NewUnitConversion("°C", "kW"); !errors.Is(err, data.ErrIncompatibleUnits) {
+		t.Errorf("Expected: %v; Got: %v", data.ErrIncompatibleUnits, err)
+	}
+
+	if _, err := data.NewUnitConversion("", "°C"); !errors.Is(err, data.ErrUnknownUnit) {
+		t.Errorf("Expected: %v; Got: %v", data.ErrUnknownUnit, err)
+	}
+}
+
+func TestMeasurementBucketConvert(t *testing.T) {
+	conversion, _ := data.NewUnitConversion("°C", "°F")
+	bucket := data.MeasurementBucket{Avg: 10, Min: 0, Max: 20, Sum: 30, Count: 3, Last: 20}
+
+	bucket.Convert(conversion)
+
+	// sum of 0, 10 and 20 °C in °F
+	if bucket.Avg != 50 || bucket.Min != 32 || bucket.Max != 68 || math.Abs(bucket.Sum-150) > 1e-9 || bucket.Count != 3 {
+		t.Errorf("Got: %+v", bucket)
+	}
+}
+
+func TestSensorValueRange(t *testing.T) {
+	min, max := 16.0, 28.0
+	sensor := data.Sensor{Type: data.DecimalSwitch, Min: &min, Max: &max}
+
+	tests := []struct {
+		value float64
+		valid bool
+	}{
+		{16, true},
+		{21.5, true},
+		{15.9, false},
+		{30, false},
+	}
+
+	for _, test := range tests {
+		v := validator.New()
+		data.ValidateSensorValue(v, "value", &sensor, data.NumberValue(test.value))
+		if v.Valid() != test.valid {
+			t.Errorf("%v: Expected valid: %v; Got errors: %v", test.value, test.valid, v.Errors)
+		}
+
+		if err := sensor.CheckValue(test.value); (err == nil) != test.valid {
+			t.Errorf("%v: Expected valid: %v; Got error: %v", test.value, test.valid, err)
+		}
+	}
+}
@@ -174,14 +174,24 @@ func (m UserModel) Update(user *User) error {
 	query := `
     UPDATE users
     SET display_name = $1, role = $2, password_hash = $3, version = version + 1
-    WHERE id = $4
+    WHERE id = $4 AND version = $5
     RETURNING version
     `
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	return m.DB.QueryRow(ctx, query, user.Name, user.Role, user.Password.hash, user.ID).Scan(&user.Version)
+	err := m.DB.QueryRow(ctx, query, user.Name, user.Role, user.Password.hash, user.ID, user.Version).Scan(&user.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (m UserModel) DeleteByUsername(username string) error {
@@ -205,25 +215,35 @@ func (m UserModel) DeleteByUsername(username string) error {
 	return nil
 }
 
-func (m UserModel) GetAllUsers() ([]*User, error) {
+// GetAllUsers returns page of users, searching both username and display name
+func (m UserModel) GetAllUsers(filters Filters) ([]*User, Metadata, error) {
+	q := &listQuery{}
+	filters.search(q, "username || ' ' || display_name")
+	pagination := filters.pagination(q)
+
 	query := `
-    SELECT id, username, display_name, role, created_at, version
+    SELECT count(*) OVER(), id, username, display_name, role, created_at, version
     FROM users
-    `
+    ` + q.whereClause() + `
+    ` + filters.orderBy() + `
+    ` + pagination
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	rows, err := m.DB.Query(ctx, query)
+	rows, err := m.DB.Query(ctx, query, q.args...)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
+	defer rows.Close()
 
+	totalRecords := 0
 	users := make([]*User, 0)
 
 	for rows.Next() {
 		var user User
 		err := rows.Scan(
+			&totalRecords,
 			&user.ID,
 			&user.Username,
 			&user.Name,
@@ -232,13 +252,17 @@ func (m UserModel) GetAllUsers() ([]*User, error) {
 			&user.Version,
 		)
 		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
 
 		users = append(users, &user)
 	}
 
-	return users, nil
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return users, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
 }
 
 func (m UserModel) GetForToken(tokenPlaintext string) (*User, error) {
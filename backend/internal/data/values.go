@@ -0,0 +1,230 @@
+package data
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"inzynierka/internal/data/validator"
+	"math"
+	"slices"
+	"strconv"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidSensorValue = errors.New("value does not fit the sensor")
+)
+
+const (
+	maxTextValueLength = 255
+	maxEnumOptions     = 32
+	// integers above it can not be represented exactly by float64
+	maxIntegerValue = 1 << 53
+)
+
+// ValueKind is the kind of values produced (and accepted) by sensors of given type
+type ValueKind string
+
+const (
+	KindDecimal ValueKind = "decimal"
+	KindInteger ValueKind = "integer"
+	KindBoolean ValueKind = "boolean"
+	// one of options of the sensor, eg. "heat", "cool" or "off"
+	KindEnum   ValueKind = "enum"
+	KindString ValueKind = "string"
+)
+
+func (t SensorType) ValueKind() ValueKind {
+	switch t {
+	case BinarySwitch, BinarySensor:
+		return KindBoolean
+	case IntegerSensor:
+		return KindInteger
+	case EnumSensor, EnumSwitch:
+		return KindEnum
+	case TextSensor:
+		return KindString
+	default:
+		return KindDecimal
+	}
+}
+
+// values of text kinds can not be transformed, aggregated or compared with numbers
+func (k ValueKind) IsText() bool {
+	return k == KindEnum || k == KindString
+}
+
+// SensorValue is value of any kind passed from transports through listeners to storage, rules and websocket clients.
+// Number holds decimals, integers, booleans (as 1 or 0) and index of enum option, Text holds enum option and strings
+type SensorValue struct {
+	Kind   ValueKind
+	Number float64
+	Text   string
+}
+
+func DecimalValue(value float64) SensorValue {
+	return SensorValue{Kind: KindDecimal, Number: value}
+}
+
+func IntegerValue(value int64) SensorValue {
+	return SensorValue{Kind: KindInteger, Number: float64(value)}
+}
+
+func BooleanValue(value bool) SensorValue {
+	if value {
+		return SensorValue{Kind: KindBoolean, Number: 1}
+	}
+	return SensorValue{Kind: KindBoolean}
+}
+
+func StringValue(value string) SensorValue {
+	return SensorValue{Kind: KindString, Text: value}
+}
+
+func enumValue(index int, option string) SensorValue {
+	return SensorValue{Kind: KindEnum, Number: float64(index), Text: option}
+}
+
+func (v SensorValue) IsText() bool {
+	return v.Kind.IsText()
+}
+
+// Equal compares texts of text values and numbers of the others, so true equals 1.
+// Text value never equals a number
+func (v SensorValue) Equal(other SensorValue) bool {
+	if v.IsText() || other.IsText() {
+		return v.IsText() && other.IsText() && v.Text == other.Text
+	}
+	return v.Number == other.Number
+}
+
+func (v SensorValue) String() string {
+	if v.IsText() {
+		return v.Text
+	}
+	if v.Kind == KindBoolean {
+		return strconv.FormatBool(v.Number != 0)
+	}
+	return strconv.FormatFloat(v.Number, 'f', -1, 64)
+}
+
+// value as sent to sensors: text for text values, number for the others (booleans as 1 or 0)
+func (v SensorValue) wire() any {
+	if v.IsText() {
+		return v.Text
+	}
+	return v.Number
+}
+
+func (v SensorValue) MarshalJSON() ([]byte, error) {
+	switch {
+	case v.IsText():
+		return json.Marshal(v.Text)
+	case v.Kind == KindBoolean:
+		return json.Marshal(v.Number != 0)
+	default:
+		return json.Marshal(v.Number)
+	}
+}
+
+// json booleans, numbers and strings are accepted as boolean, decimal and string values
+func (v *SensorValue) UnmarshalJSON(data []byte) error {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	parsed, ok := sensorValueOf(value)
+	if !ok {
+		return fmt.Errorf("%w: must be a number, boolean or string", ErrInvalidSensorValue)
+	}
+
+	*v = parsed
+	return nil
+}
+
+// converts value decoded from json
+func sensorValueOf(value any) (SensorValue, bool) {
+	switch value := value.(type) {
+	case float64:
+		return DecimalValue(value), true
+	case bool:
+		return BooleanValue(value), true
+	case string:
+		return StringValue(value), true
+	}
+
+	return SensorValue{}, false
+}
+
+// Coerce converts value received from the sensor (or written to it) to the kind of the sensor.
+// Numbers and booleans convert into each other, integers must be whole, enums accept one of options or its index
+// and only strings accept any text. Numbers are converted to strings, but texts are never converted to numbers
+func (s *Sensor) Coerce(value SensorValue) (SensorValue, error) {
+	kind := s.Type.ValueKind()
+
+	if value.IsText() && !kind.IsText() {
+		return SensorValue{}, fmt.Errorf("%w: %s sensor does not accept text %q", ErrInvalidSensorValue, kind, value.Text)
+	}
+
+	switch kind {
+	case KindBoolean:
+		return BooleanValue(value.Number != 0), nil
+	case KindInteger:
+		if value.Number != math.Trunc(value.Number) || math.Abs(value.Number) > maxIntegerValue {
+			return SensorValue{}, fmt.Errorf("%w: %v is not an integer", ErrInvalidSensorValue, value.Number)
+		}
+		return IntegerValue(int64(value.Number)), nil
+	case KindEnum:
+		if value.IsText() {
+			index := slices.Index(s.Options, value.Text)
+			if index < 0 {
+				return SensorValue{}, fmt.Errorf("%w: %q is not one of options", ErrInvalidSensorValue, value.Text)
+			}
+			return enumValue(index, value.Text), nil
+		}
+
+		index := int(value.Number)
+		if float64(index) != value.Number || index < 0 || index >= len(s.Options) {
+			return SensorValue{}, fmt.Errorf("%w: %v is not an index of options", ErrInvalidSensorValue, value.Number)
+		}
+		return enumValue(index, s.Options[index]), nil
+	case KindString:
+		text := value.String()
+		if len(text) > maxTextValueLength || !utf8.ValidString(text) {
+			return SensorValue{}, fmt.Errorf("%w: text must be valid utf-8 of at most %d bytes", ErrInvalidSensorValue, maxTextValueLength)
+		}
+		return StringValue(text), nil
+	default:
+		return DecimalValue(value.Number), nil
+	}
+}
+
+// options are required by enum sensors, numeric settings make no sense for text ones
+func validateValueKind(v *validator.Validator, sensor *Sensor) {
+	kind := sensor.Type.ValueKind()
+
+	if kind == KindEnum {
+		v.Check(len(sensor.Options) >= 2, "options", "must have at least 2 options")
+		v.Check(len(sensor.Options) <= maxEnumOptions, "options", fmt.Sprintf("must not have more than %d options", maxEnumOptions))
+		v.Check(validator.Unique(sensor.Options), "options", "must not be repeated")
+
+		for i, option := range sensor.Options {
+			field := fmt.Sprintf("options[%d]", i)
+			v.Check(option != "", field, "must not be empty")
+			v.Check(len(option) <= 64, field, "must not be more than 64 bytes long")
+		}
+	} else {
+		v.Check(len(sensor.Options) == 0, "options", "must only be provided for enum sensors")
+	}
+
+	if !kind.IsText() {
+		return
+	}
+
+	v.Check(sensor.Unit == "", "unit", "must not be provided for text sensors")
+	v.Check(sensor.Decimals == nil, "decimals", "must not be provided for text sensors")
+	v.Check(sensor.Min == nil && sensor.Max == nil, "min", "must not be provided for text sensors")
+	v.Check(len(sensor.Transforms) == 0, "transforms", "must not be provided for text sensors")
+	v.Check(!sensor.Anomaly.Enabled(), "anomaly", "must not be enabled for text sensors")
+}
@@ -0,0 +1,116 @@
+package data_test
+
+import (
+	"encoding/json"
+	"errors"
+	"inzynierka/internal/data"
+	"inzynierka/internal/data/validator"
+	"testing"
+)
+
+func TestSensorCoerce(t *testing.T) {
+	thermostat := data.Sensor{Type: data.EnumSwitch, Options: []string{"heat", "cool", "off"}}
+
+	tests := []struct {
+		sensor   data.Sensor
+		value    data.SensorValue
+		expected data.SensorValue
+		valid    bool
+	}{
+		{data.Sensor{Type: data.DecimalSensor}, data.DecimalValue(21.5), data.DecimalValue(21.5), true},
+		{data.Sensor{Type: data.DecimalSensor}, data.BooleanValue(true), data.DecimalValue(1), true},
+		{data.Sensor{Type: data.DecimalSensor}, data.StringValue("warm"), data.SensorValue{}, false},
+		{data.Sensor{Type: data.BinarySensor}, data.DecimalValue(1), data.BooleanValue(true), true},
+		{data.Sensor{Type: data.BinarySwitch}, data.DecimalValue(0), data.BooleanValue(false), true},
+		{data.Sensor{Type: data.IntegerSensor}, data.DecimalValue(42), data.IntegerValue(42), true},
+		{data.Sensor{Type: data.IntegerSensor}, data.DecimalValue(4.2), data.SensorValue{}, false},
+		{thermostat, data.StringValue("cool"), data.SensorValue{Kind: data.KindEnum, Number: 1, Text: "cool"}, true},
+		{thermostat, data.DecimalValue(2), data.SensorValue{Kind: data.KindEnum, Number: 2, Text: "off"}, true},
+		{thermostat, data.StringValue("auto"), data.SensorValue{}, false},
+		{thermostat, data.DecimalValue(3), data.SensorValue{}, false},
+		{data.Sensor{Type: data.TextSensor}, data.StringValue("door open"), data.StringValue("door open"), true},
+		{data.Sensor{Type: data.TextSensor}, data.DecimalValue(12.5), data.StringValue("12.5"), true},
+	}
+
+	for _, test := range tests {
+		value, err := test.sensor.Coerce(test.value)
+		if (err == nil) != test.valid {
+			t.Errorf("%s %v: Expected valid: %v; Got error: %v", test.sensor.Type, test.value, test.valid, err)
+			continue
+		}
+		if !test.valid {
+			if !errors.Is(err, data.ErrInvalidSensorValue) {
+				t.Errorf("%s %v: Expected: %v; Got: %v", test.sensor.Type, test.value, data.ErrInvalidSensorValue, err)
+			}
+			continue
+		}
+		if value != test.expected {
+			t.Errorf("%s %v: Expected: %+v; Got: %+v", test.sensor.Type, test.value, test.expected, value)
+		}
+	}
+}
+
+func TestSensorValueJSON(t *testing.T) {
+	values := []data.SensorValue{
+		data.DecimalValue(21.5),
+		data.IntegerValue(3),
+		data.BooleanValue(true),
+		{Kind: data.KindEnum, Number: 0, Text: "heat"},
+		data.StringValue("door open"),
+	}
+
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	expected := `[21.5,3,true,"heat","door open"]`
+	if string(encoded) != expected {
+		t.Errorf("Expected: %s; Got: %s", expected, encoded)
+	}
+
+	var decoded []data.SensorValue
+	if err = json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	for i, value := range decoded {
+		if !value.Equal(values[i]) {
+			t.Errorf("Expected: %v; Got: %v", values[i], value)
+		}
+	}
+
+	if err = json.Unmarshal([]byte(`[{"value": 1}]`), &decoded); !errors.Is(err, data.ErrInvalidSensorValue) {
+		t.Errorf("Expected: %v; Got: %v", data.ErrInvalidSensorValue, err)
+	}
+}
+
+func TestValidateSensorValueKind(t *testing.T) {
+	unit := "°C"
+	tests := []struct {
+		name   string
+		sensor data.Sensor
+		valid  bool
+	}{
+		{"enum with options", data.Sensor{Type: data.EnumSensor, Options: []string{"heat", "cool", "off"}}, true},
+		{"enum without options", data.Sensor{Type: data.EnumSensor}, false},
+		{"repeated options", data.Sensor{Type: data.EnumSensor, Options: []string{"off", "off"}}, false},
+		{"options of decimal sensor", data.Sensor{Type: data.DecimalSensor, Options: []string{"heat", "cool"}}, false},
+		{"text with unit", data.Sensor{Type: data.TextSensor, Unit: unit}, false},
+		{"text with transforms", data.Sensor{Type: data.TextSensor, Transforms: []data.Transform{{Type: data.TransformScale, Value: 2}}}, false},
+		{"integer with unit", data.Sensor{Type: data.IntegerSensor, Unit: unit}, true},
+	}
+
+	for _, test := range tests {
+		test.sensor.Name = "sensor"
+		test.sensor.URI = "home/state"
+		test.sensor.Transport = data.TransportMQTT
+		test.sensor.Active = true
+
+		v := validator.New()
+		data.ValidateSensor(v, &test.sensor)
+		if v.Valid() != test.valid {
+			t.Errorf("%s: Expected valid: %v; Got errors: %v", test.name, test.valid, v.Errors)
+		}
+	}
+}
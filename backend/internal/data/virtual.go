@@ -0,0 +1,210 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"inzynierka/internal/data/validator"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrMissingSourceListener = errors.New("source sensor of virtual sensor has no listener")
+)
+
+func validateVirtualSensor(v *validator.Validator, sensor *Sensor) {
+	expression, err := ParseExpression(sensor.URI)
+	if err != nil {
+		v.AddError("uri", fmt.Sprintf("must be valid expression (%v)", err))
+		return
+	}
+
+	v.Check(len(expression.Sources()) > 0, "uri", "must reference at least one sensor")
+	v.Check(!slices.Contains(expression.Sources(), sensor.ID), "uri", "must not reference the sensor itself")
+
+	// values are computed whenever source sensors change, there is nothing to poll or write to
+	v.Check(sensor.Active, "active", "must be true for virtual sensors")
+	v.Check(!sensor.Type.IsWritable(), "type", "must not be a switch for virtual sensors")
+	// expressions compute numbers
+	v.Check(!sensor.Type.ValueKind().IsText(), "type", "must not be enum or text sensor for virtual sensors")
+}
+
+// VirtualSources returns ids of sensors virtual sensor is computed from, nil for other sensors
+func VirtualSources(sensor *Sensor) []uuid.UUID {
+	if sensor.Transport != TransportVirtual {
+		return nil
+	}
+
+	expression, err := ParseExpression(sensor.URI)
+	if err != nil {
+		return nil
+	}
+
+	return expression.Sources()
+}
+
+// SensorSetupOrder orders sensors so listeners of sources are set up before virtual sensors computed from them
+func SensorSetupOrder(sensors []*Sensor) []*Sensor {
+	ordered := make([]*Sensor, 0, len(sensors))
+	added := make(map[uuid.UUID]struct{}, len(sensors))
+	pending := []*Sensor{}
+
+	for _, sensor := range sensors {
+		if sensor.Transport == TransportVirtual {
+			pending = append(pending, sensor)
+			continue
+		}
+		ordered = append(ordered, sensor)
+		added[sensor.ID] = struct{}{}
+	}
+
+	virtual := make(map[uuid.UUID]struct{}, len(pending))
+	for _, sensor := range pending {
+		virtual[sensor.ID] = struct{}{}
+	}
+
+	for len(pending) > 0 {
+		remaining := []*Sensor{}
+
+		for _, sensor := range pending {
+			ready := true
+			for _, source := range VirtualSources(sensor) {
+				_, isVirtual := virtual[source]
+				if _, ok := added[source]; isVirtual && !ok {
+					ready = false
+					break
+				}
+			}
+
+			if !ready {
+				remaining = append(remaining, sensor)
+				continue
+			}
+
+			ordered = append(ordered, sensor)
+			added[sensor.ID] = struct{}{}
+		}
+
+		// sources of the rest reference each other, their listeners fail to start anyway
+		if len(remaining) == len(pending) {
+			ordered = append(ordered, remaining...)
+			break
+		}
+
+		pending = remaining
+	}
+
+	return ordered
+}
+
+// VirtualTransport computes values of virtual sensors from expression (stored in sensor uri)
+// over values of source sensors, recomputing whenever any of them publishes a new value
+type VirtualTransport struct {
+	listeners *SensorListeners
+}
+
+func NewVirtualTransport(listeners *SensorListeners) *VirtualTransport {
+	return &VirtualTransport{listeners: listeners}
+}
+
+func (t *VirtualTransport) Read(ctx context.Context, sensor *Sensor) (SensorValue, error) {
+	return SensorValue{}, ErrTransportUnsupported
+}
+
+func (t *VirtualTransport) Write(ctx context.Context, sensor *Sensor, value SensorValue) error {
+	return ErrTransportUnsupported
+}
+
+func (t *VirtualTransport) Init(ctx context.Context, sensor *Sensor, request InitRequest) error {
+	return ErrTransportUnsupported
+}
+
+// Subscribe subscribes to brokers of source sensors, so their listeners must already be running
+func (t *VirtualTransport) Subscribe(sensor *Sensor, handler ValueHandler) (func(), error) {
+	expression, err := ParseExpression(sensor.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := expression.Sources()
+	listeners := make([]*Listener[SensorValue], len(sources))
+	values := make(map[uuid.UUID]float64, len(sources))
+
+	for i, source := range sources {
+		listener, ok := t.listeners.Get(source)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrMissingSourceListener, source)
+		}
+		listeners[i] = listener
+
+		if current := listener.GetCurrentValue(); len(current) > 0 && !current[len(current)-1].IsText() {
+			values[source] = current[len(current)-1].Number
+		}
+	}
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	channels := make([]reflect.SelectCase, len(sources)+1)
+
+	for i, listener := range listeners {
+		msgCh := listener.GetBroker().Subscribe()
+		channels[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(msgCh)}
+	}
+	channels[len(sources)] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(stopCh)}
+
+	compute := func() {
+		// nothing to compute until every source has a value
+		if len(values) < len(sources) {
+			return
+		}
+
+		value, err := expression.Eval(values)
+		if err != nil {
+			logger.Debug("virtual sensor value", "sensor", sensor.ID, "error", err)
+			return
+		}
+
+		if err = handler(DecimalValue(value), time.Now()); err != nil {
+			logger.Error("virtual sensor value", "sensor", sensor.ID, "error", err)
+		}
+	}
+
+	go func() {
+		defer close(done)
+		// brokers of sources are already stopped when the virtual sensor is restarted because its source was replaced,
+		// Unsubscribe returns immediately then
+		defer func() {
+			for i, listener := range listeners {
+				listener.GetBroker().Unsubscribe(channels[i].Chan.Interface().(chan []SensorValue))
+			}
+		}()
+
+		compute()
+
+		for {
+			i, message, ok := reflect.Select(channels)
+			if i == len(sources) || !ok {
+				return
+			}
+
+			// failed polls of the source are published as nil, last known value is kept
+			window := message.Interface().([]SensorValue)
+			if len(window) == 0 || window[len(window)-1].IsText() {
+				continue
+			}
+
+			values[sources[i]] = window[len(window)-1].Number
+			compute()
+		}
+	}()
+
+	// waits for unsubscribing from sources, so restarted virtual sensor is not subscribed twice
+	return func() {
+		close(stopCh)
+		<-done
+	}, nil
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS sequence_runs;
+DROP TYPE IF EXISTS sequence_run_status;
@@ -0,0 +1,17 @@
+CREATE TYPE sequence_run_status AS ENUM (
+    'running',
+    'completed',
+    'failed'
+    );
+
+CREATE TABLE IF NOT EXISTS sequence_runs (
+    id uuid PRIMARY KEY,
+    sequence_id uuid NOT NULL REFERENCES sequences(id) ON DELETE CASCADE,
+    status sequence_run_status NOT NULL DEFAULT 'running',
+    steps json NOT NULL DEFAULT '[]',
+    error text,
+    started_at timestamptz(0) NOT NULL DEFAULT now(),
+    finished_at timestamptz(0)
+);
+
+CREATE INDEX IF NOT EXISTS sequence_runs_sequence_id_idx ON sequence_runs (sequence_id, started_at DESC);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS sequence_schedules;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS sequence_schedules (
+    id uuid PRIMARY KEY,
+    sequence_id uuid NOT NULL REFERENCES sequences(id) ON DELETE CASCADE,
+    schedule_type varchar(16) NOT NULL,
+    run_at timestamptz(0),
+    cron text NOT NULL DEFAULT '',
+    enabled bool NOT NULL DEFAULT true,
+    skip_at timestamptz(0),
+    last_run_at timestamptz(0),
+    created_at timestamptz(0) NOT NULL DEFAULT now(),
+    version integer NOT NULL DEFAULT 1,
+    CHECK ( (schedule_type = 'once' AND run_at IS NOT NULL) OR (schedule_type = 'cron' AND cron <> '') )
+);
+
+CREATE INDEX IF NOT EXISTS sequence_schedules_sequence_id_idx ON sequence_schedules (sequence_id);
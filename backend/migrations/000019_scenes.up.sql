@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS scenes (
+    id uuid PRIMARY KEY,
+    name varchar(255) NOT NULL,
+    description text NOT NULL DEFAULT '',
+    targets json NOT NULL DEFAULT '[]',
+    created_at timestamptz(0) NOT NULL DEFAULT now(),
+    version integer NOT NULL DEFAULT 1
+);
@@ -0,0 +1,6 @@
+DROP VIEW IF EXISTS sensor_measurements_history;
+DROP TABLE IF EXISTS sensor_measurements_1h;
+DROP TABLE IF EXISTS sensor_measurements_1m;
+
+ALTER TABLE sensors
+DROP COLUMN IF EXISTS retention_days;
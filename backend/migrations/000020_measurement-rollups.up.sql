@@ -0,0 +1,35 @@
+ALTER TABLE sensors
+ADD COLUMN retention_days integer NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS sensor_measurements_1m (
+    sensor_id uuid NOT NULL REFERENCES sensors(id) ON DELETE CASCADE,
+    bucket timestamptz(0) NOT NULL,
+    min_value real NOT NULL,
+    max_value real NOT NULL,
+    avg_value double precision NOT NULL,
+    count integer NOT NULL,
+    PRIMARY KEY (sensor_id, bucket)
+);
+
+CREATE TABLE IF NOT EXISTS sensor_measurements_1h (
+    sensor_id uuid NOT NULL REFERENCES sensors(id) ON DELETE CASCADE,
+    bucket timestamptz(0) NOT NULL,
+    min_value real NOT NULL,
+    max_value real NOT NULL,
+    avg_value double precision NOT NULL,
+    count integer NOT NULL,
+    PRIMARY KEY (sensor_id, bucket)
+);
+
+-- whole history of every sensor, raw rows for recent data and aggregates for older one.
+-- Ranges of the three tables do not overlap, since rows are moved (not copied) when rolled up
+CREATE OR REPLACE VIEW sensor_measurements_history AS
+    SELECT sensor_id, measured_at, measured_value::double precision AS avg_value,
+        measured_value AS min_value, measured_value AS max_value, 1 AS count
+    FROM sensor_measurements
+    UNION ALL
+    SELECT sensor_id, bucket, avg_value, min_value, max_value, count
+    FROM sensor_measurements_1m
+    UNION ALL
+    SELECT sensor_id, bucket, avg_value, min_value, max_value, count
+    FROM sensor_measurements_1h;
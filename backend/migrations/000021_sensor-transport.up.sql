@@ -0,0 +1,2 @@
+ALTER TABLE sensors
+ADD COLUMN transport varchar(32) NOT NULL DEFAULT 'http';
@@ -0,0 +1,2 @@
+ALTER TABLE sensors
+ADD COLUMN mqtt_options jsonb;
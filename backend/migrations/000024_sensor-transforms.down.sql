@@ -0,0 +1,6 @@
+ALTER TABLE sensor_measurements
+DROP COLUMN IF EXISTS raw_value;
+
+ALTER TABLE sensors
+DROP COLUMN IF EXISTS transforms,
+DROP COLUMN IF EXISTS store_raw;
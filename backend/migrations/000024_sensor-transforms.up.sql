@@ -0,0 +1,7 @@
+ALTER TABLE sensors
+ADD COLUMN transforms jsonb,
+ADD COLUMN store_raw boolean NOT NULL DEFAULT false;
+
+-- value received from the sensor before its transforms, only stored if store_raw is set
+ALTER TABLE sensor_measurements
+ADD COLUMN raw_value real;
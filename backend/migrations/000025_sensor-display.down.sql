@@ -0,0 +1,6 @@
+ALTER TABLE sensors
+DROP COLUMN IF EXISTS unit,
+DROP COLUMN IF EXISTS decimals,
+DROP COLUMN IF EXISTS min_value,
+DROP COLUMN IF EXISTS max_value,
+DROP COLUMN IF EXISTS icon;
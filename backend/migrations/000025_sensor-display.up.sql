@@ -0,0 +1,6 @@
+ALTER TABLE sensors
+ADD COLUMN unit varchar(16) NOT NULL DEFAULT '',
+ADD COLUMN decimals smallint,
+ADD COLUMN min_value double precision,
+ADD COLUMN max_value double precision,
+ADD COLUMN icon varchar(64) NOT NULL DEFAULT '';
@@ -0,0 +1,15 @@
+DROP INDEX IF EXISTS sensors_tags_idx;
+
+ALTER TABLE sequences
+DROP COLUMN IF EXISTS area_id,
+DROP COLUMN IF EXISTS tags;
+
+ALTER TABLE rules
+DROP COLUMN IF EXISTS area_id,
+DROP COLUMN IF EXISTS tags;
+
+ALTER TABLE sensors
+DROP COLUMN IF EXISTS area_id,
+DROP COLUMN IF EXISTS tags;
+
+DROP TABLE IF EXISTS areas;
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS areas (
+    id uuid PRIMARY KEY,
+    name varchar(64) NOT NULL,
+    kind varchar(16) NOT NULL,
+    parent_id uuid REFERENCES areas(id) ON DELETE RESTRICT,
+    created_at timestamptz(0) NOT NULL DEFAULT now(),
+    version integer NOT NULL DEFAULT 1
+);
+
+ALTER TABLE sensors
+ADD COLUMN area_id uuid REFERENCES areas(id) ON DELETE SET NULL,
+ADD COLUMN tags text[] NOT NULL DEFAULT '{}';
+
+ALTER TABLE rules
+ADD COLUMN area_id uuid REFERENCES areas(id) ON DELETE SET NULL,
+ADD COLUMN tags text[] NOT NULL DEFAULT '{}';
+
+ALTER TABLE sequences
+ADD COLUMN area_id uuid REFERENCES areas(id) ON DELETE SET NULL,
+ADD COLUMN tags text[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS sensors_tags_idx ON sensors USING GIN (tags);
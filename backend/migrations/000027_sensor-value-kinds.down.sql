@@ -0,0 +1,20 @@
+DROP VIEW IF EXISTS sensor_measurements_history;
+
+CREATE VIEW sensor_measurements_history AS
+    SELECT sensor_id, measured_at, measured_value::double precision AS avg_value,
+        measured_value AS min_value, measured_value AS max_value, 1 AS count
+    FROM sensor_measurements
+    UNION ALL
+    SELECT sensor_id, bucket, avg_value, min_value, max_value, count
+    FROM sensor_measurements_1m
+    UNION ALL
+    SELECT sensor_id, bucket, avg_value, min_value, max_value, count
+    FROM sensor_measurements_1h;
+
+ALTER TABLE sensor_measurements
+DROP COLUMN IF EXISTS text_value;
+
+ALTER TABLE sensors
+DROP COLUMN IF EXISTS options;
+
+-- values can not be removed from enum type, sensors of the new types have to be deleted by hand
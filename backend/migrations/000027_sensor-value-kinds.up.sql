@@ -0,0 +1,23 @@
+ALTER TYPE sensor_type ADD VALUE IF NOT EXISTS 'integer_sensor';
+ALTER TYPE sensor_type ADD VALUE IF NOT EXISTS 'enum_sensor';
+ALTER TYPE sensor_type ADD VALUE IF NOT EXISTS 'enum_switch';
+ALTER TYPE sensor_type ADD VALUE IF NOT EXISTS 'text_sensor';
+
+ALTER TABLE sensors
+ADD COLUMN options text[] NOT NULL DEFAULT '{}';
+
+-- option of enum sensors and value of text sensors, measured_value keeps index of the option (0 for text)
+ALTER TABLE sensor_measurements
+ADD COLUMN text_value text;
+
+-- text values are not aggregated, so rolled up periods have none
+CREATE OR REPLACE VIEW sensor_measurements_history AS
+    SELECT sensor_id, measured_at, measured_value::double precision AS avg_value,
+        measured_value AS min_value, measured_value AS max_value, 1 AS count, text_value
+    FROM sensor_measurements
+    UNION ALL
+    SELECT sensor_id, bucket, avg_value, min_value, max_value, count, NULL
+    FROM sensor_measurements_1m
+    UNION ALL
+    SELECT sensor_id, bucket, avg_value, min_value, max_value, count, NULL
+    FROM sensor_measurements_1h;
@@ -0,0 +1 @@
+ALTER TABLE sequence_schedules DROP COLUMN IF EXISTS timezone;
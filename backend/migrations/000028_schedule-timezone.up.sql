@@ -0,0 +1,2 @@
+-- schedules used to be evaluated in server local time, which is UTC in containers
+ALTER TABLE sequence_schedules ADD COLUMN timezone text NOT NULL DEFAULT 'UTC';